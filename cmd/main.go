@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"example.com/imageProc/interface/shttp"
 	appsvc "example.com/imageProc/internal/app/service"
 	"example.com/imageProc/internal/domain/service"
@@ -8,7 +10,23 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultJobWorkers = 2
+	jobPollInterval   = time.Second
+	// sweepInterval is how often expired resumable uploads, redeemed upload
+	// tokens and finished jobs are deleted
+	sweepInterval = time.Hour
+	// defaultUsageExportInterval is how often the usage of every tenant is
+	// exported for billing
+	defaultUsageExportInterval = 24 * time.Hour
 )
 
 func main() {
@@ -18,23 +36,229 @@ func main() {
 	}
 	baseDir := os.Getenv("StorageDir")
 	localImageStorageSvc := appsvc.NewLocalImageStorageService(baseDir)
-	vipsImageProcessorSvc := appsvc.NewVipsImageProcessorService()
-	imgSvc := domainsvc.NewImageService(localImageStorageSvc, vipsImageProcessorSvc)
+	fontDir := os.Getenv("FontDir")
+	if fontDir == "" {
+		log.Printf("FontDir is not set, text overlays are rendered with the system fonts only")
+	}
+	vipsImageProcessorSvc, err := appsvc.NewVipsImageProcessorService(fontDir)
+	if err != nil {
+		panic(err)
+	}
+	tenantConfigSvc, err := appsvc.NewFileTenantConfigService(os.Getenv("TenantConfigFile"))
+	if err != nil {
+		panic(err)
+	}
+	jobDir := os.Getenv("JobDir")
+	if jobDir == "" {
+		jobDir = filepath.Join(baseDir, "jobs")
+	}
+	jobQueue, err := appsvc.NewFileJobQueue(jobDir)
+	if err != nil {
+		panic(err)
+	}
+	webhookLogDir := os.Getenv("WebhookLogDir")
+	if webhookLogDir == "" {
+		webhookLogDir = filepath.Join(baseDir, "webhooks")
+	}
+	webhookSvc, err := appsvc.NewHttpWebhookService(webhookLogDir)
+	if err != nil {
+		panic(err)
+	}
+	remoteFetchMaxBytes, err := strconv.ParseInt(os.Getenv("RemoteFetchMaxBytes"), 10, 64)
+	if err != nil || remoteFetchMaxBytes < 1 {
+		remoteFetchMaxBytes = appsvc.DefaultRemoteFetchSize
+	}
+	var remoteFetchAllowedNetworks []string
+	if networks := os.Getenv("RemoteFetchAllowedNetworks"); networks != "" {
+		remoteFetchAllowedNetworks = strings.Split(networks, ",")
+	}
+	remoteFetchSvc, err := appsvc.NewHttpRemoteFetchService(remoteFetchMaxBytes, remoteFetchAllowedNetworks)
+	if err != nil {
+		panic(err)
+	}
+	uploadDir := os.Getenv("UploadDir")
+	if uploadDir == "" {
+		uploadDir = filepath.Join(baseDir, "uploads")
+	}
+	uploadStore, err := appsvc.NewFileUploadStore(uploadDir)
+	if err != nil {
+		panic(err)
+	}
+	uploadTokenDir := os.Getenv("UploadTokenDir")
+	if uploadTokenDir == "" {
+		uploadTokenDir = filepath.Join(baseDir, "upload-tokens")
+	}
+	uploadTokenSvc, err := appsvc.NewFileUploadTokenService(secretFromEnv("UploadTokenSecret"), uploadTokenDir)
+	if err != nil {
+		panic(err)
+	}
+	urlSigner, err := appsvc.NewHmacUrlSigner(secretFromEnv("UrlSigningSecret"))
+	if err != nil {
+		panic(err)
+	}
+	imgSvc := domainsvc.NewImageService(localImageStorageSvc, vipsImageProcessorSvc, tenantConfigSvc, jobQueue, webhookSvc,
+		remoteFetchSvc, uploadStore, uploadTokenSvc, urlSigner)
 
 	httpSvc := shttp.NewHttpService(imgSvc)
 
+	usageExportDir := os.Getenv("UsageExportDir")
+	if usageExportDir == "" {
+		usageExportDir = filepath.Join(baseDir, "usage")
+	}
+	usageExporter, err := appsvc.NewFileUsageExporter(usageExportDir)
+	if err != nil {
+		panic(err)
+	}
+	usageExportInterval, err := time.ParseDuration(os.Getenv("UsageExportInterval"))
+	if err != nil || usageExportInterval <= 0 {
+		usageExportInterval = defaultUsageExportInterval
+	}
+
+	authSvc, err := appsvc.NewAuthService(os.Getenv("ApiKeyFile"), []byte(os.Getenv("JwtSecret")))
+	if err != nil {
+		panic(err)
+	}
+	authDisabled, _ := strconv.ParseBool(os.Getenv("AuthDisabled"))
+
 	vips.Startup(nil)
 	defer vips.Shutdown()
 
+	workers, err := strconv.Atoi(os.Getenv("JobWorkers"))
+	if err != nil || workers < 1 {
+		workers = defaultJobWorkers
+	}
+	go appsvc.RunJobWorkers(context.Background(), jobQueue, workers, jobPollInterval, imgSvc.HandleJob)
+	go sweepExpired(jobQueue, uploadStore, uploadTokenSvc)
+	go exportUsage(imgSvc, usageExporter, usageExportInterval)
+
 	e := echo.New()
 	e.Use(middleware.Logger())
+	if authDisabled {
+		log.Printf("AuthDisabled is set, tenants are taken from the query of unauthenticated requests")
+	} else {
+		// images are served to anonymous requests as far as their visibility allows
+		e.Use(shttp.Authenticate(domainsvc.NewAuthService(authSvc), "/:imgName", "/:imgName/original"))
+	}
 
+	e.GET("/images/similar", func(c echo.Context) error {
+		return httpSvc.FindSimilarImages(c)
+	})
+	e.POST("/images/reprocess", func(c echo.Context) error {
+		return httpSvc.ReprocessImages(c)
+	})
+	e.GET("/jobs/:id", func(c echo.Context) error {
+		return httpSvc.GetJob(c)
+	})
+	e.GET("/webhooks/deliveries", func(c echo.Context) error {
+		return httpSvc.GetWebhookDeliveries(c)
+	})
+	e.GET("/usage", func(c echo.Context) error {
+		return httpSvc.GetUsage(c)
+	})
 	e.GET("/:imgName", func(c echo.Context) error {
 		return httpSvc.GetImage(c)
 	})
+	e.GET("/:imgName/original", func(c echo.Context) error {
+		return httpSvc.GetOriginalImage(c)
+	})
+	e.GET("/:imgName/placeholder", func(c echo.Context) error {
+		return httpSvc.GetPlaceholder(c)
+	})
+	e.GET("/:imgName/colors", func(c echo.Context) error {
+		return httpSvc.GetColors(c)
+	})
+	e.GET("/:imgName/metadata", func(c echo.Context) error {
+		return httpSvc.GetMetadata(c)
+	})
+	e.GET("/:imgName/srcset", func(c echo.Context) error {
+		return httpSvc.GetSrcset(c)
+	})
+	e.GET("/:imgName/eager", func(c echo.Context) error {
+		return httpSvc.GetEagerStatus(c)
+	})
+	e.GET("/:imgName/visibility", func(c echo.Context) error {
+		return httpSvc.GetVisibility(c)
+	})
+	e.PUT("/:imgName/visibility", func(c echo.Context) error {
+		return httpSvc.SetVisibility(c)
+	})
+	e.GET("/:imgName/signed-url", func(c echo.Context) error {
+		return httpSvc.GetSignedUrl(c)
+	})
+	e.DELETE("/:imgName", func(c echo.Context) error {
+		return httpSvc.PurgeImage(c)
+	})
 	e.POST("/upload", func(c echo.Context) error {
 		return httpSvc.UploadImage(c)
 	})
+	e.POST("/upload/batch", func(c echo.Context) error {
+		return httpSvc.UploadBatch(c)
+	})
+	e.POST("/upload-tokens", func(c echo.Context) error {
+		return httpSvc.IssueUploadToken(c)
+	})
+	e.OPTIONS("/uploads", func(c echo.Context) error {
+		return httpSvc.TusOptions(c)
+	})
+	e.POST("/uploads", func(c echo.Context) error {
+		return httpSvc.CreateUpload(c)
+	})
+	e.HEAD("/uploads/:id", func(c echo.Context) error {
+		return httpSvc.HeadUpload(c)
+	})
+	e.PATCH("/uploads/:id", func(c echo.Context) error {
+		return httpSvc.PatchUpload(c)
+	})
+	e.DELETE("/uploads/:id", func(c echo.Context) error {
+		return httpSvc.TerminateUpload(c)
+	})
 
 	e.Logger.Fatal(e.Start(":2380"))
 }
+
+func sweepExpired(jobQueue appsvc.JobQueueInterface, uploadStore appsvc.ResumableUploadStoreInterface,
+	uploadTokenSvc appsvc.UploadTokenServiceInterface) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := jobQueue.DeleteExpired(); err != nil {
+			log.Printf("error deleting finished jobs: %v", err)
+		}
+		if _, err := uploadStore.DeleteExpired(); err != nil {
+			log.Printf("error deleting expired uploads: %v", err)
+		}
+		if _, err := uploadTokenSvc.DeleteExpired(); err != nil {
+			log.Printf("error deleting expired upload tokens: %v", err)
+		}
+	}
+}
+
+func exportUsage(imgSvc domainsvc.ImageServiceInterface, usageExporter appsvc.UsageExporterInterface, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for takenAt := range ticker.C {
+		snapshots, err := imgSvc.SnapshotUsage(context.Background())
+		if err != nil {
+			log.Printf("error taking usage snapshots: %v", err)
+			continue
+		}
+		if _, err = usageExporter.Export(takenAt, snapshots); err != nil {
+			log.Printf("error exporting usage: %v", err)
+		}
+	}
+}
+
+// secretFromEnv returns the secret set in the environment variable, or else a
+// random secret: what it signs then only verifies on this instance until it
+// restarts.
+func secretFromEnv(name string) []byte {
+	secret := []byte(os.Getenv(name))
+	if len(secret) == 0 {
+		log.Printf("%s is not set, using a random secret", name)
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+	return secret
+}
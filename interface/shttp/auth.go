@@ -0,0 +1,117 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"example.com/imageProc/internal/domain/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	ApiKeyHeader = "X-Api-Key"
+	// PrincipalKey is the echo context key the authenticated principal is set at
+	PrincipalKey = "principal"
+)
+
+// Authenticate authenticates requests with an api key, sent in the X-Api-Key
+// header, or with a JWT sent as a bearer token. The principal is set on the
+// context and the request is scoped to its tenant, see requestTenant; a
+// tenant-code or org-code naming another tenant is refused. GET and HEAD
+// requests need the read scope, every other request the write scope.
+//
+// Uploads carrying an upload token are let through, the token is their
+// authentication, and so are OPTIONS requests which browsers send without
+// credentials. GET and HEAD requests to the optional routes are let through
+// unauthenticated when they carry no credentials, their handlers decide what to
+// serve them. Other methods on the same routes always need credentials.
+func Authenticate(authSvc domainsvc.AuthServiceInterface, optionalRoutes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method == http.MethodOptions || carriesUploadToken(c) {
+				return next(c)
+			}
+			if isRead(req) && slices.Contains(optionalRoutes, c.Path()) && !carriesCredentials(req) {
+				return next(c)
+			}
+
+			principal, err := authenticate(authSvc, req)
+			if err != nil {
+				if errors.Is(err, domainsvc.ErrUnauthenticated) {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+					return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+				}
+				return echo.NewHTTPError(http.StatusInternalServerError, "error authenticating").SetInternal(err)
+			}
+			scope := domain.Scope_WRITE
+			if isRead(req) {
+				scope = domain.Scope_READ
+			}
+			if !principal.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "the credentials lack the "+scope.String()+" scope")
+			}
+
+			tenantParams := map[string]string{
+				"tenant-code": principal.TenantOpts.TenantCode,
+				"org-code":    principal.TenantOpts.OrgCode,
+			}
+			for param, value := range tenantParams {
+				if given := c.QueryParam(param); given != "" && given != value {
+					return echo.NewHTTPError(http.StatusForbidden, "the credentials do not grant access to "+param+" "+given)
+				}
+			}
+			c.Set(PrincipalKey, principal)
+			return next(c)
+		}
+	}
+}
+
+func authenticate(authSvc domainsvc.AuthServiceInterface, req *http.Request) (domain.Principal, error) {
+	if apiKey := req.Header.Get(ApiKeyHeader); apiKey != "" {
+		return authSvc.AuthenticateApiKey(context.Background(), apiKey)
+	}
+	scheme, token, ok := strings.Cut(req.Header.Get(echo.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return domain.Principal{}, domainsvc.ErrUnauthenticated
+	}
+	return authSvc.AuthenticateJwt(context.Background(), strings.TrimSpace(token))
+}
+
+func isRead(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+func carriesCredentials(req *http.Request) bool {
+	return req.Header.Get(ApiKeyHeader) != "" || req.Header.Get(echo.HeaderAuthorization) != ""
+}
+
+// carriesUploadToken only looks at the header, parsing the body of a request
+// that is not authenticated yet would let anyone make the server read it whole.
+func carriesUploadToken(c echo.Context) bool {
+	req := c.Request()
+	return req.Method == http.MethodPost && c.Path() == "/upload" && req.Header.Get(UploadTokenHeader) != ""
+}
+
+// requestTenant returns the tenant a request is scoped to. Authenticated
+// requests are scoped to the tenant of their principal. Only anonymous requests,
+// i.e. reads of the optional routes or any request with authentication
+// disabled, name their tenant with the tenant-code and org-code of the query.
+func requestTenant(c echo.Context) (domain.TenantOpts, error) {
+	tenantOpts := domain.TenantOpts{
+		TenantCode: c.QueryParam("tenant-code"),
+		OrgCode:    c.QueryParam("org-code"),
+	}
+	if principal, ok := c.Get(PrincipalKey).(domain.Principal); ok {
+		tenantOpts = principal.TenantOpts
+	} else if tenantOpts.TenantCode == "" || tenantOpts.OrgCode == "" {
+		return domain.TenantOpts{}, echo.NewHTTPError(http.StatusBadRequest, "tenant-code and org-code are required")
+	}
+	if err := tenantOpts.Validate(); err != nil {
+		return domain.TenantOpts{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return tenantOpts, nil
+}
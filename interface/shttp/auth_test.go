@@ -0,0 +1,199 @@
+package shttp
+
+import (
+	"errors"
+	appsvc "example.com/imageProc/internal/app/service"
+	"example.com/imageProc/internal/domain"
+	"example.com/imageProc/internal/domain/service"
+	"example.com/imageProc/internal/mock"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuthServer serves the routes behind Authenticate with a handler echoing
+// the tenant the request was scoped to and its principal.
+func newAuthServer(authSvc appsvc.AuthServiceInterface) *echo.Echo {
+	e := echo.New()
+	e.Use(Authenticate(domainsvc.NewAuthService(authSvc), "/:imgName"))
+	echoTenant := func(c echo.Context) error {
+		principal, _ := c.Get(PrincipalKey).(domain.Principal)
+		tenantOpts, err := requestTenant(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, strings.Join([]string{
+			tenantOpts.TenantCode, tenantOpts.OrgCode, principal.Subject,
+		}, " "))
+	}
+	e.GET("/:imgName", echoTenant)
+	e.DELETE("/:imgName", echoTenant)
+	e.GET("/usage", echoTenant)
+	e.POST("/upload", echoTenant)
+	e.POST("/images/reprocess", echoTenant)
+	e.OPTIONS("/uploads", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	return e
+}
+
+func serve(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthenticate(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	readWrite := domain.Principal{Subject: "key1", TenantOpts: tenantOpts,
+		Scopes: []domain.Scope{domain.Scope_READ, domain.Scope_WRITE}}
+	readOnly := domain.Principal{Subject: "key2", TenantOpts: tenantOpts, Scopes: []domain.Scope{domain.Scope_READ}}
+
+	newAuthService := func() *mock.AuthService {
+		authSvc := new(mock.AuthService)
+		authSvc.On("AuthenticateApiKey", "read-write").Return(readWrite, nil)
+		authSvc.On("AuthenticateApiKey", "read-only").Return(readOnly, nil)
+		authSvc.On("AuthenticateApiKey", "unknown").Return(domain.Principal{}, appsvc.ErrInvalidCredentials)
+		authSvc.On("AuthenticateApiKey", "broken").Return(domain.Principal{}, errors.New("disk error"))
+		return authSvc
+	}
+
+	t.Run("the request is scoped to the tenant of the credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/usage", nil)
+		req.Header.Set(ApiKeyHeader, "read-write")
+
+		rec := serve(newAuthServer(newAuthService()), req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "tenant org key1", rec.Body.String())
+	})
+
+	testCases := []struct {
+		name         string
+		method       string
+		target       string
+		apiKey       string
+		bearer       string
+		expectedCode int
+	}{
+		{name: "another tenant is refused", method: http.MethodGet, target: "/usage?tenant-code=other&org-code=org",
+			apiKey: "read-write", expectedCode: http.StatusForbidden},
+		{name: "another org is refused", method: http.MethodGet, target: "/usage?tenant-code=tenant&org-code=other",
+			apiKey: "read-write", expectedCode: http.StatusForbidden},
+		{name: "a read only key can not write", method: http.MethodPost, target: "/images/reprocess",
+			apiKey: "read-only", expectedCode: http.StatusForbidden},
+		{name: "a read only key can read", method: http.MethodGet, target: "/usage",
+			apiKey: "read-only", expectedCode: http.StatusOK},
+		{name: "missing credentials are refused", method: http.MethodGet, target: "/usage",
+			expectedCode: http.StatusUnauthorized},
+		{name: "an unknown api key is refused", method: http.MethodGet, target: "/usage",
+			apiKey: "unknown", expectedCode: http.StatusUnauthorized},
+		{name: "a malformed authorization header is refused", method: http.MethodGet, target: "/usage",
+			bearer: "Basic dXNlcjpwYXNz", expectedCode: http.StatusUnauthorized},
+		{name: "a failing lookup is an internal error", method: http.MethodGet, target: "/usage",
+			apiKey: "broken", expectedCode: http.StatusInternalServerError},
+		{name: "optional routes with invalid credentials are refused", method: http.MethodGet, target: "/cat.jpg",
+			apiKey: "unknown", expectedCode: http.StatusUnauthorized},
+		{name: "optional routes need credentials to write", method: http.MethodDelete,
+			target: "/cat.jpg?tenant-code=other&org-code=org", expectedCode: http.StatusUnauthorized},
+		{name: "anonymous reads of optional routes name their tenant", method: http.MethodGet, target: "/cat.jpg",
+			expectedCode: http.StatusBadRequest},
+		{name: "anonymous reads naming an invalid tenant are refused", method: http.MethodGet,
+			target: "/cat.jpg?tenant-code=a-b&org-code=c", expectedCode: http.StatusBadRequest},
+		{name: "uploads without a token need credentials", method: http.MethodPost, target: "/upload",
+			expectedCode: http.StatusUnauthorized},
+		{name: "options requests need no credentials", method: http.MethodOptions, target: "/uploads",
+			expectedCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			if tc.apiKey != "" {
+				req.Header.Set(ApiKeyHeader, tc.apiKey)
+			}
+			if tc.bearer != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.bearer)
+			}
+
+			rec := serve(newAuthServer(newAuthService()), req)
+
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			if tc.expectedCode == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+
+	t.Run("optional routes are served anonymously without credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/cat.jpg?tenant-code=tenant&org-code=org", nil)
+
+		rec := serve(newAuthServer(newAuthService()), req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "tenant org ", rec.Body.String())
+	})
+
+	t.Run("uploads carrying an upload token are let through", func(t *testing.T) {
+		authSvc := newAuthService()
+		req := httptest.NewRequest(http.MethodPost, "/upload?tenant-code=tenant&org-code=org", nil)
+		req.Header.Set(UploadTokenHeader, "signed")
+
+		rec := serve(newAuthServer(authSvc), req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		authSvc.AssertNotCalled(t, "AuthenticateApiKey", "read-write")
+	})
+
+	t.Run("the body of uploads without credentials is left unread", func(t *testing.T) {
+		body := strings.NewReader("--b\r\nContent-Disposition: form-data; name=\"upload-token\"\r\n\r\nsigned\r\n--b--\r\n")
+		req := httptest.NewRequest(http.MethodPost, "/upload?tenant-code=tenant&org-code=org", body)
+		req.Header.Set(echo.HeaderContentType, "multipart/form-data; boundary=b")
+
+		rec := serve(newAuthServer(newAuthService()), req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, body.Size(), int64(body.Len()))
+	})
+}
+
+func TestAuthenticateJwt(t *testing.T) {
+	secret := []byte("secret")
+	authSvc, err := appsvc.NewAuthService("", secret)
+	if err != nil {
+		t.Fatalf("error creating auth service: %v", err)
+	}
+	sign := func(expiresAt time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": "user1", "tenant": "tenant", "org": "org", "scope": "read", "exp": expiresAt.Unix(),
+		}).SignedString(secret)
+		if err != nil {
+			t.Fatalf("error signing token: %v", err)
+		}
+		return token
+	}
+
+	t.Run("a valid token is accepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/usage", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+sign(time.Now().Add(time.Hour)))
+
+		rec := serve(newAuthServer(authSvc), req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "tenant org user1", rec.Body.String())
+	})
+
+	t.Run("an expired token is refused", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/usage", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+sign(time.Now().Add(-time.Minute)))
+
+		rec := serve(newAuthServer(authSvc), req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "Bearer", rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+}
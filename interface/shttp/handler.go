@@ -2,19 +2,50 @@ package shttp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"example.com/imageProc/internal/domain"
 	"example.com/imageProc/internal/domain/service"
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"html"
+	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type HttpServiceInterface interface {
 	GetImage(c echo.Context) error
+	GetOriginalImage(c echo.Context) error
+	GetPlaceholder(c echo.Context) error
+	GetColors(c echo.Context) error
+	FindSimilarImages(c echo.Context) error
+	GetMetadata(c echo.Context) error
+	GetSrcset(c echo.Context) error
+	GetEagerStatus(c echo.Context) error
+	GetVisibility(c echo.Context) error
+	SetVisibility(c echo.Context) error
+	GetSignedUrl(c echo.Context) error
 	UploadImage(c echo.Context) error
+	UploadBatch(c echo.Context) error
+	IssueUploadToken(c echo.Context) error
+	PurgeImage(c echo.Context) error
+	ReprocessImages(c echo.Context) error
+	GetJob(c echo.Context) error
+	GetWebhookDeliveries(c echo.Context) error
+	GetUsage(c echo.Context) error
+	TusOptions(c echo.Context) error
+	CreateUpload(c echo.Context) error
+	HeadUpload(c echo.Context) error
+	PatchUpload(c echo.Context) error
+	TerminateUpload(c echo.Context) error
 }
 
 type httpService struct {
@@ -25,6 +56,86 @@ var (
 	ErrInvalidAspectRatio = errors.New("invalid aspect ratio")
 	ErrInvalidWidth       = errors.New("invalid width")
 	ErrInvalidHeight      = errors.New("invalid height")
+	ErrInvalidWatermark   = errors.New("invalid watermark")
+	ErrInvalidText        = errors.New("invalid text")
+	ErrInvalidFontSize    = errors.New("invalid font size")
+	ErrInvalidColor       = errors.New("invalid color")
+	ErrInvalidGravity     = errors.New("invalid gravity")
+	ErrInvalidTextWidth   = errors.New("invalid text width")
+	ErrInvalidPadding     = errors.New("invalid padding")
+	ErrInvalidBackground  = errors.New("invalid background")
+	ErrInvalidRadius      = errors.New("invalid radius")
+	ErrInvalidMask        = errors.New("invalid mask")
+	ErrInvalidFrame       = errors.New("invalid frame")
+	ErrInvalidPage        = errors.New("invalid page")
+	ErrInvalidDensity     = errors.New("invalid density")
+	ErrInvalidPlaceholder = errors.New("invalid placeholder type")
+	ErrInvalidColorCount  = errors.New("invalid color count")
+	ErrInvalidDistance    = errors.New("invalid distance")
+	ErrInvalidDpr         = errors.New("invalid dpr")
+	ErrInvalidFormat      = errors.New("invalid format")
+	ErrInvalidWidths      = errors.New("invalid widths")
+	ErrInvalidMaxBytes    = errors.New("invalid maxBytes")
+	ErrInvalidImages      = errors.New("invalid images")
+	ErrInvalidLimit       = errors.New("invalid limit")
+	ErrInvalidExpiry      = errors.New("invalid expiresIn")
+	ErrInvalidVisibility  = errors.New("invalid visibility")
+	ErrInvalidSignature   = errors.New("invalid expires or signature")
+)
+
+const (
+	minDpr          = 1
+	maxDpr          = 4
+	maxDensity      = 1200
+	maxSrcsetWidths = 12
+	// maxDimension bounds the output dimensions a request can ask for
+	maxDimension   = 8192
+	maxSrcsetWidth = maxDimension
+	// maxPadding bounds the padding added on each side of an image
+	maxPadding = maxDimension / 2
+	// maxTextLength (in characters) and maxFontSize bound the text rendered on an image
+	maxTextLength = 500
+	maxFontSize   = 1000
+	// minMaxBytes is the smallest byte budget worth searching, below it not even
+	// a thumbnail fits
+	minMaxBytes       = 1024
+	maxPaletteColors  = 16
+	defaultColorCount = 5
+	// defaultDeliveryLimit and maxDeliveryLimit bound the webhook deliveries listed at once
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+	// maxRemoteUploadBatch bounds the urls of a single remote upload request
+	maxRemoteUploadBatch = 50
+	maxRemoteUploadBody  = 1 << 20
+	// maxBatchUploadFiles and maxBatchUploadBytes bound a batch upload, counting
+	// the files extracted from archives at their uncompressed size
+	maxBatchUploadFiles = 1000
+	maxBatchUploadBytes = 1 << 30
+	// batchUploadMemory is how much of a batch is held in memory while parsing
+	// the request, the rest goes to temporary files
+	batchUploadMemory = 32 << 20
+	// defaultUploadTokenExpiry and maxUploadTokenExpiry bound the lifetime of
+	// upload tokens in seconds, they are meant to be used right after issuing
+	defaultUploadTokenExpiry   = 15 * 60
+	maxUploadTokenExpiry       = 24 * 60 * 60
+	defaultUploadTokenMaxBytes = 20 << 20
+	maxUploadTokenMaxBytes     = 1 << 30
+	maxUploadTokenBody         = 1 << 16
+	UploadTokenHeader          = "X-Upload-Token"
+	// defaultSignedUrlExpiry and maxSignedUrlExpiry bound the lifetime of
+	// signed urls in seconds
+	defaultSignedUrlExpiry = 60 * 60
+	maxSignedUrlExpiry     = 7 * 24 * 60 * 60
+	// defaultSimilarityDistance catches recompressed and slightly cropped copies
+	// while staying clear of merely similar photos
+	defaultSimilarityDistance = 10
+	// svgContentSecurityPolicy keeps original svg documents from running scripts
+	// or loading anything should one slip through sanitization
+	svgContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; sandbox"
+	// acceptClientHints asks browsers to send the hints GetImage sizes images by
+	acceptClientHints = "Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width, Save-Data"
+	// varyImage lists every request header the served image depends on
+	varyImage = "Accept, Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width, Save-Data"
 )
 
 func (h httpService) GetImage(c echo.Context) error {
@@ -34,51 +145,549 @@ func (h httpService) GetImage(c echo.Context) error {
 	ar := queryPrms.Get("ar")
 	width := queryPrms.Get("width")
 	height := queryPrms.Get("height")
-	tenantCode := queryPrms.Get("tenant-code")
-	orgCode := queryPrms.Get("org-code")
+
+	c.Response().Header().Set("Accept-CH", acceptClientHints)
+	c.Response().Header().Set("Vary", varyImage)
 
 	getImgOpts, err := prepareGetImageOpts(width, height, ar)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-
-	if tenantCode == "" || orgCode == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "tenant-code and org-code are required")
+	getImgOpts, err = prepareDeviceOpts(getImgOpts, queryPrms, c.Request().Header)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if watermark := queryPrms.Get("watermark"); watermark != "" {
+		validWatermark, err := strconv.ParseBool(watermark)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidWatermark.Error())
+		}
+		getImgOpts = getImgOpts.SetWatermark(validWatermark)
+	}
+	textOpts, err := prepareTextOpts(queryPrms)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if textOpts != nil {
+		getImgOpts = getImgOpts.SetText(*textOpts)
+	}
+	shapeOpts, err := prepareShapeOpts(queryPrms)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if shapeOpts != nil {
+		getImgOpts = getImgOpts.SetShape(*shapeOpts)
+	}
+	if frame := queryPrms.Get("frame"); frame != "" {
+		validFrame, err := strconv.Atoi(frame)
+		if err != nil || validFrame < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidFrame.Error())
+		}
+		getImgOpts = getImgOpts.SetFrame(validFrame)
+	}
+	if page := queryPrms.Get("page"); page != "" {
+		validPage, err := strconv.Atoi(page)
+		if err != nil || validPage < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidPage.Error())
+		}
+		getImgOpts = getImgOpts.SetPage(validPage)
+	}
+	if density := queryPrms.Get("density"); density != "" {
+		validDensity, err := strconv.Atoi(density)
+		if err != nil || validDensity < 1 || validDensity > maxDensity {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidDensity.Error())
+		}
+		getImgOpts = getImgOpts.SetDensity(validDensity)
+	}
+	if maxBytes := queryPrms.Get("maxBytes"); maxBytes != "" {
+		validMaxBytes, err := strconv.Atoi(maxBytes)
+		if err != nil || validMaxBytes < minMaxBytes {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidMaxBytes.Error())
+		}
+		getImgOpts = getImgOpts.SetMaxBytes(validMaxBytes)
+	}
+	access, err := imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-	tenantOpts := domain.TenantOpts{
-		TenantCode: queryPrms.Get("tenant-code"),
-		OrgCode:    queryPrms.Get("org-code"),
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
 	}
 
-	// get image type from accepts header
-	acceptHeader := c.Request().Header.Get("accept")
-	_imgType := distinguishImageType(strings.Split(acceptHeader, ","))
+	// get image type from the format parameter, or else from accepts header
+	_imgType, err := parseOutputFormat(queryPrms.Get("format"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if _imgType == domain.ImageType_AUTO {
+		acceptHeader := c.Request().Header.Get("accept")
+		_imgType = distinguishImageType(strings.Split(acceptHeader, ","))
+	}
 
 	opts := domainsvc.NewServiceGetImageOpts()
-	opts = getImgOpts.SetFormat(_imgType).SetTenantOpts(tenantOpts).SetName(imgName)
+	opts = getImgOpts.SetFormat(_imgType).SetTenantOpts(tenantOpts).SetName(imgName).SetAccess(access)
 
-	image, err := h.imageSvc.GetImage(context.Background(), opts)
+	image, compression, err := h.imageSvc.GetCompressedImage(c.Request().Context(), opts)
 	if err != nil {
 		if errors.Is(err, domainsvc.ErrNotFound) {
 			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
 		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrStorageQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrObjectQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrUnsupportedFont) ||
+			errors.Is(err, domainsvc.ErrInvalidFrame) ||
+			errors.Is(err, domainsvc.ErrInvalidPage) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrBudgetUnreachable) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "error fetching image").SetInternal(err)
 	}
+	if compression.Quality > 0 {
+		c.Response().Header().Set("X-Image-Quality", strconv.Itoa(compression.Quality))
+	}
 	return c.Blob(http.StatusOK, contentTypeString(_imgType), image)
 }
 
+func (h httpService) GetOriginalImage(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	access, err := imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	image, format, err := h.imageSvc.GetOriginalImage(c.Request().Context(), imgName, access, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrStorageQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrObjectQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error fetching image").SetInternal(err)
+	}
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	if format == domain.ImageType_SVG {
+		c.Response().Header().Set("Content-Security-Policy", svgContentSecurityPolicy)
+	}
+	return c.Blob(http.StatusOK, contentTypeString(format), image)
+}
+
+func (h httpService) GetPlaceholder(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	queryPrms := c.QueryParams()
+
+	placeholderType, err := domain.PlaceholderTypeFromString(queryPrms.Get("type"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidPlaceholder.Error())
+	}
+	access, err := imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	placeholders, err := h.imageSvc.GetPlaceholders(c.Request().Context(), imgName,
+		[]domain.PlaceholderType{placeholderType}, access, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error computing placeholder").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"type":        placeholderType.String(),
+		"placeholder": placeholders[placeholderType],
+	})
+}
+
+func (h httpService) GetColors(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	queryPrms := c.QueryParams()
+
+	count := defaultColorCount
+	if countStr := queryPrms.Get("count"); countStr != "" {
+		validCount, err := strconv.Atoi(countStr)
+		if err != nil || validCount < 1 || validCount > maxPaletteColors {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidColorCount.Error())
+		}
+		count = validCount
+	}
+	access, err := imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	analysis, err := h.imageSvc.GetColors(c.Request().Context(), imgName, count, access, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrNoColors) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error analyzing colors").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, analysis)
+}
+
+func (h httpService) FindSimilarImages(c echo.Context) error {
+	queryPrms := c.QueryParams()
+	imgName := queryPrms.Get("to")
+
+	if imgName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "to is required")
+	}
+	distance := defaultSimilarityDistance
+	if distanceStr := queryPrms.Get("distance"); distanceStr != "" {
+		validDistance, err := strconv.Atoi(distanceStr)
+		if err != nil || validDistance < 0 || validDistance > domain.MaxPerceptualHashDistance {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidDistance.Error())
+		}
+		distance = validDistance
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	similarImages, err := h.imageSvc.FindSimilar(c.Request().Context(), imgName, distance, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error finding similar images").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"images": similarImages})
+}
+
+func (h httpService) GetMetadata(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	access, err := imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := h.imageSvc.GetMetadata(c.Request().Context(), imgName, access, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrStorageQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrObjectQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading metadata").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, metadata)
+}
+
+func (h httpService) GetSrcset(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	queryPrms := c.QueryParams()
+
+	widths, err := parseWidths(queryPrms.Get("widths"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	srcsetOpts := domainsvc.SrcsetOpts{Name: imgName, Widths: widths}
+	if ar := queryPrms.Get("ar"); ar != "" {
+		validAr, err := domain.ParseAspectRatio(ar)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidAspectRatio.Error())
+		}
+		srcsetOpts.Ar = &validAr
+	}
+	if prerender := queryPrms.Get("prerender"); prerender != "" {
+		validPrerender, err := strconv.ParseBool(prerender)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid prerender")
+		}
+		srcsetOpts.Prerender = validPrerender
+	}
+	sizes := queryPrms.Get("sizes")
+	if sizes == "" {
+		sizes = "100vw"
+	}
+	srcsetOpts.Access, err = imageAccess(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	srcsetOpts.TenantOpts, err = requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	srcset, err := h.imageSvc.GetSrcset(c.Request().Context(), srcsetOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "image not found")
+		}
+		if errors.Is(err, domainsvc.ErrAccessDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error preparing srcset").SetInternal(err)
+	}
+
+	variantUrl := func(width int, format domain.ImageType) string {
+		params := url.Values{}
+		params.Set("width", strconv.Itoa(width))
+		if srcsetOpts.Ar != nil {
+			params.Set("ar", srcsetOpts.Ar.String())
+		}
+		params.Set("format", format.String())
+		params.Set("tenant-code", srcsetOpts.TenantOpts.TenantCode)
+		params.Set("org-code", srcsetOpts.TenantOpts.OrgCode)
+		// the signature covers every variant of the image
+		if srcset.Signature != "" {
+			params.Set("expires", strconv.FormatInt(srcset.Expires.Unix(), 10))
+			params.Set("signature", srcset.Signature)
+		}
+		return "/" + url.PathEscape(imgName) + "?" + params.Encode()
+	}
+	srcsetOf := func(format domain.ImageType) string {
+		candidates := make([]string, 0, len(widths))
+		for i, variant := range srcset.Variants {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", variantUrl(widths[i], format), variant.Width))
+		}
+		return strings.Join(candidates, ", ")
+	}
+
+	type source struct {
+		Type   string `json:"type"`
+		Srcset string `json:"srcset"`
+	}
+	sources := make([]source, 0, len(srcset.Formats))
+	var picture strings.Builder
+	picture.WriteString("<picture>")
+	for _, format := range srcset.Formats {
+		s := source{Type: contentTypeString(format), Srcset: srcsetOf(format)}
+		sources = append(sources, s)
+		fmt.Fprintf(&picture, `<source type="%s" srcset="%s" sizes="%s">`,
+			html.EscapeString(s.Type), html.EscapeString(s.Srcset), html.EscapeString(sizes))
+	}
+	// the largest variant is the src of clients without srcset support
+	largest := 0
+	for i, variant := range srcset.Variants {
+		if variant.Width > srcset.Variants[largest].Width {
+			largest = i
+		}
+	}
+	fallbackSrc := variantUrl(widths[largest], srcset.Fallback)
+	fallbackSrcset := srcsetOf(srcset.Fallback)
+	fmt.Fprintf(&picture, `<img src="%s" srcset="%s" sizes="%s" width="%d" height="%d" alt="" loading="lazy" decoding="async">`,
+		html.EscapeString(fallbackSrc), html.EscapeString(fallbackSrcset), html.EscapeString(sizes),
+		srcset.Variants[largest].Width, srcset.Variants[largest].Height)
+	picture.WriteString("</picture>")
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"variants": srcset.Variants,
+		"sources":  sources,
+		"fallback": map[string]string{
+			"type":   contentTypeString(srcset.Fallback),
+			"src":    fallbackSrc,
+			"srcset": fallbackSrcset,
+		},
+		"sizes": sizes,
+		"html":  picture.String(),
+	})
+}
+
+func (h httpService) GetEagerStatus(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.imageSvc.GetEagerStatus(context.Background(), imgName, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNoEagerStatus) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading eager status").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func (h httpService) GetVisibility(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	visibility, err := h.imageSvc.GetVisibility(context.Background(), imgName, tenantOpts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading visibility").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"visibility": visibility})
+}
+
+func (h httpService) SetVisibility(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	queryPrms := c.QueryParams()
+
+	visibility, err := domain.VisibilityFromString(queryPrms.Get("visibility"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidVisibility.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.imageSvc.SetVisibility(context.Background(), imgName, visibility, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "image not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error setting visibility").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"visibility": visibility})
+}
+
+// GetSignedUrl returns a url serving the image, whatever its visibility, for
+// expiresIn seconds. Any variant of the image can be requested by adding query
+// parameters to it.
+func (h httpService) GetSignedUrl(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	queryPrms := c.QueryParams()
+
+	expiresIn := defaultSignedUrlExpiry
+	if expiresInStr := queryPrms.Get("expiresIn"); expiresInStr != "" {
+		validExpiresIn, err := strconv.Atoi(expiresInStr)
+		if err != nil || validExpiresIn < 1 || validExpiresIn > maxSignedUrlExpiry {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidExpiry.Error())
+		}
+		expiresIn = validExpiresIn
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second).Truncate(time.Second).UTC()
+	signature := h.imageSvc.SignImageUrl(context.Background(), imgName, expiresAt, tenantOpts)
+	query := url.Values{
+		"tenant-code": {tenantOpts.TenantCode},
+		"org-code":    {tenantOpts.OrgCode},
+		"expires":     {strconv.FormatInt(expiresAt.Unix(), 10)},
+		"signature":   {signature},
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"url":       "/" + url.PathEscape(imgName) + "?" + query.Encode(),
+		"expiresAt": expiresAt,
+	})
+}
+
+// imageAccess collects what the request presents to be served an image: the
+// principal set by Authenticate and the expires and signature parameters of a
+// signed url.
+func imageAccess(c echo.Context) (domain.Access, error) {
+	var access domain.Access
+	if principal, ok := c.Get(PrincipalKey).(domain.Principal); ok {
+		access.Principal = &principal
+	}
+	queryPrms := c.QueryParams()
+	expires := queryPrms.Get("expires")
+	signature := queryPrms.Get("signature")
+	if expires == "" && signature == "" {
+		return access, nil
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || signature == "" {
+		return domain.Access{}, ErrInvalidSignature
+	}
+	access.Expires = time.Unix(expiresUnix, 0)
+	access.Signature = signature
+	return access, nil
+}
+
+// UploadImage uploads the img file of a multipart request, or the images at the
+// urls of a json body. Clients without tenant credentials upload files with an
+// upload token, sent in the X-Upload-Token header.
 func (h httpService) UploadImage(c echo.Context) error {
 	queryPrms := c.QueryParams()
+	isJson := strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
 
-	tenantCode := queryPrms.Get("tenant-code")
-	orgCode := queryPrms.Get("org-code")
+	uploadToken := c.Request().Header.Get(UploadTokenHeader)
+	var visibility *domain.Visibility
+	if visibilityStr := queryPrms.Get("visibility"); visibilityStr != "" {
+		validVisibility, err := domain.VisibilityFromString(visibilityStr)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidVisibility.Error())
+		}
+		if isJson || uploadToken != "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "visibility can only be set on file uploads of the tenant")
+		}
+		visibility = &validVisibility
+	}
 
-	if tenantCode == "" || orgCode == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "tenant-code and org-code are required")
+	// uploads with a token are stored for the tenant of the token
+	var tenantOpts domain.TenantOpts
+	if uploadToken == "" {
+		var err error
+		if tenantOpts, err = requestTenant(c); err != nil {
+			return err
+		}
 	}
-	tenantOpts := domain.TenantOpts{
-		TenantCode: queryPrms.Get("tenant-code"),
-		OrgCode:    queryPrms.Get("org-code"),
+	if isJson {
+		if uploadToken != "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "upload tokens only accept file uploads")
+		}
+		return h.uploadFromUrl(c, tenantOpts)
 	}
 
 	file, err := c.FormFile("img")
@@ -97,18 +706,349 @@ func (h httpService) UploadImage(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	if uploadToken != "" {
+		return h.uploadWithToken(c, uploadToken, img)
+	}
 
 	imgName, err := h.imageSvc.Upload(context.Background(), img, tenantOpts)
 	if err != nil {
+		if errors.Is(err, domainsvc.ErrUnsupportedImageFormat) {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrStorageQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		if errors.Is(err, domainsvc.ErrObjectQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload the file")
 	}
-	err = c.JSON(http.StatusOK, map[string]string{"imgName": imgName})
+	if visibility != nil {
+		err = h.imageSvc.SetVisibility(context.Background(), imgName, *visibility, tenantOpts)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to set the visibility").SetInternal(err)
+		}
+	}
+	err = c.JSON(http.StatusOK, h.uploadResponse(imgName, uploaderAccess(c), tenantOpts))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (h httpService) uploadWithToken(c echo.Context, uploadToken string, img []byte) error {
+	imgName, tenantOpts, err := h.imageSvc.UploadWithToken(context.Background(), uploadToken, img)
+	if err != nil {
+		switch {
+		case errors.Is(err, domainsvc.ErrInvalidUploadToken), errors.Is(err, domainsvc.ErrUploadTokenExpired):
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, domainsvc.ErrUploadTokenUsed):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, domainsvc.ErrUploadTooLarge):
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, domainsvc.ErrUnsupportedImageFormat), errors.Is(err, domainsvc.ErrFormatNotAllowed):
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, domainsvc.ErrStorageQuotaExceeded):
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		case errors.Is(err, domainsvc.ErrObjectQuotaExceeded):
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload the file").SetInternal(err)
+		}
+	}
+	return c.JSON(http.StatusOK, h.uploadResponse(imgName, uploaderAccess(c), tenantOpts))
+}
+
+type uploadTokenRequest struct {
+	MaxBytes int64              `json:"maxBytes"`
+	Formats  []domain.ImageType `json:"formats"`
+	// ExpiresIn is the lifetime of the token in seconds
+	ExpiresIn int  `json:"expiresIn"`
+	SingleUse bool `json:"singleUse"`
+}
+
+// IssueUploadToken signs an upload token for the tenant, constrained by the
+// optional json body.
+func (h httpService) IssueUploadToken(c echo.Context) error {
+	req := uploadTokenRequest{MaxBytes: defaultUploadTokenMaxBytes, ExpiresIn: defaultUploadTokenExpiry}
+	err := json.NewDecoder(io.LimitReader(c.Request().Body, maxUploadTokenBody)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").SetInternal(err)
+	}
+	if req.MaxBytes < 1 || req.MaxBytes > maxUploadTokenMaxBytes {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidMaxBytes.Error())
+	}
+	if req.ExpiresIn < 1 || req.ExpiresIn > maxUploadTokenExpiry {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidExpiry.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	token, signed, err := h.imageSvc.IssueUploadToken(context.Background(), domain.UploadToken{
+		TenantOpts: tenantOpts,
+		MaxBytes:   req.MaxBytes,
+		Formats:    req.Formats,
+		ExpiresAt:  time.Now().Add(time.Duration(req.ExpiresIn) * time.Second).UTC(),
+		SingleUse:  req.SingleUse,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error issuing upload token").SetInternal(err)
+	}
+	return c.JSON(http.StatusCreated, map[string]any{
+		"token":     signed,
+		"id":        token.Id,
+		"expiresAt": token.ExpiresAt,
+		"maxBytes":  token.MaxBytes,
+		"formats":   token.Formats,
+		"singleUse": token.SingleUse,
+	})
+}
+
+type remoteUploadRequest struct {
+	Url  string   `json:"url"`
+	Urls []string `json:"urls"`
+}
+
+// uploadFromUrl uploads the image at the url of a json body, or the images at
+// its urls for a batch.
+func (h httpService) uploadFromUrl(c echo.Context, tenantOpts domain.TenantOpts) error {
+	var req remoteUploadRequest
+	if err := json.NewDecoder(io.LimitReader(c.Request().Body, maxRemoteUploadBody)).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").SetInternal(err)
+	}
+	if (req.Url == "") == (len(req.Urls) == 0) {
+		return echo.NewHTTPError(http.StatusBadRequest, "exactly one of url and urls is required")
+	}
+
+	if len(req.Urls) > 0 {
+		if len(req.Urls) > maxRemoteUploadBatch {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("at most %d urls can be uploaded at once", maxRemoteUploadBatch))
+		}
+		results := h.imageSvc.UploadFromUrls(context.Background(), req.Urls, tenantOpts)
+		return c.JSON(http.StatusOK, map[string]any{"results": results})
+	}
+
+	imgName, err := h.imageSvc.UploadFromUrl(context.Background(), req.Url, tenantOpts)
+	if err != nil {
+		switch {
+		case errors.Is(err, domainsvc.ErrInvalidRemoteUrl), errors.Is(err, domainsvc.ErrForbiddenRemoteAddress):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, domainsvc.ErrRemoteTooLarge):
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, domainsvc.ErrUnsupportedImageFormat):
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, domainsvc.ErrStorageQuotaExceeded):
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		case errors.Is(err, domainsvc.ErrObjectQuotaExceeded):
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		case errors.Is(err, domainsvc.ErrRemoteFetchFailed):
+			return echo.NewHTTPError(http.StatusBadGateway, domainsvc.ErrRemoteFetchFailed.Error()).SetInternal(err)
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload the file").SetInternal(err)
+		}
+	}
+	return c.JSON(http.StatusOK, h.uploadResponse(imgName, uploaderAccess(c), tenantOpts))
+}
+
+// UploadBatch uploads every file of the img fields of a multipart request along
+// with the files of the zip archives of its archive fields.
+func (h httpService) UploadBatch(c echo.Context) error {
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBatchUploadBytes)
+	if err := req.ParseMultipartForm(batchUploadMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "batch is too large")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read the files").SetInternal(err)
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	images := req.MultipartForm.File["img"]
+	archives := req.MultipartForm.File["archive"]
+	if len(images) == 0 && len(archives) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "img or archive files are required")
+	}
+	if len(images) > maxBatchUploadFiles {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("at most %d files can be uploaded at once", maxBatchUploadFiles))
+	}
+
+	files := make([]domain.BatchFile, 0, len(images))
+	remainingBytes := int64(maxBatchUploadBytes)
+	for _, header := range images {
+		remainingBytes -= header.Size
+		files = append(files, domain.BatchFile{Name: header.Filename, Size: header.Size, Open: openBatchFile(header)})
+	}
+	for _, header := range archives {
+		archive, err := header.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to open the file").SetInternal(err)
+		}
+		// the files of the archive are read from it while they are uploaded
+		defer archive.Close()
+		extracted, err := domain.ExtractZipArchive(archive, header.Size, maxBatchUploadFiles-len(files), remainingBytes)
+		if err != nil {
+			switch {
+			case errors.Is(err, domain.ErrInvalidArchive):
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %s", header.Filename, err.Error()))
+			case errors.Is(err, domain.ErrArchiveTooLarge), errors.Is(err, domain.ErrTooManyArchiveFiles):
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: %s", header.Filename, err.Error()))
+			default:
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to open the file").SetInternal(err)
+			}
+		}
+		for _, file := range extracted {
+			remainingBytes -= file.Size
+			file.Name = header.Filename + "/" + file.Name
+			files = append(files, file)
+		}
+	}
+
+	results := h.imageSvc.UploadBatch(context.Background(), files, tenantOpts)
+	return c.JSON(http.StatusOK, map[string]any{"results": results})
+}
+
+func openBatchFile(header *multipart.FileHeader) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return header.Open()
+	}
+}
+
+// uploaderAccess is the access of the principal uploading an image, uploads
+// with a token have none and are only shown the placeholders of public images.
+func uploaderAccess(c echo.Context) domain.Access {
+	var access domain.Access
+	if principal, ok := c.Get(PrincipalKey).(domain.Principal); ok {
+		access.Principal = &principal
+	}
+	return access
+}
+
+func (h httpService) uploadResponse(imgName string, access domain.Access, tenantOpts domain.TenantOpts) map[string]any {
+	response := map[string]any{"imgName": imgName}
+	// placeholders are a convenience, the upload itself succeeded either way
+	placeholders, err := h.imageSvc.GetPlaceholders(context.Background(), imgName, domain.PlaceholderTypes, access, tenantOpts)
+	if err == nil {
+		placeholderStrings := make(map[string]string, len(placeholders))
+		for placeholderType, placeholder := range placeholders {
+			placeholderStrings[placeholderType.String()] = placeholder
+		}
+		response["placeholders"] = placeholderStrings
+	}
+	// eager transformations keep rendering after the response is sent
+	eagerStatus, err := h.imageSvc.GetEagerStatus(context.Background(), imgName, tenantOpts)
+	if err == nil {
+		response["eager"] = eagerStatus
+	}
+	return response
+}
+
+func (h httpService) PurgeImage(c echo.Context) error {
+	imgName := c.Param("imgName")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.imageSvc.Purge(context.Background(), imgName, tenantOpts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error queueing purge").SetInternal(err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ReprocessImages queues the reprocessing of the comma separated images, or of
+// all images of the tenant when none are given.
+func (h httpService) ReprocessImages(c echo.Context) error {
+	queryPrms := c.QueryParams()
+
+	var names []string
+	if imagesStr := queryPrms.Get("images"); imagesStr != "" {
+		for _, name := range strings.Split(imagesStr, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidImages.Error())
+			}
+			names = append(names, name)
+		}
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.imageSvc.Reprocess(context.Background(), names, tenantOpts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error queueing reprocessing").SetInternal(err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h httpService) GetJob(c echo.Context) error {
+	id := c.Param("id")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.imageSvc.GetJob(context.Background(), id, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrJobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading job").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h httpService) GetWebhookDeliveries(c echo.Context) error {
+	queryPrms := c.QueryParams()
+
+	limit := defaultDeliveryLimit
+	if limitStr := queryPrms.Get("limit"); limitStr != "" {
+		validLimit, err := strconv.Atoi(limitStr)
+		if err != nil || validLimit < 1 || validLimit > maxDeliveryLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidLimit.Error())
+		}
+		limit = validLimit
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := h.imageSvc.GetWebhookDeliveries(context.Background(), limit, tenantOpts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading webhook deliveries").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"deliveries": deliveries})
+}
+
+// GetUsage returns the storage used by the tenant along with its quota.
+func (h httpService) GetUsage(c echo.Context) error {
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	usage, err := h.imageSvc.GetUsage(context.Background(), tenantOpts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reading usage").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
 func NewHttpService(imgSvc domainsvc.ImageServiceInterface) HttpServiceInterface {
 	return httpService{
 		imgSvc,
@@ -116,7 +1056,9 @@ func NewHttpService(imgSvc domainsvc.ImageServiceInterface) HttpServiceInterface
 }
 
 func distinguishImageType(acceptHeader []string) domain.ImageType {
-	if slices.Contains(acceptHeader, "image/avif") {
+	if slices.Contains(acceptHeader, "image/jxl") {
+		return domain.ImageType_JXL
+	} else if slices.Contains(acceptHeader, "image/avif") {
 		return domain.ImageType_AVIF
 	} else if slices.Contains(acceptHeader, "image/webp") {
 		return domain.ImageType_WEBP
@@ -135,11 +1077,97 @@ func contentTypeString(imgType domain.ImageType) string {
 		return "image/jpeg"
 	case domain.ImageType_PNG:
 		return "image/png"
+	case domain.ImageType_GIF:
+		return "image/gif"
+	case domain.ImageType_JXL:
+		return "image/jxl"
+	case domain.ImageType_HEIC:
+		return "image/heic"
+	case domain.ImageType_TIFF:
+		return "image/tiff"
+	case domain.ImageType_BMP:
+		return "image/bmp"
+	case domain.ImageType_SVG:
+		return "image/svg+xml"
+	case domain.ImageType_PDF:
+		return "application/pdf"
 	default:
 		return ""
 	}
 }
 
+// parseOutputFormat parses an explicitly requested output format; an empty one
+// leaves the format to content negotiation.
+func parseOutputFormat(format string) (domain.ImageType, error) {
+	if format == "" {
+		return domain.ImageType_AUTO, nil
+	}
+	imgType, err := domain.ImageTypeFromString(strings.ToLower(format))
+	if err != nil || (imgType != domain.ImageType_AUTO && !imgType.IsOutputFormat()) {
+		return -1, ErrInvalidFormat
+	}
+	return imgType, nil
+}
+
+// parseWidths parses a comma separated list of widths, defaulting to a range of
+// common breakpoints.
+func parseWidths(widthsStr string) ([]int, error) {
+	if widthsStr == "" {
+		return []int{320, 640, 960, 1280, 1920}, nil
+	}
+	parts := strings.Split(widthsStr, ",")
+	if len(parts) > maxSrcsetWidths {
+		return nil, ErrInvalidWidths
+	}
+	widths := make([]int, 0, len(parts))
+	for _, part := range parts {
+		width, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || width < 1 || width > maxSrcsetWidth {
+			return nil, ErrInvalidWidths
+		}
+		if !slices.Contains(widths, width) {
+			widths = append(widths, width)
+		}
+	}
+	slices.Sort(widths)
+	return widths, nil
+}
+
+// prepareDeviceOpts resolves the device pixel ratio from the dpr parameter or the
+// Sec-CH-DPR hint and, when no size is requested, the width from the Sec-CH-Width
+// or Sec-CH-Viewport-Width hints. Save-Data caps the ratio at 1. Hints are sent by
+// browsers on their own so malformed ones are ignored rather than rejected.
+func prepareDeviceOpts(opts domainsvc.GetImageOpts, queryPrms url.Values, header http.Header) (domainsvc.GetImageOpts, error) {
+	deviceDpr := 1.0
+	if hint, err := strconv.ParseFloat(header.Get("Sec-CH-DPR"), 64); err == nil && hint > 0 {
+		deviceDpr = hint
+	}
+	dpr := min(max(deviceDpr, minDpr), maxDpr)
+	if dprStr := queryPrms.Get("dpr"); dprStr != "" {
+		validDpr, err := strconv.ParseFloat(dprStr, 64)
+		if err != nil || !(validDpr >= minDpr && validDpr <= maxDpr) {
+			return opts, ErrInvalidDpr
+		}
+		dpr = validDpr
+	}
+	if strings.EqualFold(header.Get("Save-Data"), "on") {
+		dpr = minDpr
+	}
+
+	if opts.Width == nil && opts.Height == nil {
+		if hint, err := strconv.Atoi(header.Get("Sec-CH-Width")); err == nil && hint > 0 {
+			// Sec-CH-Width is in physical pixels of the device
+			opts = opts.SetWidth(int(math.Round(float64(hint) / deviceDpr)))
+		} else if hint, err := strconv.Atoi(header.Get("Sec-CH-Viewport-Width")); err == nil && hint > 0 {
+			opts = opts.SetWidth(hint)
+		}
+	}
+	if dpr != 1 {
+		opts = opts.SetDpr(dpr)
+	}
+	return opts, nil
+}
+
 func prepareGetImageOpts(width, height, ar string) (domainsvc.GetImageOpts, error) {
 	svcGetImgOpts := domainsvc.NewServiceGetImageOpts()
 	switch {
@@ -208,3 +1236,95 @@ func prepareGetImageOpts(width, height, ar string) (domainsvc.GetImageOpts, erro
 	}
 	return svcGetImgOpts, nil
 }
+
+// prepareTextOpts builds the text overlay from the text, font, font-size,
+// text-color, text-bg, text-gravity and text-width parameters. It returns nil
+// if no text is requested.
+func prepareTextOpts(queryPrms url.Values) (*domain.TextOpts, error) {
+	text := queryPrms.Get("text")
+	if text == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(text) > maxTextLength {
+		return nil, ErrInvalidText
+	}
+	textOpts := domain.TextOpts{
+		Text:  text,
+		Font:  queryPrms.Get("font"),
+		Color: domain.Color{A: 0xff},
+	}
+	if size := queryPrms.Get("font-size"); size != "" {
+		validSize, err := strconv.Atoi(size)
+		if err != nil || validSize <= 0 || validSize > maxFontSize {
+			return nil, ErrInvalidFontSize
+		}
+		textOpts.Size = validSize
+	}
+	if color := queryPrms.Get("text-color"); color != "" {
+		validColor, err := domain.ParseHexColor(color)
+		if err != nil {
+			return nil, ErrInvalidColor
+		}
+		textOpts.Color = validColor
+	}
+	if background := queryPrms.Get("text-bg"); background != "" {
+		validBackground, err := domain.ParseHexColor(background)
+		if err != nil {
+			return nil, ErrInvalidColor
+		}
+		textOpts.Background = &validBackground
+	}
+	gravity, err := domain.GravityFromString(queryPrms.Get("text-gravity"))
+	if err != nil {
+		return nil, ErrInvalidGravity
+	}
+	textOpts.Gravity = gravity
+	if width := queryPrms.Get("text-width"); width != "" {
+		validWidth, err := strconv.Atoi(width)
+		if err != nil || validWidth <= 0 || validWidth > maxDimension {
+			return nil, ErrInvalidTextWidth
+		}
+		textOpts.WrapWidth = validWidth
+	}
+	return &textOpts, nil
+}
+
+// prepareShapeOpts builds the shape operation from the pad, bg, radius and mask
+// parameters. It returns nil if none of them is given.
+func prepareShapeOpts(queryPrms url.Values) (*domain.ShapeOpts, error) {
+	pad := queryPrms.Get("pad")
+	background := queryPrms.Get("bg")
+	radius := queryPrms.Get("radius")
+	mask := queryPrms.Get("mask")
+	if pad == "" && background == "" && radius == "" && mask == "" {
+		return nil, nil
+	}
+	shapeOpts := domain.ShapeOpts{}
+	if pad != "" {
+		validPad, err := strconv.Atoi(pad)
+		if err != nil || validPad < 0 || validPad > maxPadding {
+			return nil, ErrInvalidPadding
+		}
+		shapeOpts.Padding = validPad
+	}
+	if background != "" {
+		validBackground, err := domain.ParseBackground(background)
+		if err != nil {
+			return nil, ErrInvalidBackground
+		}
+		shapeOpts.Background = &validBackground
+	}
+	if radius != "" {
+		validRadius, err := strconv.Atoi(radius)
+		if err != nil || validRadius < 0 {
+			return nil, ErrInvalidRadius
+		}
+		shapeOpts.Radius = validRadius
+	}
+	validMask, err := domain.MaskFromString(mask)
+	if err != nil {
+		return nil, ErrInvalidMask
+	}
+	shapeOpts.Mask = validMask
+	return &shapeOpts, nil
+}
@@ -0,0 +1,207 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"example.com/imageProc/internal/domain/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads follow the tus protocol, see https://tus.io/protocols/resumable-upload.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+	// tusContentType is the only content type chunks are accepted in
+	tusContentType = "application/offset+octet-stream"
+
+	TusResumableHeader    = "Tus-Resumable"
+	TusVersionHeader      = "Tus-Version"
+	TusExtensionHeader    = "Tus-Extension"
+	TusMaxSizeHeader      = "Tus-Max-Size"
+	UploadLengthHeader    = "Upload-Length"
+	UploadOffsetHeader    = "Upload-Offset"
+	UploadMetadataHeader  = "Upload-Metadata"
+	UploadExpiresHeader   = "Upload-Expires"
+	UploadImageNameHeader = "X-Image-Name"
+)
+
+var (
+	ErrInvalidUploadLength = errors.New("invalid upload length")
+	ErrInvalidUploadOffset = errors.New("invalid upload offset")
+)
+
+// TusOptions lets clients discover the protocol version and extensions supported.
+func (h httpService) TusOptions(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set(TusResumableHeader, tusVersion)
+	header.Set(TusVersionHeader, tusVersion)
+	header.Set(TusExtensionHeader, tusExtensions)
+	header.Set(TusMaxSizeHeader, strconv.FormatInt(domain.MaxResumableUploadSize, 10))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload, the body of the request may already
+// carry its first chunk.
+func (h httpService) CreateUpload(c echo.Context) error {
+	if err := checkTusResumable(c); err != nil {
+		return err
+	}
+	req := c.Request()
+
+	length, err := strconv.ParseInt(req.Header.Get(UploadLengthHeader), 10, 64)
+	if err != nil || length < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidUploadLength.Error())
+	}
+	metadata, err := domain.ParseUploadMetadata(req.Header.Get(UploadMetadataHeader))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	withChunk := req.ContentLength != 0 && req.Header.Get(echo.HeaderContentType) != ""
+	if withChunk && req.Header.Get(echo.HeaderContentType) != tusContentType {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "content type must be "+tusContentType)
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.imageSvc.CreateUpload(context.Background(), length, metadata, tenantOpts)
+	if err != nil {
+		if errors.Is(err, domainsvc.ErrUploadTooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "error creating upload").SetInternal(err)
+	}
+	if withChunk {
+		upload, err = h.imageSvc.AppendUpload(context.Background(), upload.Id, 0, req.Body, tenantOpts)
+		if err != nil {
+			return uploadError(err)
+		}
+	}
+
+	location := url.URL{
+		Path:     "/uploads/" + upload.Id,
+		RawQuery: url.Values{"tenant-code": {tenantOpts.TenantCode}, "org-code": {tenantOpts.OrgCode}}.Encode(),
+	}
+	c.Response().Header().Set(echo.HeaderLocation, location.String())
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusCreated)
+}
+
+// HeadUpload reports the offset a client resumes an upload from.
+func (h httpService) HeadUpload(c echo.Context) error {
+	if err := checkTusResumable(c); err != nil {
+		return err
+	}
+	id := c.Param("id")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.imageSvc.GetUpload(context.Background(), id, tenantOpts)
+	if err != nil {
+		return uploadError(err)
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, "no-store")
+	header.Set(UploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		header.Set(UploadMetadataHeader, domain.FormatUploadMetadata(upload.Metadata))
+	}
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusOK)
+}
+
+// PatchUpload appends the chunk in the body at the offset of the upload.
+func (h httpService) PatchUpload(c echo.Context) error {
+	if err := checkTusResumable(c); err != nil {
+		return err
+	}
+	id := c.Param("id")
+	req := c.Request()
+
+	if req.Header.Get(echo.HeaderContentType) != tusContentType {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "content type must be "+tusContentType)
+	}
+	offset, err := strconv.ParseInt(req.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidUploadOffset.Error())
+	}
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.imageSvc.AppendUpload(context.Background(), id, offset, req.Body, tenantOpts)
+	if err != nil {
+		return uploadError(err)
+	}
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h httpService) TerminateUpload(c echo.Context) error {
+	if err := checkTusResumable(c); err != nil {
+		return err
+	}
+	id := c.Param("id")
+
+	tenantOpts, err := requestTenant(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.imageSvc.TerminateUpload(context.Background(), id, tenantOpts); err != nil {
+		return uploadError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkTusResumable sets the protocol version on the response and refuses
+// clients speaking another one.
+func checkTusResumable(c echo.Context) error {
+	c.Response().Header().Set(TusResumableHeader, tusVersion)
+	if strings.TrimSpace(c.Request().Header.Get(TusResumableHeader)) != tusVersion {
+		c.Response().Header().Set(TusVersionHeader, tusVersion)
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "unsupported tus version")
+	}
+	return nil
+}
+
+func setUploadHeaders(c echo.Context, upload domain.ResumableUpload) {
+	header := c.Response().Header()
+	header.Set(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	if upload.ImageName != "" {
+		header.Set(UploadImageNameHeader, upload.ImageName)
+		return
+	}
+	header.Set(UploadExpiresHeader, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, domainsvc.ErrUploadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, domainsvc.ErrUploadOffsetMismatch):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, domainsvc.ErrUploadExceedsLength):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, domainsvc.ErrUploadLocked):
+		return echo.NewHTTPError(http.StatusLocked, err.Error())
+	case errors.Is(err, domainsvc.ErrUnsupportedImageFormat):
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, domainsvc.ErrStorageQuotaExceeded):
+		return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+	case errors.Is(err, domainsvc.ErrObjectQuotaExceeded):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "error writing upload").SetInternal(err)
+	}
+}
@@ -0,0 +1,227 @@
+package shttp
+
+import (
+	"context"
+	"example.com/imageProc/internal/domain"
+	"example.com/imageProc/internal/domain/service"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	testifymock "github.com/stretchr/testify/mock"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusImageService mocks the resumable upload methods of the image service, the
+// embedded interface is left nil as the tus handlers call nothing else.
+type tusImageService struct {
+	testifymock.Mock
+	domainsvc.ImageServiceInterface
+}
+
+func (m *tusImageService) CreateUpload(ctx context.Context, length int64, metadata map[string]string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	args := m.Called(length, metadata, tenantOpts)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *tusImageService) GetUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	args := m.Called(id, tenantOpts)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *tusImageService) AppendUpload(ctx context.Context, id string, offset int64, chunk io.Reader, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	content, _ := io.ReadAll(chunk)
+	args := m.Called(id, offset, string(content), tenantOpts)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *tusImageService) TerminateUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) error {
+	args := m.Called(id, tenantOpts)
+	return args.Error(0)
+}
+
+func newTusServer(imageSvc domainsvc.ImageServiceInterface) *echo.Echo {
+	httpSvc := NewHttpService(imageSvc)
+	e := echo.New()
+	e.OPTIONS("/uploads", httpSvc.TusOptions)
+	e.POST("/uploads", httpSvc.CreateUpload)
+	e.HEAD("/uploads/:id", httpSvc.HeadUpload)
+	e.PATCH("/uploads/:id", httpSvc.PatchUpload)
+	e.DELETE("/uploads/:id", httpSvc.TerminateUpload)
+	return e
+}
+
+func tusRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(TusResumableHeader, tusVersion)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, tusContentType)
+	}
+	return req
+}
+
+func TestTusUploads(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	query := "?tenant-code=tenant&org-code=org"
+	expiresAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	created := domain.ResumableUpload{Id: "upload1", TenantOpts: tenantOpts, Length: 10,
+		Metadata: map[string]string{"filename": "cat.jpg"}, ExpiresAt: expiresAt}
+
+	t.Run("the protocol is discovered with an options request", func(t *testing.T) {
+		rec := serve(newTusServer(new(tusImageService)), httptest.NewRequest(http.MethodOptions, "/uploads", nil))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, tusVersion, rec.Header().Get(TusVersionHeader))
+		assert.Equal(t, tusExtensions, rec.Header().Get(TusExtensionHeader))
+	})
+
+	t.Run("an upload is created at its location", func(t *testing.T) {
+		imageSvc := new(tusImageService)
+		imageSvc.On("CreateUpload", int64(10), map[string]string{"filename": "cat.jpg"}, tenantOpts).Return(created, nil)
+		req := tusRequest(http.MethodPost, "/uploads"+query, "")
+		req.Header.Set(UploadLengthHeader, "10")
+		req.Header.Set(UploadMetadataHeader, "filename Y2F0LmpwZw==")
+
+		rec := serve(newTusServer(imageSvc), req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "/uploads/upload1?org-code=org&tenant-code=tenant", rec.Header().Get(echo.HeaderLocation))
+		assert.Equal(t, "0", rec.Header().Get(UploadOffsetHeader))
+		assert.Equal(t, "Fri, 02 Jan 2026 03:04:05 GMT", rec.Header().Get(UploadExpiresHeader))
+		imageSvc.AssertNotCalled(t, "AppendUpload", testifymock.Anything, testifymock.Anything,
+			testifymock.Anything, testifymock.Anything)
+	})
+
+	t.Run("an upload is created with its first chunk", func(t *testing.T) {
+		imageSvc := new(tusImageService)
+		imageSvc.On("CreateUpload", int64(10), map[string]string{}, tenantOpts).Return(created, nil)
+		appended := created
+		appended.Offset = 4
+		imageSvc.On("AppendUpload", "upload1", int64(0), "abcd", tenantOpts).Return(appended, nil)
+		req := tusRequest(http.MethodPost, "/uploads"+query, "abcd")
+		req.Header.Set(UploadLengthHeader, "10")
+
+		rec := serve(newTusServer(imageSvc), req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "4", rec.Header().Get(UploadOffsetHeader))
+	})
+
+	t.Run("the offset of an upload is reported", func(t *testing.T) {
+		imageSvc := new(tusImageService)
+		upload := created
+		upload.Offset = 4
+		imageSvc.On("GetUpload", "upload1", tenantOpts).Return(upload, nil)
+
+		rec := serve(newTusServer(imageSvc), tusRequest(http.MethodHead, "/uploads/upload1"+query, ""))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "4", rec.Header().Get(UploadOffsetHeader))
+		assert.Equal(t, "10", rec.Header().Get(UploadLengthHeader))
+		assert.Equal(t, "no-store", rec.Header().Get(echo.HeaderCacheControl))
+	})
+
+	t.Run("the last chunk reports the image name", func(t *testing.T) {
+		imageSvc := new(tusImageService)
+		received := created
+		received.Offset = 10
+		received.ImageName = "testimagename1"
+		imageSvc.On("AppendUpload", "upload1", int64(4), "efghij", tenantOpts).Return(received, nil)
+		req := tusRequest(http.MethodPatch, "/uploads/upload1"+query, "efghij")
+		req.Header.Set(UploadOffsetHeader, "4")
+
+		rec := serve(newTusServer(imageSvc), req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "10", rec.Header().Get(UploadOffsetHeader))
+		assert.Equal(t, "testimagename1", rec.Header().Get(UploadImageNameHeader))
+		assert.Empty(t, rec.Header().Get(UploadExpiresHeader))
+	})
+
+	t.Run("an upload is terminated", func(t *testing.T) {
+		imageSvc := new(tusImageService)
+		imageSvc.On("TerminateUpload", "upload1", tenantOpts).Return(nil)
+
+		rec := serve(newTusServer(imageSvc), tusRequest(http.MethodDelete, "/uploads/upload1"+query, ""))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		imageSvc.AssertExpectations(t)
+	})
+
+	testCases := []struct {
+		name         string
+		request      func() *http.Request
+		appendErr    error
+		expectedCode int
+	}{
+		{name: "another protocol version is refused", request: func() *http.Request {
+			req := tusRequest(http.MethodHead, "/uploads/upload1"+query, "")
+			req.Header.Set(TusResumableHeader, "0.2.2")
+			return req
+		}, expectedCode: http.StatusPreconditionFailed},
+		{name: "an invalid length is refused", request: func() *http.Request {
+			req := tusRequest(http.MethodPost, "/uploads"+query, "")
+			req.Header.Set(UploadLengthHeader, "0")
+			return req
+		}, expectedCode: http.StatusBadRequest},
+		{name: "invalid metadata is refused", request: func() *http.Request {
+			req := tusRequest(http.MethodPost, "/uploads"+query, "")
+			req.Header.Set(UploadLengthHeader, "10")
+			req.Header.Set(UploadMetadataHeader, "filename !!!")
+			return req
+		}, expectedCode: http.StatusBadRequest},
+		{name: "a chunk in another content type is refused", request: func() *http.Request {
+			req := tusRequest(http.MethodPatch, "/uploads/upload1"+query, "abcd")
+			req.Header.Set(echo.HeaderContentType, "application/octet-stream")
+			req.Header.Set(UploadOffsetHeader, "0")
+			return req
+		}, expectedCode: http.StatusUnsupportedMediaType},
+		{name: "an invalid offset is refused", request: func() *http.Request {
+			req := tusRequest(http.MethodPatch, "/uploads/upload1"+query, "abcd")
+			req.Header.Set(UploadOffsetHeader, "-1")
+			return req
+		}, expectedCode: http.StatusBadRequest},
+		{name: "the tenant is required", request: func() *http.Request {
+			req := tusRequest(http.MethodPatch, "/uploads/upload1", "abcd")
+			req.Header.Set(UploadOffsetHeader, "0")
+			return req
+		}, expectedCode: http.StatusBadRequest},
+		{name: "a missing upload", appendErr: domainsvc.ErrUploadNotFound, expectedCode: http.StatusNotFound},
+		{name: "a chunk at the wrong offset", appendErr: domainsvc.ErrUploadOffsetMismatch,
+			expectedCode: http.StatusConflict},
+		{name: "a chunk past the length", appendErr: domainsvc.ErrUploadExceedsLength,
+			expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "an upload being processed", appendErr: domainsvc.ErrUploadLocked, expectedCode: http.StatusLocked},
+		{name: "an upload which is not an image", appendErr: domainsvc.ErrUnsupportedImageFormat,
+			expectedCode: http.StatusUnsupportedMediaType},
+		{name: "an upload past the quota", appendErr: domainsvc.ErrStorageQuotaExceeded,
+			expectedCode: http.StatusInsufficientStorage},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			imageSvc := new(tusImageService)
+			imageSvc.On("AppendUpload", "upload1", int64(0), "abcd", tenantOpts).
+				Return(domain.ResumableUpload{}, tc.appendErr)
+			request := tc.request
+			if request == nil {
+				request = func() *http.Request {
+					req := tusRequest(http.MethodPatch, "/uploads/upload1"+query, "abcd")
+					req.Header.Set(UploadOffsetHeader, "0")
+					return req
+				}
+			}
+
+			rec := serve(newTusServer(imageSvc), request())
+
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			assert.Equal(t, tusVersion, rec.Header().Get(TusResumableHeader))
+			if tc.appendErr == nil {
+				imageSvc.AssertNotCalled(t, "AppendUpload", testifymock.Anything, testifymock.Anything,
+					testifymock.Anything, testifymock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package appsvc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"os"
+	"strings"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+type AuthServiceInterface interface {
+	AuthenticateApiKey(key string) (domain.Principal, error)
+	// AuthenticateJwt authenticates an HMAC signed JWT carrying the tenant and
+	// org claims along with a space separated scope claim
+	AuthenticateJwt(token string) (domain.Principal, error)
+}
+
+// authService looks api keys up by their hash, JWTs are accepted when they are
+// signed with jwtSecret and have not expired.
+type authService struct {
+	apiKeys   map[string]domain.ApiKey
+	jwtSecret []byte
+}
+
+type tenantClaims struct {
+	Tenant string `json:"tenant"`
+	Org    string `json:"org"`
+	Scope  string `json:"scope"`
+	jwt.StandardClaims
+}
+
+func (a authService) AuthenticateApiKey(key string) (domain.Principal, error) {
+	apiKey, ok := a.apiKeys[domain.HashApiKey(key)]
+	if !ok || key == "" {
+		return domain.Principal{}, ErrInvalidCredentials
+	}
+	return domain.Principal{Subject: apiKey.Id, TenantOpts: apiKey.TenantOpts, Scopes: apiKey.Scopes}, nil
+}
+
+func (a authService) AuthenticateJwt(token string) (domain.Principal, error) {
+	if len(a.jwtSecret) == 0 {
+		return domain.Principal{}, ErrInvalidCredentials
+	}
+	var claims tenantClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		// the algorithm is the token's own claim, only the expected one is trusted
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.jwtSecret, nil
+	})
+	if err != nil {
+		return domain.Principal{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	// tokens which never expire can not be revoked
+	tenantOpts := domain.TenantOpts{TenantCode: claims.Tenant, OrgCode: claims.Org}
+	if claims.ExpiresAt == 0 || tenantOpts.Validate() != nil {
+		return domain.Principal{}, ErrInvalidCredentials
+	}
+
+	principal := domain.Principal{
+		Subject:    claims.Subject,
+		TenantOpts: tenantOpts,
+	}
+	for _, scopeStr := range strings.Fields(claims.Scope) {
+		// tokens may carry scopes meant for other services
+		if scope, err := domain.ScopeFromString(scopeStr); err == nil {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+	return principal, nil
+}
+
+// NewAuthService loads the api keys from the json array at apiKeyFile, no api
+// key is accepted with an empty path. No JWT is accepted without a secret.
+func NewAuthService(apiKeyFile string, jwtSecret []byte) (AuthServiceInterface, error) {
+	apiKeys := map[string]domain.ApiKey{}
+	if apiKeyFile != "" {
+		content, err := os.ReadFile(apiKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading api keys %s", err.Error())
+		}
+		var keys []domain.ApiKey
+		if err = json.Unmarshal(content, &keys); err != nil {
+			return nil, fmt.Errorf("error while parsing api keys %s", err.Error())
+		}
+		for _, key := range keys {
+			hash, err := hex.DecodeString(key.Hash)
+			if err != nil || len(hash) != 32 {
+				return nil, fmt.Errorf("api key %s: hash must be a hex encoded sha-256", key.Id)
+			}
+			if err = key.TenantOpts.Validate(); err != nil {
+				return nil, fmt.Errorf("api key %s: %v", key.Id, err)
+			}
+			apiKeys[strings.ToLower(key.Hash)] = key
+		}
+	}
+	return authService{apiKeys: apiKeys, jwtSecret: jwtSecret}, nil
+}
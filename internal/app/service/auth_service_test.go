@@ -0,0 +1,93 @@
+package appsvc
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateApiKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"id": "ci", "hash": "` + domain.HashApiKey("secret-key") + `",
+		"tenant": {"tenantCode": "tenant", "orgCode": "org"}, "scopes": ["read", "write"]}]`
+	if err := os.WriteFile(keyFile, []byte(content), 0666); err != nil {
+		t.Fatalf("error writing api keys: %v", err)
+	}
+	svc, err := NewAuthService(keyFile, nil)
+	if err != nil {
+		t.Fatalf("error creating auth service: %v", err)
+	}
+
+	principal, err := svc.AuthenticateApiKey("secret-key")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Principal{
+		Subject:    "ci",
+		TenantOpts: domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"},
+		Scopes:     []domain.Scope{domain.Scope_READ, domain.Scope_WRITE},
+	}, principal)
+
+	for _, key := range []string{"other-key", ""} {
+		_, err = svc.AuthenticateApiKey(key)
+		assert.ErrorIs(t, err, ErrInvalidCredentials, key)
+	}
+	_, err = svc.AuthenticateJwt("a.b.c")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAuthenticateJwt(t *testing.T) {
+	secret := []byte("jwt-secret")
+	svc, err := NewAuthService("", secret)
+	if err != nil {
+		t.Fatalf("error creating auth service: %v", err)
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims tenantClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("error signing token: %v", err)
+		}
+		return token
+	}
+	valid := tenantClaims{
+		Tenant:         "tenant",
+		Org:            "org",
+		Scope:          "read profile",
+		StandardClaims: jwt.StandardClaims{Subject: "user1", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}
+
+	t.Run("a signed token authenticates", func(t *testing.T) {
+		principal, err := svc.AuthenticateJwt(sign(jwt.SigningMethodHS256, secret, valid))
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Principal{
+			Subject:    "user1",
+			TenantOpts: domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"},
+			Scopes:     []domain.Scope{domain.Scope_READ},
+		}, principal)
+	})
+
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	neverExpiring := valid
+	neverExpiring.ExpiresAt = 0
+	noTenant := valid
+	noTenant.Tenant = ""
+	testCases := map[string]string{
+		"expired":        sign(jwt.SigningMethodHS256, secret, expired),
+		"never expiring": sign(jwt.SigningMethodHS256, secret, neverExpiring),
+		"without tenant": sign(jwt.SigningMethodHS256, secret, noTenant),
+		"other secret":   sign(jwt.SigningMethodHS256, []byte("other"), valid),
+		"unsigned":       sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid),
+		"garbage":        "not a token",
+	}
+	for name, token := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.AuthenticateJwt(token)
+
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+}
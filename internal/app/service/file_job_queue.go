@@ -0,0 +1,398 @@
+package appsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrNoJobReady  = errors.New("no job is ready to run")
+)
+
+const (
+	defaultJobAttempts = 5
+	jobBaseBackoff     = 5 * time.Second
+	jobMaxBackoff      = 10 * time.Minute
+	jobIdLength        = 16
+	// JobRetention is how long succeeded jobs are kept for clients to look up
+	JobRetention = 7 * 24 * time.Hour
+	// DeadLetterRetention is how long dead-lettered jobs are kept for
+	// operators to look into
+	DeadLetterRetention = 30 * 24 * time.Hour
+)
+
+type JobQueueInterface interface {
+	// Enqueue stores the job as pending and returns it with its id assigned
+	Enqueue(job domain.Job) (domain.Job, error)
+	// GetJob returns a job in any state, dead-lettered ones included
+	GetJob(id string) (domain.Job, error)
+	// Claim marks the pending job which is due first as running and returns
+	// it, or ErrNoJobReady if no job is due
+	Claim() (domain.Job, error)
+	Complete(id string) error
+	// Fail schedules another attempt of the job after an exponential backoff,
+	// or moves it to the dead-letter store once it ran out of attempts
+	Fail(id string, jobErr error) error
+	// DeadLetters returns the jobs which ran out of attempts
+	DeadLetters() ([]domain.Job, error)
+	// DeleteExpired drops the jobs which finished longer ago than their
+	// retention and returns how many there were
+	DeleteExpired() (int, error)
+}
+
+// fileJobQueue keeps one json file per job in its directory, and the jobs which
+// ran out of attempts in the dead subdirectory. It serves a single process, so
+// the pending jobs are indexed in memory instead of being read on every claim.
+// Job files which can not be read are moved to the corrupt subdirectory.
+type fileJobQueue struct {
+	dir string
+	// mu guards the job files and pending, which maps the id of every pending
+	// job to when it is due
+	mu      *sync.Mutex
+	pending map[string]time.Time
+	now     func() time.Time
+}
+
+func (f fileJobQueue) Enqueue(job domain.Job) (domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	job.Id = GenerateJobId()
+	job.State = domain.JobState_PENDING
+	job.Attempts = 0
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultJobAttempts
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.NextRunAt = now
+	if err := writeJob(f.dir, job); err != nil {
+		return domain.Job{}, err
+	}
+	f.pending[job.Id] = job.NextRunAt
+	return job, nil
+}
+
+func (f fileJobQueue) GetJob(id string) (domain.Job, error) {
+	if !isJobId(id) {
+		return domain.Job{}, ErrJobNotFound
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job, err := readJob(f.dir, id)
+	if errors.Is(err, ErrJobNotFound) {
+		return readJob(deadLetterDir(f.dir), id)
+	}
+	return job, err
+}
+
+func (f fileJobQueue) Claim() (domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	for {
+		dueId := ""
+		for id, nextRunAt := range f.pending {
+			if nextRunAt.After(now) {
+				continue
+			}
+			if dueId == "" || nextRunAt.Before(f.pending[dueId]) {
+				dueId = id
+			}
+		}
+		if dueId == "" {
+			return domain.Job{}, ErrNoJobReady
+		}
+		delete(f.pending, dueId)
+
+		job, err := readJob(f.dir, dueId)
+		if err != nil {
+			// the job is dropped from the index, the others are still claimed
+			if !errors.Is(err, ErrJobNotFound) {
+				quarantineJob(f.dir, dueId+".json", err)
+			}
+			continue
+		}
+		job.State = domain.JobState_RUNNING
+		job.Attempts++
+		job.UpdatedAt = now
+		if err = writeJob(f.dir, job); err != nil {
+			f.pending[dueId] = job.NextRunAt
+			return domain.Job{}, err
+		}
+		return job, nil
+	}
+}
+
+func (f fileJobQueue) Complete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job, err := readJob(f.dir, id)
+	if err != nil {
+		return err
+	}
+	job.State = domain.JobState_SUCCEEDED
+	job.LastError = ""
+	job.UpdatedAt = f.now()
+	return writeJob(f.dir, job)
+}
+
+func (f fileJobQueue) Fail(id string, jobErr error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job, err := readJob(f.dir, id)
+	if err != nil {
+		return err
+	}
+	return f.fail(job, jobErr, f.now().Add(jobBackoff(job.Attempts)))
+}
+
+// fail schedules the failed job to run again at nextRunAt, or dead-letters it
+// once it ran out of attempts.
+func (f fileJobQueue) fail(job domain.Job, jobErr error, nextRunAt time.Time) error {
+	job.LastError = jobErr.Error()
+	job.UpdatedAt = f.now()
+	if job.Attempts < job.MaxAttempts {
+		job.State = domain.JobState_PENDING
+		job.NextRunAt = nextRunAt
+		if err := writeJob(f.dir, job); err != nil {
+			return err
+		}
+		f.pending[job.Id] = job.NextRunAt
+		return nil
+	}
+
+	job.State = domain.JobState_DEAD
+	if err := writeJob(deadLetterDir(f.dir), job); err != nil {
+		return err
+	}
+	if err := os.Remove(jobFile(f.dir, job.Id)); err != nil {
+		return fmt.Errorf("error while removing job %s", err.Error())
+	}
+	return nil
+}
+
+func (f fileJobQueue) DeadLetters() ([]domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return readJobs(deadLetterDir(f.dir))
+}
+
+func (f fileJobQueue) DeleteExpired() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	retentions := map[string]time.Duration{f.dir: JobRetention, deadLetterDir(f.dir): DeadLetterRetention}
+	deleted := 0
+	for dir, retention := range retentions {
+		jobs, err := readJobs(dir)
+		if err != nil {
+			return deleted, err
+		}
+		for _, job := range jobs {
+			finished := job.State == domain.JobState_SUCCEEDED || job.State == domain.JobState_DEAD
+			if !finished || now.Sub(job.UpdatedAt) < retention {
+				continue
+			}
+			if err = os.Remove(jobFile(dir, job.Id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return deleted, fmt.Errorf("error while removing job %s", err.Error())
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// NewFileJobQueue opens the queue stored in dir. Jobs left running by a previous
+// process count as failed attempts: a job which keeps taking the process down
+// is dead-lettered like any other once it ran out of attempts.
+func NewFileJobQueue(dir string) (JobQueueInterface, error) {
+	if err := os.MkdirAll(deadLetterDir(dir), 0750); err != nil {
+		return nil, fmt.Errorf("error while making directory %s", err.Error())
+	}
+	queue := fileJobQueue{dir: dir, mu: &sync.Mutex{}, pending: map[string]time.Time{}, now: time.Now}
+
+	jobs, err := readJobs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		switch job.State {
+		case domain.JobState_PENDING:
+			queue.pending[job.Id] = job.NextRunAt
+		case domain.JobState_RUNNING:
+			if err = queue.fail(job, errors.New("interrupted"), queue.now()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return queue, nil
+}
+
+// JobHandler runs a claimed job; an error fails the attempt.
+type JobHandler func(ctx context.Context, job domain.Job) error
+
+// RunJobWorkers runs jobs of the queue with the given number of workers until
+// ctx is done. Idle workers poll the queue every pollInterval.
+func RunJobWorkers(ctx context.Context, queue JobQueueInterface, workers int, pollInterval time.Duration, handler JobHandler) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if !runNextJob(ctx, queue, handler) {
+					select {
+					case <-ctx.Done():
+					case <-time.After(pollInterval):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runNextJob runs the job which is due first and reports whether there was one.
+func runNextJob(ctx context.Context, queue JobQueueInterface, handler JobHandler) bool {
+	job, err := queue.Claim()
+	if err != nil {
+		return false
+	}
+	if err = runJob(ctx, job, handler); err != nil {
+		_ = queue.Fail(job.Id, err)
+	} else {
+		_ = queue.Complete(job.Id)
+	}
+	return true
+}
+
+// runJob keeps a panicking handler from taking the worker down with it.
+func runJob(ctx context.Context, job domain.Job, handler JobHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func jobBackoff(attempts int) time.Duration {
+	backoff := jobBaseBackoff
+	for i := 1; i < attempts && backoff < jobMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, jobMaxBackoff)
+}
+
+func readJob(dir, id string) (domain.Job, error) {
+	content, err := os.ReadFile(jobFile(dir, id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return domain.Job{}, ErrJobNotFound
+		}
+		return domain.Job{}, fmt.Errorf("internal error: %v", err)
+	}
+	var job domain.Job
+	if err = json.Unmarshal(content, &job); err != nil {
+		return domain.Job{}, fmt.Errorf("error while parsing job %s", err.Error())
+	}
+	return job, nil
+}
+
+func readJobs(dir string) ([]domain.Job, error) {
+	dirEntry, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	jobs := make([]domain.Job, 0, len(dirEntry))
+	for _, e := range dirEntry {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		job, err := readJob(dir, strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			// a job file which can not be read must not hold up the others
+			if !errors.Is(err, ErrJobNotFound) {
+				quarantineJob(dir, e.Name(), err)
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// quarantineJob moves the job file which can not be read out of the way, to be
+// looked into by an operator.
+func quarantineJob(dir, name string, readErr error) {
+	log.Printf("quarantining job file %s: %v", filepath.Join(dir, name), readErr)
+	corrupt := filepath.Join(dir, "corrupt")
+	if err := os.MkdirAll(corrupt, 0750); err != nil {
+		log.Printf("error while making directory %s", err.Error())
+		return
+	}
+	if err := os.Rename(filepath.Join(dir, name), filepath.Join(corrupt, name)); err != nil {
+		log.Printf("error while quarantining job file %s", err.Error())
+	}
+}
+
+// writeJob replaces the job file through a rename so that a crash never leaves
+// a partially written job behind.
+func writeJob(dir string, job domain.Job) error {
+	content, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := jobFile(dir, job.Id) + ".tmp"
+	if err = os.WriteFile(tmp, content, 0666); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	if err = os.Rename(tmp, jobFile(dir, job.Id)); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+func jobFile(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func deadLetterDir(dir string) string {
+	return filepath.Join(dir, "dead")
+}
+
+// isJobId keeps ids received from clients from pointing outside of the queue.
+func isJobId(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == jobIdLength
+}
+
+func GenerateJobId() string {
+	return randomId(jobIdLength)
+}
+
+func randomId(length int) string {
+	id := make([]byte, length)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,240 @@
+package appsvc
+
+import (
+	"context"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestJobQueue(t *testing.T, now *time.Time) fileJobQueue {
+	queue, err := NewFileJobQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("error opening job queue: %v", err)
+	}
+	fjq := queue.(fileJobQueue)
+	fjq.now = func() time.Time { return *now }
+	return fjq
+}
+
+func TestFileJobQueue(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("a job runs once and succeeds", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		queue := newTestJobQueue(t, &now)
+
+		job, err := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, TenantOpts: tenantOpts, Images: []string{"img"}})
+		assert.NoError(t, err)
+		assert.Equal(t, domain.JobState_PENDING, job.State)
+		assert.Equal(t, defaultJobAttempts, job.MaxAttempts)
+
+		claimed, err := queue.Claim()
+		assert.NoError(t, err)
+		assert.Equal(t, job.Id, claimed.Id)
+		assert.Equal(t, domain.JobState_RUNNING, claimed.State)
+		assert.Equal(t, 1, claimed.Attempts)
+
+		_, err = queue.Claim()
+		assert.ErrorIs(t, err, ErrNoJobReady)
+
+		assert.NoError(t, queue.Complete(job.Id))
+		stored, err := queue.GetJob(job.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.JobState_SUCCEEDED, stored.State)
+		assert.Equal(t, []string{"img"}, stored.Images)
+	})
+
+	t.Run("a failed job is retried after a growing backoff and dead-lettered", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		queue := newTestJobQueue(t, &now)
+
+		job, err := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, TenantOpts: tenantOpts, MaxAttempts: 3})
+		assert.NoError(t, err)
+
+		for attempt, backoff := range []time.Duration{5 * time.Second, 10 * time.Second} {
+			_, err = queue.Claim()
+			assert.NoError(t, err, "attempt %d", attempt+1)
+			assert.NoError(t, queue.Fail(job.Id, errors.New("disk failure")))
+
+			now = now.Add(backoff - time.Millisecond)
+			_, err = queue.Claim()
+			assert.ErrorIs(t, err, ErrNoJobReady, "attempt %d", attempt+1)
+			now = now.Add(time.Millisecond)
+		}
+		_, err = queue.Claim()
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Fail(job.Id, errors.New("disk failure")))
+
+		_, err = queue.Claim()
+		assert.ErrorIs(t, err, ErrNoJobReady)
+		dead, err := queue.DeadLetters()
+		assert.NoError(t, err)
+		if assert.Len(t, dead, 1) {
+			assert.Equal(t, domain.JobState_DEAD, dead[0].State)
+			assert.Equal(t, 3, dead[0].Attempts)
+			assert.Equal(t, "disk failure", dead[0].LastError)
+		}
+		stored, err := queue.GetJob(job.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.JobState_DEAD, stored.State)
+	})
+
+	t.Run("jobs interrupted by a restart are pending again", func(t *testing.T) {
+		dir := t.TempDir()
+		queue, err := NewFileJobQueue(dir)
+		if err != nil {
+			t.Fatalf("error opening job queue: %v", err)
+		}
+		job, _ := queue.Enqueue(domain.Job{Type: domain.JobType_EAGER, TenantOpts: tenantOpts})
+		_, _ = queue.Claim()
+
+		reopened, err := NewFileJobQueue(dir)
+		assert.NoError(t, err)
+		claimed, err := reopened.Claim()
+		assert.NoError(t, err)
+		assert.Equal(t, job.Id, claimed.Id)
+		assert.Equal(t, 2, claimed.Attempts)
+	})
+
+	t.Run("a job interrupted on its last attempt is dead-lettered", func(t *testing.T) {
+		dir := t.TempDir()
+		queue, err := NewFileJobQueue(dir)
+		if err != nil {
+			t.Fatalf("error opening job queue: %v", err)
+		}
+		job, _ := queue.Enqueue(domain.Job{Type: domain.JobType_EAGER, TenantOpts: tenantOpts, MaxAttempts: 1})
+		_, _ = queue.Claim()
+
+		reopened, err := NewFileJobQueue(dir)
+		assert.NoError(t, err)
+		_, err = reopened.Claim()
+		assert.ErrorIs(t, err, ErrNoJobReady)
+		stored, err := reopened.GetJob(job.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.JobState_DEAD, stored.State)
+		assert.Equal(t, "interrupted", stored.LastError)
+	})
+
+	t.Run("a job file which can not be read is quarantined", func(t *testing.T) {
+		dir := t.TempDir()
+		queue, err := NewFileJobQueue(dir)
+		if err != nil {
+			t.Fatalf("error opening job queue: %v", err)
+		}
+		job, _ := queue.Enqueue(domain.Job{Type: domain.JobType_EAGER, TenantOpts: tenantOpts})
+		corruptId := GenerateJobId()
+		if err = os.WriteFile(jobFile(dir, corruptId), []byte("{not json"), 0666); err != nil {
+			t.Fatalf("error writing job file: %v", err)
+		}
+
+		reopened, err := NewFileJobQueue(dir)
+		assert.NoError(t, err)
+		claimed, err := reopened.Claim()
+		assert.NoError(t, err)
+		assert.Equal(t, job.Id, claimed.Id)
+		_, err = os.Stat(filepath.Join(dir, "corrupt", corruptId+".json"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("finished jobs are deleted after their retention", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		queue := newTestJobQueue(t, &now)
+
+		succeeded, _ := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, TenantOpts: tenantOpts})
+		_, _ = queue.Claim()
+		assert.NoError(t, queue.Complete(succeeded.Id))
+		dead, _ := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, TenantOpts: tenantOpts, MaxAttempts: 1})
+		_, _ = queue.Claim()
+		assert.NoError(t, queue.Fail(dead.Id, errors.New("disk failure")))
+		pending, _ := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, TenantOpts: tenantOpts})
+
+		now = now.Add(JobRetention)
+		deleted, err := queue.DeleteExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		_, err = queue.GetJob(succeeded.Id)
+		assert.ErrorIs(t, err, ErrJobNotFound)
+
+		now = now.Add(DeadLetterRetention)
+		deleted, err = queue.DeleteExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		_, err = queue.GetJob(dead.Id)
+		assert.ErrorIs(t, err, ErrJobNotFound)
+		_, err = queue.GetJob(pending.Id)
+		assert.NoError(t, err)
+	})
+
+	t.Run("ids which are not job ids are not found", func(t *testing.T) {
+		now := time.Now()
+		queue := newTestJobQueue(t, &now)
+
+		_, err := queue.GetJob("../dead/" + GenerateJobId())
+
+		assert.ErrorIs(t, err, ErrJobNotFound)
+	})
+}
+
+func TestJobBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 5 * time.Second},
+		{attempts: 2, expected: 10 * time.Second},
+		{attempts: 4, expected: 40 * time.Second},
+		{attempts: 20, expected: jobMaxBackoff},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, jobBackoff(tc.attempts), "attempts %d", tc.attempts)
+	}
+}
+
+func TestRunJobWorkers(t *testing.T) {
+	t.Run("workers run the queued jobs until they are stopped", func(t *testing.T) {
+		queue, err := NewFileJobQueue(t.TempDir())
+		if err != nil {
+			t.Fatalf("error opening job queue: %v", err)
+		}
+		succeeding, _ := queue.Enqueue(domain.Job{Type: domain.JobType_PURGE, Images: []string{"img"}})
+		panicking, _ := queue.Enqueue(domain.Job{Type: domain.JobType_EAGER})
+
+		var mu sync.Mutex
+		ran := map[string]int{}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			RunJobWorkers(ctx, queue, 2, time.Millisecond, func(ctx context.Context, job domain.Job) error {
+				mu.Lock()
+				ran[job.Id]++
+				mu.Unlock()
+				if job.Type == domain.JobType_EAGER {
+					panic("broken handler")
+				}
+				return nil
+			})
+			close(done)
+		}()
+
+		assert.Eventually(t, func() bool {
+			job, err := queue.GetJob(panicking.Id)
+			return err == nil && job.State == domain.JobState_PENDING && job.Attempts == 1
+		}, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool {
+			job, err := queue.GetJob(succeeding.Id)
+			return err == nil && job.State == domain.JobState_SUCCEEDED
+		}, time.Second, time.Millisecond)
+		cancel()
+		<-done
+
+		failed, _ := queue.GetJob(panicking.Id)
+		assert.Equal(t, "job panicked: broken handler", failed.LastError)
+		assert.Equal(t, 1, ran[succeeding.Id])
+	})
+}
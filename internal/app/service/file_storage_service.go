@@ -1,13 +1,23 @@
 package appsvc
 
 import (
+	"encoding/json"
 	"errors"
 	"example.com/imageProc/internal/domain"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
+)
+
+const (
+	// tenantMarkerFile starts with a dot so that it never collides with an image name
+	tenantMarkerFile = ".tenant"
+	imageNameLength  = 16
+	// maxImageNameAttempts bounds the generated names tried for a new image,
+	// random names are not expected to collide even once
+	maxImageNameAttempts = 5
 )
 
 var (
@@ -17,45 +27,142 @@ var (
 
 type ImageStorageServiceInterface interface {
 	StoreParentImage(image []byte, format domain.ImageType, tenantOpts domain.TenantOpts) (string, error)
+	// StoreNamedParentImage stores a parent image under the given name instead of a generated one
+	StoreNamedParentImage(name string, image []byte, format domain.ImageType, tenantOpts domain.TenantOpts) error
 	StoreChildImage(image []byte, name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) error
 	GetParentImage(name string, tenantOpts domain.TenantOpts) ([]byte, error)
-	GetChildImage(name string, format domain.ImageType, width, height int, tenantOpts domain.TenantOpts) ([]byte, error)
+	GetChildImage(name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) ([]byte, error)
+	// StoreParentAttachment stores data derived from a parent image, e.g. its placeholders, next to it
+	StoreParentAttachment(name, key string, data []byte, tenantOpts domain.TenantOpts) error
+	GetParentAttachment(name, key string, tenantOpts domain.TenantOpts) ([]byte, error)
+	// StoreParentSetting stores a setting chosen for a parent image, e.g. its visibility. Unlike
+	// attachments settings are not derived from the image and survive DeleteDerivedImages
+	StoreParentSetting(name, key string, data []byte, tenantOpts domain.TenantOpts) error
+	GetParentSetting(name, key string, tenantOpts domain.TenantOpts) ([]byte, error)
+	// ListParentImages returns the names of all parent images of the tenant
+	ListParentImages(tenantOpts domain.TenantOpts) ([]string, error)
+	// DeleteParentImage deletes a parent image along with its child images and attachments
+	DeleteParentImage(name string, tenantOpts domain.TenantOpts) error
+	// DeleteDerivedImages deletes the child images and attachments of a parent image, keeping the parent image
+	DeleteDerivedImages(name string, tenantOpts domain.TenantOpts) error
+	// DeleteChildImages deletes the child images of a parent image only and returns what they used
+	DeleteChildImages(name string, tenantOpts domain.TenantOpts) (domain.UsageCount, error)
+	// Usage returns the storage used by the images of the tenant. It is counted
+	// once and then kept up to date as images are stored and deleted
+	Usage(tenantOpts domain.TenantOpts) (domain.Usage, error)
+	// RecountUsage counts the storage used by the images of the tenant again,
+	// correcting the drift of the running count Usage returns
+	RecountUsage(tenantOpts domain.TenantOpts) (domain.Usage, error)
+	// ListTenants returns every tenant which stored an image
+	ListTenants() ([]domain.TenantOpts, error)
 }
 
 type localImageStorageService struct {
 	baseDir string
+	usage   *usageCounter
+}
+
+// usageCounter keeps the usage of the tenants counted so far. Images stored
+// concurrently with a count, or by another instance sharing the directory, make
+// it drift until the tenant is counted again.
+type usageCounter struct {
+	mu      sync.Mutex
+	tenants map[string]domain.Usage
+}
+
+func (u *usageCounter) get(tenant string) (domain.Usage, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage, ok := u.tenants[tenant]
+	return usage, ok
+}
+
+func (u *usageCounter) set(tenant string, usage domain.Usage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.tenants[tenant] = usage
+}
+
+// add changes the usage of a tenant counted already, the others are counted in
+// full on their first Usage.
+func (u *usageCounter) add(tenant string, delta domain.Usage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage, ok := u.tenants[tenant]
+	if !ok {
+		return
+	}
+	u.tenants[tenant] = domain.Usage{
+		Originals:   usage.Originals.Add(delta.Originals),
+		Derivatives: usage.Derivatives.Add(delta.Derivatives),
+	}
 }
 
 func (l localImageStorageService) StoreParentImage(image []byte, format domain.ImageType, tenantOpts domain.TenantOpts) (string, error) {
-	fName := GenerateImageName()
+	if err := os.MkdirAll(tenantDir(l.baseDir, tenantOpts), 0750); err != nil {
+		return "", fmt.Errorf("error while making directory %s", err.Error())
+	}
+	for attempt := 0; attempt < maxImageNameAttempts; attempt++ {
+		fName := GenerateImageName()
+		// the directory is claimed exclusively so that concurrent uploads never share a name
+		path := parentImageDir(l.baseDir, tenantOpts, fName)
+		if err := os.Mkdir(path, 0750); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			return "", fmt.Errorf("error while making directory %s", err.Error())
+		}
+		if err := l.StoreNamedParentImage(fName, image, format, tenantOpts); err != nil {
+			_ = os.RemoveAll(path)
+			return "", err
+		}
+		return fName, nil
+	}
+	return "", fmt.Errorf("error while naming image: %d generated names were taken", maxImageNameAttempts)
+}
 
-	path := parentImageDir(l.baseDir, tenantOpts, fName)
+func (l localImageStorageService) StoreNamedParentImage(name string, image []byte, format domain.ImageType, tenantOpts domain.TenantOpts) error {
+	path := parentImageDir(l.baseDir, tenantOpts, name)
 
 	if err := os.MkdirAll(path, 0750); err != nil {
-		return "", fmt.Errorf("error while making directory %s", err.Error())
+		return fmt.Errorf("error while making directory %s", err.Error())
+	}
+	if err := markTenant(l.baseDir, tenantOpts); err != nil {
+		return err
 	}
 
-	fDir := filepath.Join(path, fName+"."+format.String())
+	fDir := filepath.Join(path, name+"."+format.String())
+	replaced := fileUsage(fDir)
 	if err := os.WriteFile(fDir, image, 0666); err != nil {
-		return "", fmt.Errorf("error while writing file %s", err.Error())
+		return fmt.Errorf("error while writing file %s", err.Error())
 	}
-	return fName, nil
+	l.usage.add(tenantDir(l.baseDir, tenantOpts), domain.Usage{
+		Originals: domain.UsageCount{Objects: 1, Bytes: int64(len(image))}.Sub(replaced),
+	})
+	return nil
 }
 
 func (l localImageStorageService) StoreChildImage(image []byte, name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) error {
-	path := childImageDir(
-		parentImageDir(l.baseDir, tenantOpts, name),
-		spec.Format, spec.Width, spec.Height)
+	path := variantImageDir(
+		childImageDir(parentImageDir(l.baseDir, tenantOpts, name), spec.Format, spec.Width, spec.Height),
+		spec.Variant)
 
 	if err := os.MkdirAll(path, 0750); err != nil {
 		return fmt.Errorf("error while making directory %s", err.Error())
 	}
+	if err := markTenant(l.baseDir, tenantOpts); err != nil {
+		return err
+	}
 
 	fDir := filepath.Join(path, name+"."+spec.Format.String())
+	replaced := fileUsage(fDir)
 
 	if err := os.WriteFile(fDir, image, 0666); err != nil {
 		return fmt.Errorf("error while writing file %s", err.Error())
 	}
+	l.usage.add(tenantDir(l.baseDir, tenantOpts), domain.Usage{
+		Derivatives: domain.UsageCount{Objects: 1, Bytes: int64(len(image))}.Sub(replaced),
+	})
 	return nil
 }
 
@@ -93,13 +200,13 @@ func (l localImageStorageService) GetParentImage(name string, tenantOpts domain.
 	return image, nil
 }
 
-func (l localImageStorageService) GetChildImage(name string, format domain.ImageType, width, height int, tenantOpts domain.TenantOpts) ([]byte, error) {
-	path := childImageDir(
-		parentImageDir(l.baseDir, tenantOpts, name),
-		format, width, height,
+func (l localImageStorageService) GetChildImage(name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) ([]byte, error) {
+	path := variantImageDir(
+		childImageDir(parentImageDir(l.baseDir, tenantOpts, name), spec.Format, spec.Width, spec.Height),
+		spec.Variant,
 	)
 
-	fDir := filepath.Join(path, name+"."+format.String())
+	fDir := filepath.Join(path, name+"."+spec.Format.String())
 
 	image, err := os.ReadFile(fDir)
 	if err != nil {
@@ -111,20 +218,352 @@ func (l localImageStorageService) GetChildImage(name string, format domain.Image
 	return image, nil
 }
 
+func (l localImageStorageService) StoreParentAttachment(name, key string, data []byte, tenantOpts domain.TenantOpts) error {
+	path := attachmentDir(parentImageDir(l.baseDir, tenantOpts, name))
+
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return fmt.Errorf("error while making directory %s", err.Error())
+	}
+
+	fDir := filepath.Join(path, key)
+	if err := os.WriteFile(fDir, data, 0666); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+func (l localImageStorageService) GetParentAttachment(name, key string, tenantOpts domain.TenantOpts) ([]byte, error) {
+	fDir := filepath.Join(attachmentDir(parentImageDir(l.baseDir, tenantOpts, name)), key)
+
+	data, err := os.ReadFile(fDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoMatchingFile
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	return data, nil
+}
+
+func (l localImageStorageService) StoreParentSetting(name, key string, data []byte, tenantOpts domain.TenantOpts) error {
+	path := settingDir(parentImageDir(l.baseDir, tenantOpts, name))
+
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return fmt.Errorf("error while making directory %s", err.Error())
+	}
+
+	fDir := filepath.Join(path, key)
+	if err := os.WriteFile(fDir, data, 0666); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+func (l localImageStorageService) GetParentSetting(name, key string, tenantOpts domain.TenantOpts) ([]byte, error) {
+	fDir := filepath.Join(settingDir(parentImageDir(l.baseDir, tenantOpts, name)), key)
+
+	data, err := os.ReadFile(fDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoMatchingFile
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	return data, nil
+}
+
+func (l localImageStorageService) ListParentImages(tenantOpts domain.TenantOpts) ([]string, error) {
+	dirEntry, err := os.ReadDir(tenantDir(l.baseDir, tenantOpts))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+
+	names := make([]string, 0, len(dirEntry))
+	for _, e := range dirEntry {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (l localImageStorageService) DeleteParentImage(name string, tenantOpts domain.TenantOpts) error {
+	path := parentImageDir(l.baseDir, tenantOpts, name)
+
+	usage, err := parentUsage(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNoMatchingFile
+		}
+		return fmt.Errorf("internal error: %v", err)
+	}
+	if err = os.RemoveAll(path); err != nil {
+		return fmt.Errorf("error while removing directory %s", err.Error())
+	}
+	l.usage.add(tenantDir(l.baseDir, tenantOpts), domain.Usage{
+		Originals:   domain.UsageCount{}.Sub(usage.Originals),
+		Derivatives: domain.UsageCount{}.Sub(usage.Derivatives),
+	})
+	return nil
+}
+
+func (l localImageStorageService) DeleteDerivedImages(name string, tenantOpts domain.TenantOpts) error {
+	path := parentImageDir(l.baseDir, tenantOpts, name)
+
+	dirEntry, err := os.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNoMatchingFile
+		}
+		return fmt.Errorf("internal error: %v", err)
+	}
+	// the parent image is the only file, everything derived from it lives in
+	// the format and attachment directories
+	settings := filepath.Base(settingDir(path))
+	var deleted domain.UsageCount
+	for _, e := range dirEntry {
+		if !e.IsDir() || e.Name() == settings {
+			continue
+		}
+		if isChildDir(path, e) {
+			usage, err := dirUsage(filepath.Join(path, e.Name()))
+			if err != nil {
+				return err
+			}
+			deleted = deleted.Add(usage)
+		}
+		if err = os.RemoveAll(filepath.Join(path, e.Name())); err != nil {
+			return fmt.Errorf("error while removing directory %s", err.Error())
+		}
+	}
+	l.usage.add(tenantDir(l.baseDir, tenantOpts), domain.Usage{Derivatives: domain.UsageCount{}.Sub(deleted)})
+	return nil
+}
+
+func (l localImageStorageService) DeleteChildImages(name string, tenantOpts domain.TenantOpts) (domain.UsageCount, error) {
+	path := parentImageDir(l.baseDir, tenantOpts, name)
+
+	dirEntry, err := os.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return domain.UsageCount{}, ErrNoMatchingFile
+		}
+		return domain.UsageCount{}, fmt.Errorf("internal error: %v", err)
+	}
+	var deleted domain.UsageCount
+	for _, e := range dirEntry {
+		if !isChildDir(path, e) {
+			continue
+		}
+		childDir := filepath.Join(path, e.Name())
+		usage, err := dirUsage(childDir)
+		if err != nil {
+			return deleted, err
+		}
+		if err = os.RemoveAll(childDir); err != nil {
+			return deleted, fmt.Errorf("error while removing directory %s", err.Error())
+		}
+		deleted = deleted.Add(usage)
+	}
+	l.usage.add(tenantDir(l.baseDir, tenantOpts), domain.Usage{Derivatives: domain.UsageCount{}.Sub(deleted)})
+	return deleted, nil
+}
+
+func (l localImageStorageService) Usage(tenantOpts domain.TenantOpts) (domain.Usage, error) {
+	if usage, ok := l.usage.get(tenantDir(l.baseDir, tenantOpts)); ok {
+		return usage, nil
+	}
+	return l.RecountUsage(tenantOpts)
+}
+
+func (l localImageStorageService) RecountUsage(tenantOpts domain.TenantOpts) (domain.Usage, error) {
+	dirEntry, err := os.ReadDir(tenantDir(l.baseDir, tenantOpts))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			l.usage.set(tenantDir(l.baseDir, tenantOpts), domain.Usage{})
+			return domain.Usage{}, nil
+		}
+		return domain.Usage{}, fmt.Errorf("internal error: %v", err)
+	}
+
+	var usage domain.Usage
+	for _, parent := range dirEntry {
+		if !parent.IsDir() {
+			continue
+		}
+		imageUsage, err := parentUsage(parentImageDir(l.baseDir, tenantOpts, parent.Name()))
+		if err != nil {
+			// purged while being counted
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return domain.Usage{}, fmt.Errorf("internal error: %v", err)
+		}
+		usage.Originals = usage.Originals.Add(imageUsage.Originals)
+		usage.Derivatives = usage.Derivatives.Add(imageUsage.Derivatives)
+	}
+	l.usage.set(tenantDir(l.baseDir, tenantOpts), usage)
+	return usage, nil
+}
+
+func (l localImageStorageService) ListTenants() ([]domain.TenantOpts, error) {
+	dirEntry, err := os.ReadDir(l.baseDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []domain.TenantOpts{}, nil
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+
+	tenants := make([]domain.TenantOpts, 0, len(dirEntry))
+	for _, e := range dirEntry {
+		if !e.IsDir() {
+			continue
+		}
+		// directories without a marker are not tenants, e.g. the job directory
+		content, err := os.ReadFile(filepath.Join(l.baseDir, e.Name(), tenantMarkerFile))
+		if err != nil {
+			continue
+		}
+		var tenantOpts domain.TenantOpts
+		if err = json.Unmarshal(content, &tenantOpts); err != nil {
+			continue
+		}
+		tenants = append(tenants, tenantOpts)
+	}
+	return tenants, nil
+}
+
 func NewLocalImageStorageService(baseDir string) ImageStorageServiceInterface {
 	return localImageStorageService{
 		baseDir: baseDir,
+		usage:   &usageCounter{tenants: map[string]domain.Usage{}},
 	}
 }
 
+// tenantDir is unambiguous as tenant and org codes can not contain "-", see
+// domain.TenantOpts.Validate.
+func tenantDir(baseUrl string, tenantOpts domain.TenantOpts) string {
+	return fmt.Sprintf("%s/%s-%s", baseUrl, tenantOpts.TenantCode, tenantOpts.OrgCode)
+}
+
 func parentImageDir(baseUrl string, tenantOpts domain.TenantOpts, name string) string {
-	return fmt.Sprintf("%s/%s-%s/%s", baseUrl, tenantOpts.TenantCode, tenantOpts.OrgCode, name)
+	return fmt.Sprintf("%s/%s", tenantDir(baseUrl, tenantOpts), name)
 }
 
 func childImageDir(parentDir string, format domain.ImageType, width, height int) string {
 	return fmt.Sprintf("%s/%s/%d/%d", parentDir, format, width, height)
 }
 
+// attachmentDir is kept apart from the parent image file so that it is never
+// mistaken for it, and its name never collides with a child format directory.
+func attachmentDir(parentDir string) string {
+	return fmt.Sprintf("%s/meta", parentDir)
+}
+
+// settingDir is named so that it never collides with a child format directory
+// either.
+func settingDir(parentDir string) string {
+	return fmt.Sprintf("%s/settings", parentDir)
+}
+
+// markTenant records the tenant of its directory, which can not be told from
+// the directory name as codes may contain the dash joining them.
+func markTenant(baseDir string, tenantOpts domain.TenantOpts) error {
+	marker := filepath.Join(tenantDir(baseDir, tenantOpts), tenantMarkerFile)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	content, err := json.Marshal(tenantOpts)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(marker, content, 0666); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+// isChildDir reports whether the entry of the parent directory holds child
+// images rather than attachments or settings.
+func isChildDir(parentDir string, e os.DirEntry) bool {
+	return e.IsDir() &&
+		e.Name() != filepath.Base(attachmentDir(parentDir)) &&
+		e.Name() != filepath.Base(settingDir(parentDir))
+}
+
+// parentUsage counts the parent image in path as an original and its child
+// images as derivatives.
+func parentUsage(path string) (domain.Usage, error) {
+	parentEntry, err := os.ReadDir(path)
+	if err != nil {
+		return domain.Usage{}, err
+	}
+	var usage domain.Usage
+	for _, e := range parentEntry {
+		switch {
+		case e.Type().IsRegular():
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			usage.Originals = usage.Originals.Add(domain.UsageCount{Objects: 1, Bytes: info.Size()})
+		case isChildDir(path, e):
+			childUsage, err := dirUsage(filepath.Join(path, e.Name()))
+			if err != nil {
+				return domain.Usage{}, err
+			}
+			usage.Derivatives = usage.Derivatives.Add(childUsage)
+		}
+	}
+	return usage, nil
+}
+
+// fileUsage counts the file at path, nothing if there is none.
+func fileUsage(path string) domain.UsageCount {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return domain.UsageCount{}
+	}
+	return domain.UsageCount{Objects: 1, Bytes: info.Size()}
+}
+
+// dirUsage counts the files under dir, files deleted while counting are skipped.
+func dirUsage(dir string) (domain.UsageCount, error) {
+	var usage domain.UsageCount
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		usage = usage.Add(domain.UsageCount{Objects: 1, Bytes: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return domain.UsageCount{}, fmt.Errorf("internal error: %v", err)
+	}
+	return usage, nil
+}
+
+func variantImageDir(childDir string, variant string) string {
+	if variant == "" {
+		return childDir
+	}
+	return fmt.Sprintf("%s/%s", childDir, variant)
+}
+
 func GenerateImageName() string {
-	return fmt.Sprintf("%d", time.Now().Nanosecond())
+	return randomId(imageNameLength)
 }
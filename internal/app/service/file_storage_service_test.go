@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -195,6 +196,28 @@ func TestStoreParentImage(t *testing.T) {
 		}
 
 	})
+
+	t.Run("concurrent uploads get distinct names", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+		names := make([]string, 50)
+		var wg sync.WaitGroup
+		for i := range names {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name, err := liss.StoreParentImage([]byte("parent"), domain.ImageType_JPEG, tenantOpts)
+				assert.NoError(t, err)
+				names[i] = name
+			}()
+		}
+		wg.Wait()
+
+		stored, err := liss.ListParentImages(tenantOpts)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, names, stored)
+	})
 }
 
 func TestStoreChildImage(t *testing.T) {
@@ -299,7 +322,10 @@ func TestGetChildImage(t *testing.T) {
 				t.Fatalf("error while reading image: %v", err)
 			}
 
-			image, err := liss.GetChildImage(tc.name, tc.format, tc.width, tc.height, tc.tenantOpts)
+			image, err := liss.GetChildImage(tc.name,
+				domain.ImageSpec{Width: tc.width, Height: tc.height, Format: tc.format},
+				tc.tenantOpts,
+			)
 
 			assert.NoError(t, err)
 			assert.Equal(t, expectedImage, image)
@@ -343,7 +369,10 @@ func TestGetChildImage(t *testing.T) {
 		liss := NewLocalImageStorageService(testEnvironBaseDir)
 
 		for _, tc := range testCases {
-			_, err := liss.GetChildImage(tc.name, tc.format, tc.width, tc.height, tc.tenantOpts)
+			_, err := liss.GetChildImage(tc.name,
+				domain.ImageSpec{Width: tc.width, Height: tc.height, Format: tc.format},
+				tc.tenantOpts,
+			)
 
 			assert.ErrorIs(t, err, ErrNoMatchingFile)
 		}
@@ -449,3 +478,242 @@ func TestGetParentImage(t *testing.T) {
 		}
 	})
 }
+
+func TestParentAttachment(t *testing.T) {
+	t.Run("an attachment can be fetched after it is stored", func(t *testing.T) {
+		if err := initTestEnvironment(); err != nil {
+			t.Fatalf("error initializing test environment: %v", err)
+		}
+		defer func() {
+			err := tearDownTestEnvironment()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		liss := NewLocalImageStorageService(testEnvironBaseDir)
+		parent := initTestEnvironStatus[1]
+		data := []byte("LEHV6nWB2yk8pyo0adR*.7kCMdnj")
+
+		err := liss.StoreParentAttachment(parent.name, "placeholder-blurhash", data, parent.tenantOpts)
+		assert.NoError(t, err)
+
+		storedData, err := liss.GetParentAttachment(parent.name, "placeholder-blurhash", parent.tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, data, storedData)
+
+		// the parent image is still found next to its attachments
+		expectedImage, err := os.ReadFile(parent.storedDir)
+		if err != nil {
+			t.Fatalf("error while reading image: %v", err)
+		}
+		image, err := liss.GetParentImage(parent.name, parent.tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedImage, image)
+	})
+
+	t.Run("an error should be returned if the attachment does not exist", func(t *testing.T) {
+		if err := initTestEnvironment(); err != nil {
+			t.Fatalf("error initializing test environment: %v", err)
+		}
+		defer func() {
+			err := tearDownTestEnvironment()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		liss := NewLocalImageStorageService(testEnvironBaseDir)
+		parent := initTestEnvironStatus[1]
+
+		_, err := liss.GetParentAttachment(parent.name, "placeholder-lqip", parent.tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNoMatchingFile)
+	})
+}
+
+func TestListParentImages(t *testing.T) {
+	t.Run("the parent images of a tenant are listed", func(t *testing.T) {
+		if err := initTestEnvironment(); err != nil {
+			t.Fatalf("error initializing test environment: %v", err)
+		}
+		defer func() {
+			err := tearDownTestEnvironment()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		liss := NewLocalImageStorageService(testEnvironBaseDir)
+		parent := initTestEnvironStatus[1]
+
+		names, err := liss.ListParentImages(parent.tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{parent.name}, names)
+	})
+
+	t.Run("a tenant without images has none listed", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+
+		names, err := liss.ListParentImages(domain.TenantOpts{TenantCode: "empty", OrgCode: "empty"})
+
+		assert.NoError(t, err)
+		assert.Empty(t, names)
+	})
+}
+
+func TestDeleteImages(t *testing.T) {
+	t.Run("derived images are deleted before the parent image", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"}
+		spec := domain.ImageSpec{Width: 100, Height: 50, Format: domain.ImageType_WEBP}
+
+		name, err := liss.StoreParentImage([]byte("parent"), domain.ImageType_JPEG, tenantOpts)
+		if err != nil {
+			t.Fatalf("error storing parent image: %v", err)
+		}
+		if err = liss.StoreChildImage([]byte("child"), name, spec, tenantOpts); err != nil {
+			t.Fatalf("error storing child image: %v", err)
+		}
+		if err = liss.StoreParentAttachment(name, "phash", []byte("00000000000000ff"), tenantOpts); err != nil {
+			t.Fatalf("error storing attachment: %v", err)
+		}
+		if err = liss.StoreParentSetting(name, "visibility", []byte("private"), tenantOpts); err != nil {
+			t.Fatalf("error storing setting: %v", err)
+		}
+
+		assert.NoError(t, liss.DeleteDerivedImages(name, tenantOpts))
+		setting, err := liss.GetParentSetting(name, "visibility", tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("private"), setting)
+		_, err = liss.GetChildImage(name, spec, tenantOpts)
+		assert.ErrorIs(t, err, ErrNoMatchingFile)
+		_, err = liss.GetParentAttachment(name, "phash", tenantOpts)
+		assert.ErrorIs(t, err, ErrNoMatchingFile)
+		parent, err := liss.GetParentImage(name, tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("parent"), parent)
+
+		assert.NoError(t, liss.DeleteParentImage(name, tenantOpts))
+		_, err = liss.GetParentImage(name, tenantOpts)
+		assert.ErrorIs(t, err, ErrNoMatchingFile)
+		_, err = liss.GetParentSetting(name, "visibility", tenantOpts)
+		assert.ErrorIs(t, err, ErrNoMatchingFile)
+		assert.ErrorIs(t, liss.DeleteParentImage(name, tenantOpts), ErrNoMatchingFile)
+		assert.ErrorIs(t, liss.DeleteDerivedImages(name, tenantOpts), ErrNoMatchingFile)
+	})
+}
+
+func TestStoreNamedParentImage(t *testing.T) {
+	t.Run("a parent image can be fetched by the name it was stored under", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"}
+
+		err := liss.StoreNamedParentImage("cat.jpg", []byte("parent"), domain.ImageType_JPEG, tenantOpts)
+		assert.NoError(t, err)
+
+		image, err := liss.GetParentImage("cat.jpg", tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("parent"), image)
+	})
+}
+
+func TestUsage(t *testing.T) {
+	t.Run("originals and derivatives are counted apart and child images can be evicted", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant_a", OrgCode: "org1"}
+		spec := domain.ImageSpec{Width: 100, Height: 50, Format: domain.ImageType_WEBP}
+
+		name, err := liss.StoreParentImage([]byte("parent"), domain.ImageType_JPEG, tenantOpts)
+		if err != nil {
+			t.Fatalf("error storing parent image: %v", err)
+		}
+		if err = liss.StoreChildImage([]byte("child"), name, spec, tenantOpts); err != nil {
+			t.Fatalf("error storing child image: %v", err)
+		}
+		spec.Variant = "q80"
+		if err = liss.StoreChildImage([]byte("variant"), name, spec, tenantOpts); err != nil {
+			t.Fatalf("error storing child image: %v", err)
+		}
+		if err = liss.StoreParentAttachment(name, "phash", []byte("00000000000000ff"), tenantOpts); err != nil {
+			t.Fatalf("error storing attachment: %v", err)
+		}
+
+		usage, err := liss.Usage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{
+			Originals:   domain.UsageCount{Objects: 1, Bytes: 6},
+			Derivatives: domain.UsageCount{Objects: 2, Bytes: 12},
+		}, usage)
+
+		tenants, err := liss.ListTenants()
+		assert.NoError(t, err)
+		assert.Equal(t, []domain.TenantOpts{tenantOpts}, tenants)
+		names, err := liss.ListParentImages(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{name}, names)
+
+		deleted, err := liss.DeleteChildImages(name, tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.UsageCount{Objects: 2, Bytes: 12}, deleted)
+		_, err = liss.GetParentAttachment(name, "phash", tenantOpts)
+		assert.NoError(t, err)
+
+		usage, err = liss.Usage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{Originals: domain.UsageCount{Objects: 1, Bytes: 6}}, usage)
+	})
+
+	t.Run("the usage is kept up to date without counting again until recounted", func(t *testing.T) {
+		baseDir := t.TempDir()
+		liss := NewLocalImageStorageService(baseDir)
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"}
+		spec := domain.ImageSpec{Width: 100, Height: 50, Format: domain.ImageType_WEBP}
+
+		usage, err := liss.Usage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{}, usage)
+
+		name, _ := liss.StoreParentImage([]byte("parent"), domain.ImageType_JPEG, tenantOpts)
+		_ = liss.StoreChildImage([]byte("child"), name, spec, tenantOpts)
+		_ = liss.StoreChildImage([]byte("larger child"), name, spec, tenantOpts)
+		other, _ := liss.StoreParentImage([]byte("other"), domain.ImageType_JPEG, tenantOpts)
+		_ = liss.StoreChildImage([]byte("child"), other, spec, tenantOpts)
+
+		usage, err = liss.Usage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{
+			Originals:   domain.UsageCount{Objects: 2, Bytes: 11},
+			Derivatives: domain.UsageCount{Objects: 2, Bytes: 17},
+		}, usage)
+
+		_ = liss.DeleteDerivedImages(name, tenantOpts)
+		_ = liss.DeleteParentImage(other, tenantOpts)
+
+		usage, err = liss.Usage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{Originals: domain.UsageCount{Objects: 1, Bytes: 6}}, usage)
+
+		// files written behind the storage's back are only seen once recounted
+		stray := filepath.Join(parentImageDir(baseDir, tenantOpts, name), "webp", "1", "1")
+		_ = os.MkdirAll(stray, 0750)
+		_ = os.WriteFile(filepath.Join(stray, name+".webp"), []byte("stray"), 0666)
+
+		usage, _ = liss.Usage(tenantOpts)
+		assert.Equal(t, domain.UsageCount{}, usage.Derivatives)
+		usage, err = liss.RecountUsage(tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.UsageCount{Objects: 1, Bytes: 5}, usage.Derivatives)
+		usage, _ = liss.Usage(tenantOpts)
+		assert.Equal(t, domain.UsageCount{Objects: 1, Bytes: 5}, usage.Derivatives)
+	})
+
+	t.Run("a tenant without images uses nothing", func(t *testing.T) {
+		liss := NewLocalImageStorageService(t.TempDir())
+
+		usage, err := liss.Usage(domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"})
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Usage{}, usage)
+	})
+}
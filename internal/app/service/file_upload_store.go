@@ -0,0 +1,299 @@
+package appsvc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadTooLarge       = errors.New("upload is too large")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadExceedsLength  = errors.New("upload exceeds its length")
+	ErrUploadLocked         = errors.New("upload is being written to")
+)
+
+const (
+	// ResumableUploadExpiry is how long an upload is kept after its last chunk
+	ResumableUploadExpiry = 24 * time.Hour
+	uploadIdLength        = 16
+)
+
+type ResumableUploadStoreInterface interface {
+	// Create stores a new empty upload and returns it with its id and expiry assigned
+	Create(upload domain.ResumableUpload) (domain.ResumableUpload, error)
+	// Get returns an upload which has not expired
+	Get(id string) (domain.ResumableUpload, error)
+	// Append writes the chunk at offset, which must be the offset of the upload.
+	// What was received is kept even if reading the chunk fails midway. An
+	// upload left received stays locked until it is released, so that only
+	// one request processes it.
+	Append(id string, offset int64, chunk io.Reader) (domain.ResumableUpload, error)
+	// Read returns the data of a received upload
+	Read(id string) ([]byte, error)
+	// Complete records the name of the image the upload was stored as and drops its data
+	Complete(id string, imageName string) (domain.ResumableUpload, error)
+	// Release unlocks an upload Append left locked
+	Release(id string)
+	Delete(id string) error
+	// DeleteExpired drops the uploads which expired and returns how many there were
+	DeleteExpired() (int, error)
+}
+
+// fileUploadStore keeps the state of each upload in a json file next to a file
+// holding the data received so far.
+type fileUploadStore struct {
+	dir string
+	now func() time.Time
+	// mu guards the info files and locks, locks keep concurrent requests from
+	// writing to the same upload
+	mu    *sync.Mutex
+	locks map[string]bool
+}
+
+func (f fileUploadStore) Create(upload domain.ResumableUpload) (domain.ResumableUpload, error) {
+	if upload.Length > domain.MaxResumableUploadSize {
+		return domain.ResumableUpload{}, ErrUploadTooLarge
+	}
+	upload.Id = randomId(uploadIdLength)
+	upload.Offset = 0
+	upload.ExpiresAt = f.now().Add(ResumableUploadExpiry)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.WriteFile(uploadDataFile(f.dir, upload.Id), nil, 0666); err != nil {
+		return domain.ResumableUpload{}, fmt.Errorf("error while writing file %s", err.Error())
+	}
+	if err := writeUploadInfo(f.dir, upload); err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	return upload, nil
+}
+
+func (f fileUploadStore) Get(id string) (domain.ResumableUpload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.get(id)
+}
+
+func (f fileUploadStore) get(id string) (domain.ResumableUpload, error) {
+	if !isUploadId(id) {
+		return domain.ResumableUpload{}, ErrUploadNotFound
+	}
+	upload, err := readUploadInfo(f.dir, id)
+	if err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	if upload.ImageName == "" && f.now().After(upload.ExpiresAt) {
+		return domain.ResumableUpload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (f fileUploadStore) Append(id string, offset int64, chunk io.Reader) (domain.ResumableUpload, error) {
+	f.mu.Lock()
+	upload, err := f.get(id)
+	if err != nil {
+		f.mu.Unlock()
+		return domain.ResumableUpload{}, err
+	}
+	if f.locks[id] {
+		f.mu.Unlock()
+		return domain.ResumableUpload{}, ErrUploadLocked
+	}
+	f.locks[id] = true
+	f.mu.Unlock()
+	keepLocked := false
+	defer func() {
+		if !keepLocked {
+			f.Release(id)
+		}
+	}()
+
+	if offset != upload.Offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+	file, err := os.OpenFile(uploadDataFile(f.dir, id), os.O_WRONLY, 0666)
+	if err != nil {
+		return domain.ResumableUpload{}, fmt.Errorf("internal error: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return domain.ResumableUpload{}, fmt.Errorf("internal error: %v", err)
+	}
+
+	written, copyErr := io.Copy(file, io.LimitReader(chunk, upload.Length-offset))
+	if copyErr == nil && written == upload.Length-offset {
+		// anything past the declared length is refused, what fits is kept
+		if n, _ := chunk.Read(make([]byte, 1)); n > 0 {
+			copyErr = ErrUploadExceedsLength
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload.Offset += written
+	upload.ExpiresAt = f.now().Add(ResumableUploadExpiry)
+	if err = writeUploadInfo(f.dir, upload); err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	keepLocked = upload.Received()
+	return upload, nil
+}
+
+func (f fileUploadStore) Release(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.locks, id)
+}
+
+func (f fileUploadStore) Read(id string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, err := f.get(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(uploadDataFile(f.dir, upload.Id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	return data, nil
+}
+
+func (f fileUploadStore) Complete(id string, imageName string) (domain.ResumableUpload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, err := f.get(id)
+	if err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	upload.ImageName = imageName
+	if err = writeUploadInfo(f.dir, upload); err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	if err = os.Remove(uploadDataFile(f.dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return domain.ResumableUpload{}, fmt.Errorf("error while removing file %s", err.Error())
+	}
+	return upload, nil
+}
+
+func (f fileUploadStore) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.get(id); err != nil {
+		return err
+	}
+	return removeUpload(f.dir, id)
+}
+
+func (f fileUploadStore) DeleteExpired() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dirEntry, err := os.ReadDir(f.dir)
+	if err != nil {
+		return 0, fmt.Errorf("internal error: %v", err)
+	}
+	deleted := 0
+	for _, e := range dirEntry {
+		id, ok := strings.CutSuffix(e.Name(), ".info")
+		if !ok || f.locks[id] {
+			continue
+		}
+		upload, err := readUploadInfo(f.dir, id)
+		if err != nil {
+			continue
+		}
+		// completed uploads are kept as long so that clients can still look
+		// up the name of their image
+		if f.now().After(upload.ExpiresAt) {
+			if err = removeUpload(f.dir, id); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func NewFileUploadStore(dir string) (ResumableUploadStoreInterface, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("error while making directory %s", err.Error())
+	}
+	return fileUploadStore{dir: dir, now: time.Now, mu: &sync.Mutex{}, locks: map[string]bool{}}, nil
+}
+
+func readUploadInfo(dir, id string) (domain.ResumableUpload, error) {
+	content, err := os.ReadFile(uploadInfoFile(dir, id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return domain.ResumableUpload{}, ErrUploadNotFound
+		}
+		return domain.ResumableUpload{}, fmt.Errorf("internal error: %v", err)
+	}
+	var upload domain.ResumableUpload
+	if err = json.Unmarshal(content, &upload); err != nil {
+		return domain.ResumableUpload{}, fmt.Errorf("error while parsing upload %s", err.Error())
+	}
+	return upload, nil
+}
+
+func writeUploadInfo(dir string, upload domain.ResumableUpload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := uploadInfoFile(dir, upload.Id) + ".tmp"
+	if err = os.WriteFile(tmp, content, 0666); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	if err = os.Rename(tmp, uploadInfoFile(dir, upload.Id)); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+func removeUpload(dir, id string) error {
+	for _, file := range []string{uploadDataFile(dir, id), uploadInfoFile(dir, id)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error while removing file %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func uploadInfoFile(dir, id string) string {
+	return filepath.Join(dir, id+".info")
+}
+
+func uploadDataFile(dir, id string) string {
+	return filepath.Join(dir, id+".bin")
+}
+
+// isUploadId keeps ids received from clients from pointing outside of the store.
+func isUploadId(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == uploadIdLength
+}
@@ -0,0 +1,147 @@
+package appsvc
+
+import (
+	"bytes"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func newTestUploadStore(t *testing.T) fileUploadStore {
+	store, err := NewFileUploadStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating upload store: %v", err)
+	}
+	return store.(fileUploadStore)
+}
+
+func TestFileUploadStore(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("an upload is received in chunks", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, err := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 10})
+		assert.NoError(t, err)
+		assert.True(t, isUploadId(upload.Id))
+
+		upload, err = store.Append(upload.Id, 0, strings.NewReader("hello"))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), upload.Offset)
+		assert.False(t, upload.Received())
+
+		_, err = store.Append(upload.Id, 3, strings.NewReader("world"))
+		assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+
+		upload, err = store.Append(upload.Id, 5, strings.NewReader("world"))
+		assert.NoError(t, err)
+		assert.True(t, upload.Received())
+		data, err := store.Read(upload.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "helloworld", string(data))
+
+		upload, err = store.Complete(upload.Id, "testimagename1")
+		assert.NoError(t, err)
+		got, err := store.Get(upload.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "testimagename1", got.ImageName)
+		assert.Equal(t, tenantOpts, got.TenantOpts)
+	})
+
+	t.Run("a chunk interrupted midway keeps what was received", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 10})
+
+		chunk := io.MultiReader(strings.NewReader("hel"), iotest.ErrReader(errors.New("connection reset")))
+		upload, err := store.Append(upload.Id, 0, chunk)
+		assert.Error(t, err)
+		assert.Equal(t, int64(3), upload.Offset)
+
+		got, _ := store.Get(upload.Id)
+		assert.Equal(t, int64(3), got.Offset)
+	})
+
+	t.Run("a chunk past the length is refused", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+
+		upload, err := store.Append(upload.Id, 0, bytes.NewReader([]byte("toolong")))
+
+		assert.ErrorIs(t, err, ErrUploadExceedsLength)
+		assert.Equal(t, int64(4), upload.Offset)
+	})
+
+	t.Run("an upload too large is refused", func(t *testing.T) {
+		store := newTestUploadStore(t)
+
+		_, err := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: domain.MaxResumableUploadSize + 1})
+
+		assert.ErrorIs(t, err, ErrUploadTooLarge)
+	})
+
+	t.Run("a locked upload can not be written to", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+		store.locks[upload.Id] = true
+
+		_, err := store.Append(upload.Id, 0, strings.NewReader("data"))
+
+		assert.ErrorIs(t, err, ErrUploadLocked)
+	})
+
+	t.Run("a received upload stays locked until it is released", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+
+		_, err := store.Append(upload.Id, 0, strings.NewReader("data"))
+		assert.NoError(t, err)
+		_, err = store.Append(upload.Id, 4, strings.NewReader(""))
+		assert.ErrorIs(t, err, ErrUploadLocked)
+
+		store.Release(upload.Id)
+		upload, err = store.Append(upload.Id, 4, strings.NewReader(""))
+		assert.NoError(t, err)
+		assert.True(t, upload.Received())
+	})
+
+	t.Run("expired uploads are not found and swept", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		expired, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+		store.now = func() time.Time { return now.Add(ResumableUploadExpiry / 2) }
+		active, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+		store.now = func() time.Time { return now.Add(ResumableUploadExpiry + time.Minute) }
+
+		_, err := store.Get(expired.Id)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		deleted, err := store.DeleteExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		_, err = store.Get(active.Id)
+		assert.NoError(t, err)
+	})
+
+	t.Run("ids outside the store are not found", func(t *testing.T) {
+		store := newTestUploadStore(t)
+
+		for _, id := range []string{"../jobs/x", "", "zz"} {
+			_, err := store.Get(id)
+			assert.ErrorIs(t, err, ErrUploadNotFound, id)
+		}
+	})
+
+	t.Run("a deleted upload is not found", func(t *testing.T) {
+		store := newTestUploadStore(t)
+		upload, _ := store.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: 4})
+
+		assert.NoError(t, store.Delete(upload.Id))
+
+		_, err := store.Get(upload.Id)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+	})
+}
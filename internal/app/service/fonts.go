@@ -0,0 +1,69 @@
+package appsvc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fontConfigTemplate = `<?xml version="1.0"?>
+<!DOCTYPE fontconfig SYSTEM "fonts.dtd">
+<fontconfig>
+	<include ignore_missing="yes">/etc/fonts/fonts.conf</include>
+	<dir>%s</dir>
+</fontconfig>
+`
+
+// registerFontDir makes the fonts in dir available to libvips text rendering by
+// pointing fontconfig at a generated configuration which adds dir to the system
+// fonts. It returns the font families found in dir, derived from the file names
+// (e.g. "Roboto-Bold.ttf" belongs to the "Roboto" family). Without a dir text
+// overlays are rendered with the system fonts, a dir without fonts is an error.
+// The configuration is written to a path derived from dir so restarts overwrite
+// it rather than leave a new file behind.
+func registerFontDir(dir string) (map[string]struct{}, error) {
+	families := map[string]struct{}{}
+	if dir == "" {
+		return families, nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving font directory %s", err.Error())
+	}
+	dirEntry, err := os.ReadDir(absDir)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading font directory %s", err.Error())
+	}
+	for _, e := range dirEntry {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".ttf" && ext != ".otf") {
+			continue
+		}
+		family, _, _ := strings.Cut(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())), "-")
+		families[family] = struct{}{}
+	}
+	if len(families) == 0 {
+		return nil, fmt.Errorf("no .ttf or .otf fonts found in font directory %s", absDir)
+	}
+
+	var escapedDir bytes.Buffer
+	if err = xml.EscapeText(&escapedDir, []byte(absDir)); err != nil {
+		return nil, fmt.Errorf("error while escaping font directory %s", err.Error())
+	}
+	dirHash := fnv.New64a()
+	_, _ = dirHash.Write([]byte(absDir))
+	configPath := filepath.Join(os.TempDir(), fmt.Sprintf("imageProc-fonts-%x.conf", dirHash.Sum64()))
+	config := fmt.Sprintf(fontConfigTemplate, escapedDir.String())
+	if err = os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		return nil, fmt.Errorf("error while writing font config %s", err.Error())
+	}
+	if err = os.Setenv("FONTCONFIG_FILE", configPath); err != nil {
+		return nil, err
+	}
+	return families, nil
+}
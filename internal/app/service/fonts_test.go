@@ -0,0 +1,52 @@
+package appsvc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegisterFontDir(t *testing.T) {
+	t.Setenv("FONTCONFIG_FILE", "")
+	t.Setenv("TMPDIR", t.TempDir())
+	fontDir := filepath.Join(t.TempDir(), "fonts & <brand>")
+	if err := os.Mkdir(fontDir, 0777); err != nil {
+		t.Fatalf("error creating font directory: %v", err)
+	}
+	for _, name := range []string{"Roboto-Regular.ttf", "Roboto-Bold.ttf", "Lobster.otf", "README.md"} {
+		if err := os.WriteFile(filepath.Join(fontDir, name), nil, 0666); err != nil {
+			t.Fatalf("error writing font file: %v", err)
+		}
+	}
+
+	families, err := registerFontDir(fontDir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"Roboto": {}, "Lobster": {}}, families)
+
+	configPath := os.Getenv("FONTCONFIG_FILE")
+	config, err := os.ReadFile(configPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), "<dir>"+filepath.Dir(fontDir)+"/fonts &amp; &lt;brand&gt;</dir>")
+
+	_, err = registerFontDir(fontDir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, configPath, os.Getenv("FONTCONFIG_FILE"), "a restart reuses the config file")
+	configs, _ := filepath.Glob(filepath.Join(os.TempDir(), "imageProc-fonts-*.conf"))
+	assert.Len(t, configs, 1)
+}
+
+func TestRegisterFontDirWithoutFonts(t *testing.T) {
+	t.Setenv("FONTCONFIG_FILE", "")
+	fontDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(fontDir, "README.md"), nil, 0666); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	_, err := registerFontDir(fontDir)
+
+	assert.ErrorContains(t, err, "no .ttf or .otf fonts found")
+	assert.Empty(t, os.Getenv("FONTCONFIG_FILE"))
+}
@@ -5,6 +5,8 @@ import (
 	"example.com/imageProc/internal/domain"
 	"fmt"
 	"github.com/davidbyttow/govips/v2/vips"
+	goimage "image"
+	"math"
 )
 
 type ImageProcessingServiceInterface interface {
@@ -15,11 +17,44 @@ type ImageProcessingServiceInterface interface {
 	Crop(image []byte, left, top, width, height int) ([]byte, error)
 	Resize(image []byte, scale float64) ([]byte, error)
 	Export(image []byte, imageType domain.ImageType) ([]byte, error)
+	Overlay(image []byte, overlay []byte, opts domain.OverlayOpts) ([]byte, error)
+	DrawText(image []byte, opts domain.TextOpts) ([]byte, error)
+	Shape(image []byte, opts domain.ShapeOpts) ([]byte, error)
+	ExtractFrame(image []byte, frame int) ([]byte, error)
+	Rasterize(image []byte, page int, density int) ([]byte, error)
+	GetPixels(image []byte) (*goimage.NRGBA, error)
+	AnalyzeColors(image []byte, count int) (domain.ColorAnalysis, error)
+	PerceptualHash(image []byte) (domain.PerceptualHash, error)
+	GetMetadata(image []byte) (domain.ImageMetadata, error)
+	Compress(image []byte, imageType domain.ImageType, maxBytes int) ([]byte, domain.Compression, error)
 }
 
-var ErrUnsupportedImageFormat = errors.New("unsupported image format")
+var (
+	ErrUnsupportedImageFormat = errors.New("unsupported image format")
+	ErrUnsupportedFont        = errors.New("unsupported font")
+	ErrFrameOutOfRange        = errors.New("frame out of range")
+	ErrPageOutOfRange         = errors.New("page out of range")
+	ErrBudgetUnreachable      = errors.New("image does not fit in the byte budget")
+)
 
-type VipsImageProcessorService struct{}
+const (
+	defaultFontFamily = "sans"
+	defaultFontSize   = 24
+	// colorAnalysisSize is the longest side images are shrunk to before their colors are analyzed
+	colorAnalysisSize = 64
+	// perceptualHashSize is the longest side images are shrunk to before they are hashed
+	perceptualHashSize = 64
+	// compressionQualities bound the encoder quality searched by Compress; lower
+	// qualities are better served by smaller dimensions
+	minCompressionQuality = 30
+	maxCompressionQuality = 95
+	// minCompressionWidth is the narrowest Compress shrinks images to
+	minCompressionWidth = 16
+)
+
+type VipsImageProcessorService struct {
+	fontFamilies map[string]struct{}
+}
 
 func (v VipsImageProcessorService) GetWidth(image []byte) (int, error) {
 	imageRef, err := vips.NewImageFromBuffer(image)
@@ -33,14 +68,14 @@ func (v VipsImageProcessorService) GetWidth(image []byte) (int, error) {
 }
 
 func (v VipsImageProcessorService) GetHeight(image []byte) (int, error) {
-	imageRef, err := vips.NewImageFromBuffer(image)
+	imageRef, err := loadImage(image)
 	if err != nil {
 		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
 			return 0, errors.New("unsupported image format")
 		}
 		return 0, fmt.Errorf("internal error: %v", err)
 	}
-	return imageRef.Height(), nil
+	return imageRef.PageHeight(), nil
 }
 
 func (v VipsImageProcessorService) GetFormat(image []byte) (domain.ImageType, error) {
@@ -51,44 +86,45 @@ func (v VipsImageProcessorService) GetFormat(image []byte) (domain.ImageType, er
 		}
 		return 0, fmt.Errorf("internal error: %v", err)
 	}
-	format, err := domain.ImageTypeFromString(imageRef.Format().FileExt())
+	format, err := domain.ImageTypeFromString(imageRef.OriginalFormat().FileExt())
 	if err != nil {
-		return -1, err
+		return -1, ErrUnsupportedImageFormat
 	}
 	return format, nil
 }
 
 func (v VipsImageProcessorService) GetSpec(image []byte) (domain.ImageSpec, error) {
-	imageRef, err := vips.NewImageFromBuffer(image)
+	imageRef, err := loadImage(image)
 	if err != nil {
 		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
 			return domain.ImageSpec{}, errors.New("unsupported image format")
 		}
 		return domain.ImageSpec{}, fmt.Errorf("internal error: %v", err)
 	}
-	format, err := domain.ImageTypeFromString(imageRef.Format().FileExt())
+	format, err := domain.ImageTypeFromString(imageRef.OriginalFormat().FileExt())
 	if err != nil {
 		return domain.ImageSpec{}, err
 	}
 	return domain.ImageSpec{
 		Width:  imageRef.Width(),
-		Height: imageRef.Height(),
+		Height: imageRef.PageHeight(),
 		Format: format,
 	}, nil
 }
 
 func (v VipsImageProcessorService) Crop(image []byte, left, top, width, height int) ([]byte, error) {
-	imageRef, err := vips.NewImageFromBuffer(image)
+	imageRef, err := loadImage(image)
 	if err != nil {
 		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
 			return nil, errors.New("unsupported image format")
 		}
 		return nil, fmt.Errorf("internal error: %v", err)
 	}
-	if err = imageRef.Crop(left, top, width, height); err != nil {
+	// unlike Crop, ExtractArea crops every frame of animated images
+	if err = imageRef.ExtractArea(left, top, width, height); err != nil {
 		return nil, err
 	}
-	format, err := domain.ImageTypeFromString(imageRef.Format().FileExt())
+	format, err := workingFormat(imageRef)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +136,7 @@ func (v VipsImageProcessorService) Crop(image []byte, left, top, width, height i
 }
 
 func (v VipsImageProcessorService) Resize(image []byte, scale float64) ([]byte, error) {
-	imageRef, err := vips.NewImageFromBuffer(image)
+	imageRef, err := loadImage(image)
 	if err != nil {
 		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
 			return nil, errors.New("unsupported image format")
@@ -110,7 +146,7 @@ func (v VipsImageProcessorService) Resize(image []byte, scale float64) ([]byte,
 	if err = imageRef.Resize(scale, vips.KernelAuto); err != nil {
 		return nil, err
 	}
-	format, err := domain.ImageTypeFromString(imageRef.Format().FileExt())
+	format, err := workingFormat(imageRef)
 	if err != nil {
 		return nil, err
 	}
@@ -122,13 +158,350 @@ func (v VipsImageProcessorService) Resize(image []byte, scale float64) ([]byte,
 }
 
 func (v VipsImageProcessorService) Export(image []byte, format domain.ImageType) ([]byte, error) {
-	imageRef, err := vips.NewImageFromBuffer(image)
+	imageRef, err := loadImage(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	image, err = exportImage(imageRef, format)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (v VipsImageProcessorService) Overlay(image []byte, overlay []byte, opts domain.OverlayOpts) ([]byte, error) {
+	imageRef, err := loadImage(image)
 	if err != nil {
 		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
 			return nil, errors.New("unsupported image format")
 		}
 		return nil, fmt.Errorf("internal error: %v", err)
 	}
+	overlayRef, err := vips.NewImageFromBuffer(overlay)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported overlay format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	defer overlayRef.Close()
+
+	if opts.Scale > 0 {
+		scale := float64(imageRef.Width()) * opts.Scale / float64(overlayRef.Width())
+		if err = overlayRef.Resize(scale, vips.KernelAuto); err != nil {
+			return nil, err
+		}
+	}
+	if err = overlayRef.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return nil, err
+	}
+	if !overlayRef.HasAlpha() {
+		if err = overlayRef.AddAlpha(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Opacity > 0 && opts.Opacity < 1 {
+		if err = overlayRef.Linear([]float64{1, 1, 1, opts.Opacity}, []float64{0, 0, 0, 0}); err != nil {
+			return nil, err
+		}
+	}
+
+	// animated images are pages stacked vertically, every one is overlaid alike
+	pages := imageRef.Height() / imageRef.PageHeight()
+	if opts.Tile {
+		// the margin acts as the gap between tiles
+		if opts.Margin > 0 {
+			if err = overlayRef.EmbedBackgroundRGBA(0, 0,
+				overlayRef.Width()+opts.Margin, overlayRef.Height()+opts.Margin,
+				&vips.ColorRGBA{}); err != nil {
+				return nil, err
+			}
+		}
+		across := imageRef.Width()/overlayRef.Width() + 1
+		down := imageRef.PageHeight()/overlayRef.Height() + 1
+		if err = overlayRef.Replicate(across, down); err != nil {
+			return nil, err
+		}
+		if err = overlayRef.ExtractArea(0, 0, imageRef.Width(), imageRef.PageHeight()); err != nil {
+			return nil, err
+		}
+		if pages > 1 {
+			if err = overlayRef.Replicate(1, pages); err != nil {
+				return nil, err
+			}
+		}
+		if err = imageRef.Composite(overlayRef, vips.BlendModeOver, 0, 0); err != nil {
+			return nil, err
+		}
+	} else if pages > 1 {
+		// place the overlay on a transparent page sized layer and repeat it for every frame
+		left, top := opts.Gravity.Position(imageRef.Width(), imageRef.PageHeight(),
+			overlayRef.Width(), overlayRef.Height(), opts.Margin)
+		if err = overlayRef.EmbedBackgroundRGBA(left, top, imageRef.Width(), imageRef.PageHeight(),
+			&vips.ColorRGBA{}); err != nil {
+			return nil, err
+		}
+		if err = overlayRef.Replicate(1, pages); err != nil {
+			return nil, err
+		}
+		if err = imageRef.Composite(overlayRef, vips.BlendModeOver, 0, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		left, top := opts.Gravity.Position(imageRef.Width(), imageRef.Height(),
+			overlayRef.Width(), overlayRef.Height(), opts.Margin)
+		if err = imageRef.Composite(overlayRef, vips.BlendModeOver, left, top); err != nil {
+			return nil, err
+		}
+	}
+
+	format, err := workingFormat(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	image, err = exportImage(imageRef, format)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// DrawText renders the text on top of the image, on every frame of animated
+// images alike.
+func (v VipsImageProcessorService) DrawText(image []byte, opts domain.TextOpts) ([]byte, error) {
+	font := defaultFontFamily
+	if opts.Font != "" {
+		if _, ok := v.fontFamilies[opts.Font]; !ok {
+			return nil, ErrUnsupportedFont
+		}
+		font = opts.Font
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = defaultFontSize
+	}
+
+	imageRef, err := loadImage(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	tops := pageTops(imageRef.Height(), imageRef.PageHeight())
+	pageHeight := imageRef.Height() / len(tops)
+	wrapWidth := opts.WrapWidth
+	if wrapWidth <= 0 {
+		wrapWidth = imageRef.Width() - 2*opts.Margin
+	}
+	label := vips.LabelParams{
+		Text:    opts.Text,
+		Font:    fmt.Sprintf("%s %d", font, size),
+		Width:   vips.ValueOf(float64(wrapWidth)),
+		Opacity: 1,
+		Color:   vips.Color{R: 255, G: 255, B: 255},
+	}
+
+	// render the text once on a blank page to measure its ink bounding box
+	canvas, err := vips.Black(imageRef.Width(), pageHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer canvas.Close()
+	if err = canvas.Label(&label); err != nil {
+		return nil, err
+	}
+	inkLeft, inkTop, inkWidth, inkHeight, err := canvas.FindTrim(10, &vips.Color{})
+	if err != nil {
+		return nil, err
+	}
+	if inkWidth == 0 || inkHeight == 0 {
+		return image, nil
+	}
+
+	left, top := opts.Gravity.Position(imageRef.Width(), pageHeight, inkWidth, inkHeight, opts.Margin)
+	if opts.Background != nil {
+		padding := size / 4
+		background := vips.ColorRGBA{
+			R: opts.Background.R,
+			G: opts.Background.G,
+			B: opts.Background.B,
+			A: opts.Background.A,
+		}
+		for _, pageTop := range tops {
+			if err = imageRef.DrawRect(background,
+				left-padding, pageTop+top-padding, inkWidth+2*padding, inkHeight+2*padding, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// text is drawn on the colour bands only; the alpha band is joined back afterwards
+	var alpha *vips.ImageRef
+	if imageRef.HasAlpha() {
+		alpha, err = imageRef.ExtractBandToImage(imageRef.Bands()-1, 1)
+		if err != nil {
+			return nil, err
+		}
+		defer alpha.Close()
+		if err = imageRef.ExtractBand(0, imageRef.Bands()-1); err != nil {
+			return nil, err
+		}
+	}
+	label.OffsetX = vips.ValueOf(float64(left - inkLeft))
+	label.Opacity = float32(opts.Color.A) / 255
+	label.Color = vips.Color{R: opts.Color.R, G: opts.Color.G, B: opts.Color.B}
+	for _, pageTop := range tops {
+		label.OffsetY = vips.ValueOf(float64(pageTop + top - inkTop))
+		if err = imageRef.Label(&label); err != nil {
+			return nil, err
+		}
+	}
+	if alpha != nil {
+		if err = imageRef.BandJoin(alpha); err != nil {
+			return nil, err
+		}
+	}
+
+	format, err := workingFormat(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	image, err = exportImage(imageRef, format)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Shape masks, pads and fills the background of the image, every frame of
+// animated images alike. Still images which end up with an alpha channel are
+// returned as PNG so the transparency survives until the final export, animated
+// ones keep their format which holds both the frames and the transparency.
+func (v VipsImageProcessorService) Shape(image []byte, opts domain.ShapeOpts) ([]byte, error) {
+	imageRef, err := loadImage(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	tops := pageTops(imageRef.Height(), imageRef.PageHeight())
+	pageHeight := imageRef.Height() / len(tops)
+	if err = imageRef.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return nil, err
+	}
+	if !imageRef.HasAlpha() {
+		if err = imageRef.AddAlpha(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Mask != domain.Mask_NONE || opts.Radius > 0 {
+		maskRef, err := vips.NewImageFromBuffer(maskSvg(imageRef.Width(), pageHeight, opts))
+		if err != nil {
+			return nil, fmt.Errorf("internal error: %v", err)
+		}
+		defer maskRef.Close()
+		if len(tops) > 1 {
+			if err = maskRef.Replicate(1, len(tops)); err != nil {
+				return nil, err
+			}
+		}
+		if err = imageRef.Composite(maskRef, vips.BlendModeDestIn, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Padding > 0 {
+		if err = padPages(imageRef, tops, pageHeight, opts.Padding); err != nil {
+			return nil, err
+		}
+		pageHeight += 2 * opts.Padding
+	}
+	if opts.Background != nil && opts.Background.A == 0xff {
+		if err = imageRef.Flatten(&vips.Color{
+			R: opts.Background.R,
+			G: opts.Background.G,
+			B: opts.Background.B,
+		}); err != nil {
+			return nil, err
+		}
+	} else if opts.Background != nil && opts.Background.A > 0 {
+		backgroundRef, err := vips.Black(imageRef.Width(), imageRef.Height())
+		if err != nil {
+			return nil, err
+		}
+		defer backgroundRef.Close()
+		if err = backgroundRef.Linear(
+			[]float64{0, 0, 0, 0},
+			[]float64{float64(opts.Background.R), float64(opts.Background.G),
+				float64(opts.Background.B), float64(opts.Background.A)},
+		); err != nil {
+			return nil, err
+		}
+		if err = backgroundRef.Cast(vips.BandFormatUchar); err != nil {
+			return nil, err
+		}
+		if err = backgroundRef.Composite(imageRef, vips.BlendModeOver, 0, 0); err != nil {
+			return nil, err
+		}
+		// the blank canvas knows nothing of the frames it was composited with
+		if len(tops) > 1 {
+			if err = backgroundRef.SetPageHeight(pageHeight); err != nil {
+				return nil, err
+			}
+		}
+		imageRef = backgroundRef
+	}
+
+	format := domain.ImageType_PNG
+	if !imageRef.HasAlpha() || len(tops) > 1 {
+		format, err = workingFormat(imageRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	image, err = exportImage(imageRef, format)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// ExtractFrame returns the given zero based frame of an animated image as a still
+// in the format of the image.
+func (v VipsImageProcessorService) ExtractFrame(image []byte, frame int) ([]byte, error) {
+	imageRef, err := loadImage(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	pages := imageRef.Height() / imageRef.PageHeight()
+	if frame < 0 || frame >= pages {
+		return nil, ErrFrameOutOfRange
+	}
+	if pages > 1 {
+		// treat the frame strip as a single page so the frame is cut out of it as is
+		pageHeight := imageRef.PageHeight()
+		if err = imageRef.SetPageHeight(imageRef.Height()); err != nil {
+			return nil, err
+		}
+		if err = imageRef.ExtractArea(0, frame*pageHeight, imageRef.Width(), pageHeight); err != nil {
+			return nil, err
+		}
+		if err = imageRef.SetPages(1); err != nil {
+			return nil, err
+		}
+	}
+	format, err := workingFormat(imageRef)
+	if err != nil {
+		return nil, err
+	}
 	image, err = exportImage(imageRef, format)
 	if err != nil {
 		return nil, err
@@ -136,11 +509,203 @@ func (v VipsImageProcessorService) Export(image []byte, format domain.ImageType)
 	return image, nil
 }
 
-func NewVipsImageProcessorService() ImageProcessingServiceInterface {
-	return VipsImageProcessorService{}
+// Rasterize renders the given zero based page of a multi-page or vector image at
+// density dpi and returns it as PNG. A zero density keeps the loader default.
+func (v VipsImageProcessorService) Rasterize(image []byte, page int, density int) ([]byte, error) {
+	firstPageRef, err := vips.NewImageFromBuffer(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	pages := firstPageRef.Pages()
+	firstPageRef.Close()
+	if page < 0 || page >= pages {
+		return nil, ErrPageOutOfRange
+	}
+
+	params := vips.NewImportParams()
+	params.Page.Set(page)
+	if density > 0 {
+		params.Density.Set(density)
+	}
+	imageRef, err := vips.LoadImageFromBuffer(image, params)
+	if err != nil {
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	image, err = exportImage(imageRef, domain.ImageType_PNG)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// GetPixels decodes the first frame of the image into 8 bit sRGB pixels with an
+// alpha channel, which is what pure go encoders such as the placeholder hashes need.
+func (v VipsImageProcessorService) GetPixels(image []byte) (*goimage.NRGBA, error) {
+	imageRef, err := vips.NewImageFromBuffer(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	return toPixels(imageRef)
+}
+
+// AnalyzeColors computes the dominant color and a palette of at most count colors
+// of the first frame of the image.
+func (v VipsImageProcessorService) AnalyzeColors(image []byte, count int) (domain.ColorAnalysis, error) {
+	pixels, err := thumbnailPixels(image, colorAnalysisSize)
+	if err != nil {
+		return domain.ColorAnalysis{}, err
+	}
+	analysis, err := domain.ExtractPalette(pixels, count)
+	if err != nil {
+		return domain.ColorAnalysis{}, err
+	}
+	return analysis, nil
+}
+
+// PerceptualHash computes the difference hash of the first frame of the image.
+func (v VipsImageProcessorService) PerceptualHash(image []byte) (domain.PerceptualHash, error) {
+	pixels, err := thumbnailPixels(image, perceptualHashSize)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := domain.DHash(pixels)
+	if err != nil {
+		return 0, err
+	}
+	return hash, nil
+}
+
+// Compress exports the image in the given format at the highest encoder quality
+// that fits in maxBytes. When even the lowest quality doesn't fit, the image is
+// shrunk until it does.
+func (v VipsImageProcessorService) Compress(image []byte, imageType domain.ImageType, maxBytes int) ([]byte, domain.Compression, error) {
+	imageRef, err := loadImage(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, domain.Compression{}, errors.New("unsupported image format")
+		}
+		return nil, domain.Compression{}, fmt.Errorf("internal error: %v", err)
+	}
+
+	for {
+		var best []byte
+		bestQuality, smallestSize := 0, 0
+		// the output size grows with the quality, binary search the highest that fits
+		low, high := minCompressionQuality, maxCompressionQuality
+		for low <= high {
+			quality := (low + high) / 2
+			out, err := exportImageWithQuality(imageRef, imageType, quality)
+			if err != nil {
+				return nil, domain.Compression{}, err
+			}
+			if len(out) <= maxBytes {
+				best, bestQuality = out, quality
+				low = quality + 1
+			} else {
+				smallestSize = len(out)
+				high = quality - 1
+			}
+		}
+		if best != nil {
+			return best, domain.Compression{
+				Quality: bestQuality,
+				Width:   imageRef.Width(),
+				Height:  imageRef.PageHeight(),
+			}, nil
+		}
+
+		// the size is roughly proportional to the area, aim a little below the budget
+		scale := math.Sqrt(float64(maxBytes)/float64(smallestSize)) * 0.9
+		if float64(imageRef.Width())*scale < minCompressionWidth {
+			return nil, domain.Compression{}, ErrBudgetUnreachable
+		}
+		if err = imageRef.Resize(scale, vips.KernelAuto); err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+}
+
+// GetMetadata reads the technical metadata of the image along with its EXIF
+// camera, date and location fields. Nothing but the header is decoded.
+func (v VipsImageProcessorService) GetMetadata(image []byte) (domain.ImageMetadata, error) {
+	imageRef, err := vips.NewImageFromBuffer(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return domain.ImageMetadata{}, errors.New("unsupported image format")
+		}
+		return domain.ImageMetadata{}, fmt.Errorf("internal error: %v", err)
+	}
+	format, err := domain.ImageTypeFromString(imageRef.OriginalFormat().FileExt())
+	if err != nil {
+		return domain.ImageMetadata{}, ErrUnsupportedImageFormat
+	}
+
+	metadata := domain.ImageMetadata{
+		Width:       imageRef.Width(),
+		Height:      imageRef.PageHeight(),
+		Format:      format,
+		Orientation: imageRef.Orientation(),
+		ColorSpace:  colorSpaceName(imageRef.Interpretation()),
+		BitDepth:    bitDepth(imageRef.BandFormat()),
+		HasAlpha:    imageRef.HasAlpha(),
+		Pages:       1,
+		Frames:      1,
+		HasIptc:     imageRef.HasIPTC(),
+	}
+	// only the first page is loaded, libvips reports the total in n-pages
+	if pages := imageRef.Pages(); pages > 1 {
+		if format == domain.ImageType_GIF || format == domain.ImageType_WEBP {
+			metadata.Frames = pages
+		} else {
+			metadata.Pages = pages
+		}
+	}
+	if imageRef.HasICCProfile() {
+		metadata.IccProfile = domain.IccProfileDescription(imageRef.GetICCProfile())
+	}
+	// libvips keeps the resolution in pixels per millimetre and defaults it to 1
+	// when the image doesn't declare one
+	if resX, resY := imageRef.ResX(), imageRef.ResY(); resX > 1 || resY > 1 {
+		metadata.Dpi = &domain.Resolution{
+			X: math.Round(resX*25.4*100) / 100,
+			Y: math.Round(resY*25.4*100) / 100,
+		}
+	}
+	for _, field := range imageRef.GetFields() {
+		if field == "xmp-data" {
+			metadata.HasXmp = true
+		}
+	}
+	metadata.ApplyExif(imageRef.GetExif())
+	return metadata, nil
+}
+
+// NewVipsImageProcessorService creates a libvips backed processor. The fonts in
+// fontDir become available to text overlays by their family name.
+func NewVipsImageProcessorService(fontDir string) (ImageProcessingServiceInterface, error) {
+	fontFamilies, err := registerFontDir(fontDir)
+	if err != nil {
+		return nil, err
+	}
+	return VipsImageProcessorService{
+		fontFamilies: fontFamilies,
+	}, nil
 }
 
 func exportImage(imageRef *vips.ImageRef, imgType domain.ImageType) ([]byte, error) {
+	return exportImageWithQuality(imageRef, imgType, 0)
+}
+
+// exportImageWithQuality exports the image at the given encoder quality in range
+// 1..100; zero keeps the encoder default. PNGs are quantized to a palette when a
+// quality is given.
+func exportImageWithQuality(imageRef *vips.ImageRef, imgType domain.ImageType, quality int) ([]byte, error) {
 	var (
 		image []byte
 		err   error
@@ -148,16 +713,237 @@ func exportImage(imageRef *vips.ImageRef, imgType domain.ImageType) ([]byte, err
 
 	switch imgType {
 	case domain.ImageType_JPEG:
-		image, _, err = imageRef.ExportJpeg(vips.NewJpegExportParams())
+		// jpeg has no alpha channel, flatten on white rather than libvips' default black
+		if imageRef.HasAlpha() {
+			if err = imageRef.Flatten(&vips.Color{R: 255, G: 255, B: 255}); err != nil {
+				return nil, err
+			}
+		}
+		params := vips.NewJpegExportParams()
+		if quality > 0 {
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportJpeg(params)
 	case domain.ImageType_WEBP:
-		image, _, err = imageRef.ExportWebp(vips.NewWebpExportParams())
+		params := vips.NewWebpExportParams()
+		if quality > 0 {
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportWebp(params)
 	case domain.ImageType_AVIF:
-		image, _, err = imageRef.ExportAvif(vips.NewAvifExportParams())
+		params := vips.NewAvifExportParams()
+		if quality > 0 {
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportAvif(params)
 	case domain.ImageType_PNG:
-		image, _, err = imageRef.ExportPng(vips.NewPngExportParams())
+		params := vips.NewPngExportParams()
+		if quality > 0 {
+			params.Palette = true
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportPng(params)
+	case domain.ImageType_GIF:
+		params := vips.NewGifExportParams()
+		if quality > 0 {
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportGIF(params)
+	case domain.ImageType_JXL:
+		params := vips.NewJxlExportParams()
+		if quality > 0 {
+			params.Quality = quality
+		}
+		image, _, err = imageRef.ExportJxl(params)
+	default:
+		return nil, ErrUnsupportedImageFormat
 	}
 	if err != nil {
 		return nil, err
 	}
 	return image, nil
 }
+
+// loadImage loads every frame of animated formats stacked vertically, libvips'
+// representation of animations, and only the first page of any other format.
+func loadImage(image []byte) (*vips.ImageRef, error) {
+	params := vips.NewImportParams()
+	switch vips.DetermineImageType(image) {
+	case vips.ImageTypeGIF, vips.ImageTypeWEBP:
+		params.NumPages.Set(-1)
+	}
+	return vips.LoadImageFromBuffer(image, params)
+}
+
+// padPages pads every page of the image alike. The pages of animated images are
+// cut apart and joined back, padding the stack would only pad its ends.
+func padPages(imageRef *vips.ImageRef, tops []int, pageHeight, padding int) error {
+	width := imageRef.Width()
+	others := make([]*vips.ImageRef, 0, len(tops)-1)
+	defer func() {
+		for _, page := range others {
+			page.Close()
+		}
+	}()
+	for _, top := range tops[1:] {
+		page, err := imageRef.Copy()
+		if err != nil {
+			return err
+		}
+		others = append(others, page)
+		if err = page.ExtractArea(0, top, width, pageHeight); err != nil {
+			return err
+		}
+	}
+	if err := imageRef.ExtractArea(0, 0, width, pageHeight); err != nil {
+		return err
+	}
+	for _, page := range append([]*vips.ImageRef{imageRef}, others...) {
+		if err := page.EmbedBackgroundRGBA(padding, padding, width+2*padding, pageHeight+2*padding,
+			&vips.ColorRGBA{}); err != nil {
+			return err
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	if err := imageRef.ArrayJoin(others, 1); err != nil {
+		return err
+	}
+	return imageRef.SetPageHeight(pageHeight + 2*padding)
+}
+
+// pageTops returns the top of every page of an image, animated images being
+// their frames stacked vertically. Images without a usable page height are a
+// single page.
+func pageTops(height, pageHeight int) []int {
+	if pageHeight <= 0 || pageHeight >= height || height%pageHeight != 0 {
+		return []int{0}
+	}
+	tops := make([]int, 0, height/pageHeight)
+	for top := 0; top < height; top += pageHeight {
+		tops = append(tops, top)
+	}
+	return tops
+}
+
+// workingFormat returns the format intermediate results are exported in, which is
+// the format of the image unless it cannot be written, e.g. SVG, in which case
+// the lossless PNG is used.
+func workingFormat(imageRef *vips.ImageRef) (domain.ImageType, error) {
+	format, err := domain.ImageTypeFromString(imageRef.Format().FileExt())
+	if err != nil {
+		return -1, err
+	}
+	if !format.IsOutputFormat() {
+		return domain.ImageType_PNG, nil
+	}
+	return format, nil
+}
+
+// thumbnailPixels decodes the first frame of the image shrunk so its longest side
+// is at most size pixels. Analyses of a small thumbnail are as good as the ones of
+// the full image and a lot cheaper.
+func thumbnailPixels(image []byte, size int) (*goimage.NRGBA, error) {
+	imageRef, err := vips.NewImageFromBuffer(image)
+	if err != nil {
+		if errors.Is(err, vips.ErrUnsupportedImageFormat) {
+			return nil, errors.New("unsupported image format")
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	if longestSide := max(imageRef.Width(), imageRef.PageHeight()); longestSide > size {
+		if err = imageRef.Resize(float64(size)/float64(longestSide), vips.KernelAuto); err != nil {
+			return nil, err
+		}
+	}
+	return toPixels(imageRef)
+}
+
+// toPixels converts the first frame of the image to 8 bit sRGB with alpha.
+func toPixels(imageRef *vips.ImageRef) (*goimage.NRGBA, error) {
+	if err := imageRef.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return nil, err
+	}
+	if !imageRef.HasAlpha() {
+		if err := imageRef.AddAlpha(); err != nil {
+			return nil, err
+		}
+	}
+	if err := imageRef.Cast(vips.BandFormatUchar); err != nil {
+		return nil, err
+	}
+	pixels, err := imageRef.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	width, height := imageRef.Width(), imageRef.PageHeight()
+	if len(pixels) < width*height*4 {
+		return nil, fmt.Errorf("internal error: unexpected pixel buffer size %d", len(pixels))
+	}
+	return &goimage.NRGBA{
+		Pix:    pixels[:width*height*4],
+		Stride: width * 4,
+		Rect:   goimage.Rect(0, 0, width, height),
+	}, nil
+}
+
+func colorSpaceName(interpretation vips.Interpretation) string {
+	switch interpretation {
+	case vips.InterpretationSRGB:
+		return "srgb"
+	case vips.InterpretationRGB:
+		return "rgb"
+	case vips.InterpretationRGB16:
+		return "rgb16"
+	case vips.InterpretationScRGB:
+		return "scrgb"
+	case vips.InterpretationBW:
+		return "b-w"
+	case vips.InterpretationGrey16:
+		return "grey16"
+	case vips.InterpretationCMYK:
+		return "cmyk"
+	case vips.InterpretationLAB, vips.InterpretationLABQ, vips.InterpretationLABS:
+		return "lab"
+	case vips.InterpretationLCH:
+		return "lch"
+	case vips.InterpretationXYZ:
+		return "xyz"
+	case vips.InterpretationHSV:
+		return "hsv"
+	default:
+		return "multiband"
+	}
+}
+
+func bitDepth(bandFormat vips.BandFormat) int {
+	switch bandFormat {
+	case vips.BandFormatUchar, vips.BandFormatChar:
+		return 8
+	case vips.BandFormatUshort, vips.BandFormatShort:
+		return 16
+	case vips.BandFormatUint, vips.BandFormatInt, vips.BandFormatFloat:
+		return 32
+	case vips.BandFormatDouble:
+		return 64
+	default:
+		return 0
+	}
+}
+
+// maskSvg renders an opaque shape on a transparent canvas of the given size which
+// is used to cut out either a circle or a rounded rectangle.
+func maskSvg(width, height int, opts domain.ShapeOpts) []byte {
+	var shape string
+	if opts.Mask == domain.Mask_CIRCLE {
+		radius := min(width, height) / 2
+		shape = fmt.Sprintf(`<circle cx="%g" cy="%g" r="%d" fill="#fff"/>`,
+			float64(width)/2, float64(height)/2, radius)
+	} else {
+		shape = fmt.Sprintf(`<rect x="0" y="0" width="%d" height="%d" rx="%d" ry="%d" fill="#fff"/>`,
+			width, height, opts.Radius, opts.Radius)
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">%s</svg>`,
+		width, height, shape))
+}
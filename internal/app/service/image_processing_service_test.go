@@ -0,0 +1,23 @@
+package appsvc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPageTops(t *testing.T) {
+	testCases := []struct {
+		name       string
+		height     int
+		pageHeight int
+		expected   []int
+	}{
+		{name: "a still is a single page", height: 300, pageHeight: 300, expected: []int{0}},
+		{name: "frames are stacked vertically", height: 300, pageHeight: 100, expected: []int{0, 100, 200}},
+		{name: "a missing page height", height: 300, pageHeight: 0, expected: []int{0}},
+		{name: "a page height not dividing the height", height: 300, pageHeight: 70, expected: []int{0}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, pageTops(tc.height, tc.pageHeight), tc.name)
+	}
+}
@@ -0,0 +1,198 @@
+package appsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidRemoteUrl       = errors.New("invalid remote url")
+	ErrForbiddenRemoteAddress = errors.New("remote address is not allowed")
+	ErrRemoteTooLarge         = errors.New("remote image is too large")
+	ErrRemoteNotImage         = errors.New("remote resource is not an image")
+	ErrRemoteNotFound         = errors.New("remote image not found")
+	ErrRemoteFetchFailed      = errors.New("error fetching remote image")
+)
+
+const (
+	remoteFetchTimeout     = 30 * time.Second
+	remoteDialTimeout      = 5 * time.Second
+	remoteMaxRedirects     = 5
+	DefaultRemoteFetchSize = 50 << 20
+)
+
+// blockedNetworks are never fetched from unless allow-listed: they reach the
+// host itself, the private networks it sits in or cloud metadata services.
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+type RemoteFetchServiceInterface interface {
+	// Fetch downloads the image at the http or https url
+	Fetch(ctx context.Context, rawUrl string) ([]byte, error)
+}
+
+type httpRemoteFetchService struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func (h httpRemoteFetchService) Fetch(ctx context.Context, rawUrl string) ([]byte, error) {
+	if err := checkRemoteUrl(rawUrl); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, ErrInvalidRemoteUrl
+	}
+	req.Header.Set("Accept", "image/*")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		for _, target := range []error{ErrForbiddenRemoteAddress, ErrInvalidRemoteUrl} {
+			if errors.Is(err, target) {
+				return nil, target
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrRemoteFetchFailed, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		return nil, ErrRemoteNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrRemoteFetchFailed, res.StatusCode)
+	}
+	if res.ContentLength > h.maxBytes {
+		return nil, ErrRemoteTooLarge
+	}
+
+	image, err := io.ReadAll(io.LimitReader(res.Body, h.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRemoteFetchFailed, err)
+	}
+	if int64(len(image)) > h.maxBytes {
+		return nil, ErrRemoteTooLarge
+	}
+	if !looksLikeImage(res.Header.Get("Content-Type"), image) {
+		return nil, ErrRemoteNotImage
+	}
+	return image, nil
+}
+
+// NewHttpRemoteFetchService fetches images of up to maxBytes. allowedNetworks
+// are CIDRs exempted from the blocked private and loopback ranges.
+func NewHttpRemoteFetchService(maxBytes int64, allowedNetworks []string) (RemoteFetchServiceInterface, error) {
+	allowed := make([]netip.Prefix, 0, len(allowedNetworks))
+	for _, network := range allowedNetworks {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(network))
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing allowed network %s", err.Error())
+		}
+		allowed = append(allowed, prefix)
+	}
+
+	dialer := &net.Dialer{
+		Timeout: remoteDialTimeout,
+		// the address is checked once it is resolved so that a host name can
+		// not point at a blocked address, not even by changing in between
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return ErrForbiddenRemoteAddress
+			}
+			if !remoteAddrAllowed(addrPort.Addr(), allowed) {
+				return ErrForbiddenRemoteAddress
+			}
+			return nil
+		},
+	}
+	client := &http.Client{
+		Timeout: remoteFetchTimeout,
+		Transport: &http.Transport{
+			// a proxy would connect on our behalf, past the address checks
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   remoteDialTimeout,
+			ResponseHeaderTimeout: remoteFetchTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= remoteMaxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrRemoteFetchFailed, remoteMaxRedirects)
+			}
+			return checkRemoteUrl(req.URL.String())
+		},
+	}
+	return httpRemoteFetchService{client: client, maxBytes: maxBytes}, nil
+}
+
+func checkRemoteUrl(rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return ErrInvalidRemoteUrl
+	}
+	if parsed.User != nil {
+		return ErrInvalidRemoteUrl
+	}
+	return nil
+}
+
+func remoteAddrAllowed(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	for _, prefix := range blockedNetworks {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeImage sniffs the start of the body to catch the html error and login
+// pages servers answer with a 200. Sniffing knows neither svg nor every image
+// format, so text and unknown binaries are only let through when the server
+// declared an image; the image processor has the final word on the format.
+func looksLikeImage(declaredType string, body []byte) bool {
+	sniffed := http.DetectContentType(body)
+	switch {
+	case strings.HasPrefix(sniffed, "image/"):
+		return true
+	case strings.HasPrefix(sniffed, "text/html"):
+		return false
+	case strings.HasPrefix(sniffed, "text/xml"):
+		// svg documents with an xml declaration
+		return true
+	default:
+		return strings.HasPrefix(strings.ToLower(declaredType), "image/") ||
+			(sniffed == "application/octet-stream" && declaredType == "")
+	}
+}
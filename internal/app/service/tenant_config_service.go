@@ -0,0 +1,60 @@
+package appsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type TenantConfigServiceInterface interface {
+	GetTenantConfig(tenantOpts domain.TenantOpts) (domain.TenantConfig, error)
+}
+
+// fileTenantConfigService serves tenant configurations loaded from a json file
+// keyed by "<tenant-code>-<org-code>" or "<tenant-code>" for tenant wide defaults.
+// Tenant wide defaults are held with an empty org code.
+type fileTenantConfigService struct {
+	configs map[domain.TenantOpts]domain.TenantConfig
+}
+
+func (f fileTenantConfigService) GetTenantConfig(tenantOpts domain.TenantOpts) (domain.TenantConfig, error) {
+	if config, ok := f.configs[tenantOpts]; ok {
+		return config, nil
+	}
+	if config, ok := f.configs[domain.TenantOpts{TenantCode: tenantOpts.TenantCode}]; ok {
+		return config, nil
+	}
+	return domain.TenantConfig{}, nil
+}
+
+// NewFileTenantConfigService loads tenant configurations from path. An empty path
+// results in every tenant getting the zero configuration.
+func NewFileTenantConfigService(path string) (TenantConfigServiceInterface, error) {
+	configs := map[domain.TenantOpts]domain.TenantConfig{}
+	if path == "" {
+		return fileTenantConfigService{configs: configs}, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fileTenantConfigService{configs: configs}, nil
+		}
+		return nil, fmt.Errorf("error while reading tenant config %s", err.Error())
+	}
+	var keyedConfigs map[string]domain.TenantConfig
+	if err = json.Unmarshal(content, &keyedConfigs); err != nil {
+		return nil, fmt.Errorf("error while parsing tenant config %s", err.Error())
+	}
+	for key, config := range keyedConfigs {
+		tenantCode, orgCode, withOrg := strings.Cut(key, "-")
+		if !domain.ValidTenantCode(tenantCode) || (withOrg && !domain.ValidTenantCode(orgCode)) {
+			return nil, fmt.Errorf("tenant config %s: %w", key, domain.ErrInvalidTenant)
+		}
+		configs[domain.TenantOpts{TenantCode: tenantCode, OrgCode: orgCode}] = config
+	}
+	return fileTenantConfigService{configs: configs}, nil
+}
@@ -0,0 +1,85 @@
+package appsvc
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetTenantConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "tenants.json")
+	content := `{
+		"tenant1": {"watermark": {"imageName": "tenantLogo", "gravity": "southeast"}},
+		"tenant1-org1": {"watermark": {"imageName": "orgLogo", "enforceAboveWidth": 800}}
+	}`
+	if err := os.WriteFile(configFile, []byte(content), 0666); err != nil {
+		t.Fatalf("error writing tenant config: %v", err)
+	}
+
+	tcs, err := NewFileTenantConfigService(configFile)
+	if err != nil {
+		t.Fatalf("error loading tenant config: %v", err)
+	}
+
+	testCases := []struct {
+		tenantOpts        domain.TenantOpts
+		expectedWatermark *domain.WatermarkConfig
+	}{
+		{
+			tenantOpts:        domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"},
+			expectedWatermark: &domain.WatermarkConfig{ImageName: "orgLogo", EnforceAboveWidth: 800},
+		},
+		{
+			tenantOpts:        domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org2"},
+			expectedWatermark: &domain.WatermarkConfig{ImageName: "tenantLogo", Gravity: domain.Gravity_SOUTH_EAST},
+		},
+		{
+			tenantOpts:        domain.TenantOpts{TenantCode: "tenant2", OrgCode: "org1"},
+			expectedWatermark: nil,
+		},
+	}
+	for _, tc := range testCases {
+		config, err := tcs.GetTenantConfig(tc.tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedWatermark, config.Watermark)
+	}
+}
+
+func TestGetTenantConfigEager(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "tenants.json")
+	content := `{
+		"tenant1": {"eager": [{"width": 320, "format": "webp"}, {"width": 640, "ar": "16:9", "format": "avif"}]}
+	}`
+	if err := os.WriteFile(configFile, []byte(content), 0666); err != nil {
+		t.Fatalf("error writing tenant config: %v", err)
+	}
+
+	tcs, err := NewFileTenantConfigService(configFile)
+	if err != nil {
+		t.Fatalf("error loading tenant config: %v", err)
+	}
+
+	config, err := tcs.GetTenantConfig(domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.EagerTransformation{
+		{Width: 320, Format: domain.ImageType_WEBP},
+		{Width: 640, Ar: &domain.AR{Width: 16, Height: 9}, Format: domain.ImageType_AVIF},
+	}, config.Eager)
+}
+
+func TestGetTenantConfigRejectsAmbiguousKeys(t *testing.T) {
+	for _, content := range []string{`{"a-b-c": {}}`, `{"a/b": {}}`, `{"-org": {}}`} {
+		configFile := filepath.Join(t.TempDir(), "tenants.json")
+		if err := os.WriteFile(configFile, []byte(content), 0666); err != nil {
+			t.Fatalf("error writing tenant config: %v", err)
+		}
+
+		_, err := NewFileTenantConfigService(configFile)
+
+		assert.ErrorIs(t, err, domain.ErrInvalidTenant, content)
+	}
+}
@@ -0,0 +1,124 @@
+package appsvc
+
+import (
+	"encoding/hex"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrUploadTokenUsed = errors.New("upload token was already used")
+
+const uploadTokenIdLength = 16
+
+type UploadTokenServiceInterface interface {
+	// Issue assigns the token an id and returns it along with its signed form
+	Issue(token domain.UploadToken) (domain.UploadToken, string, error)
+	// Verify returns the token if its signature is valid and it has not expired
+	Verify(signed string) (domain.UploadToken, error)
+	// Redeem records the use of a single use token, failing when it was used before
+	Redeem(token domain.UploadToken) error
+	// Release undoes the redemption of a token whose upload failed, so that it
+	// can be used again
+	Release(token domain.UploadToken) error
+	// DeleteExpired forgets the redeemed tokens which expired since, they can
+	// not be used anymore anyway, and returns how many there were
+	DeleteExpired() (int, error)
+}
+
+// fileUploadTokenService keeps an empty file per redeemed token, named by its
+// id, until the token expires.
+type fileUploadTokenService struct {
+	secret []byte
+	dir    string
+	now    func() time.Time
+}
+
+func (f fileUploadTokenService) Issue(token domain.UploadToken) (domain.UploadToken, string, error) {
+	token.Id = randomId(uploadTokenIdLength)
+	signed, err := domain.SignUploadToken(f.secret, token)
+	if err != nil {
+		return domain.UploadToken{}, "", err
+	}
+	return token, signed, nil
+}
+
+func (f fileUploadTokenService) Verify(signed string) (domain.UploadToken, error) {
+	token, err := domain.ParseUploadToken(f.secret, signed, f.now())
+	if err != nil {
+		return domain.UploadToken{}, err
+	}
+	if !isUploadTokenId(token.Id) {
+		return domain.UploadToken{}, domain.ErrInvalidUploadToken
+	}
+	return token, nil
+}
+
+func (f fileUploadTokenService) Redeem(token domain.UploadToken) error {
+	if !isUploadTokenId(token.Id) {
+		return domain.ErrInvalidUploadToken
+	}
+	file, err := os.OpenFile(filepath.Join(f.dir, token.Id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrUploadTokenUsed
+		}
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	defer file.Close()
+	// the modification time records when the token can be forgotten
+	if err = os.Chtimes(file.Name(), token.ExpiresAt, token.ExpiresAt); err != nil {
+		return fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return nil
+}
+
+func (f fileUploadTokenService) Release(token domain.UploadToken) error {
+	if !isUploadTokenId(token.Id) {
+		return domain.ErrInvalidUploadToken
+	}
+	err := os.Remove(filepath.Join(f.dir, token.Id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error while removing file %s", err.Error())
+	}
+	return nil
+}
+
+func (f fileUploadTokenService) DeleteExpired() (int, error) {
+	dirEntry, err := os.ReadDir(f.dir)
+	if err != nil {
+		return 0, fmt.Errorf("internal error: %v", err)
+	}
+	deleted := 0
+	for _, e := range dirEntry {
+		info, err := e.Info()
+		if err != nil || !f.now().After(info.ModTime()) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(f.dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, fmt.Errorf("error while removing file %s", err.Error())
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// NewFileUploadTokenService signs tokens with secret, which every instance
+// serving uploads must share.
+func NewFileUploadTokenService(secret []byte, dir string) (UploadTokenServiceInterface, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("upload token secret is required")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("error while making directory %s", err.Error())
+	}
+	return fileUploadTokenService{secret: secret, dir: dir, now: time.Now}, nil
+}
+
+func isUploadTokenId(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == uploadTokenIdLength
+}
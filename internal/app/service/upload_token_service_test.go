@@ -0,0 +1,76 @@
+package appsvc
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUploadTokenService(t *testing.T) {
+	newService := func(t *testing.T) fileUploadTokenService {
+		svc, err := NewFileUploadTokenService([]byte("secret"), t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating upload token service: %v", err)
+		}
+		return svc.(fileUploadTokenService)
+	}
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("an issued token verifies", func(t *testing.T) {
+		svc := newService(t)
+
+		token, signed, err := svc.Issue(domain.UploadToken{TenantOpts: tenantOpts, MaxBytes: 1024,
+			ExpiresAt: time.Now().Add(time.Minute).Truncate(time.Second)})
+		assert.NoError(t, err)
+		assert.True(t, isUploadTokenId(token.Id))
+
+		verified, err := svc.Verify(signed)
+		assert.NoError(t, err)
+		assert.True(t, token.ExpiresAt.Equal(verified.ExpiresAt))
+		assert.Equal(t, token.Id, verified.Id)
+		assert.Equal(t, tenantOpts, verified.TenantOpts)
+	})
+
+	t.Run("a token is redeemed once", func(t *testing.T) {
+		svc := newService(t)
+		token, _, _ := svc.Issue(domain.UploadToken{TenantOpts: tenantOpts, ExpiresAt: time.Now().Add(time.Minute)})
+
+		assert.NoError(t, svc.Redeem(token))
+		assert.ErrorIs(t, svc.Redeem(token), ErrUploadTokenUsed)
+	})
+
+	t.Run("a released token is redeemed again", func(t *testing.T) {
+		svc := newService(t)
+		token, _, _ := svc.Issue(domain.UploadToken{TenantOpts: tenantOpts, ExpiresAt: time.Now().Add(time.Minute)})
+		_ = svc.Redeem(token)
+
+		assert.NoError(t, svc.Release(token))
+		assert.NoError(t, svc.Redeem(token))
+	})
+
+	t.Run("redeemed tokens are forgotten once expired", func(t *testing.T) {
+		svc := newService(t)
+		now := time.Now()
+		expiring, _, _ := svc.Issue(domain.UploadToken{TenantOpts: tenantOpts, ExpiresAt: now.Add(time.Minute)})
+		lasting, _, _ := svc.Issue(domain.UploadToken{TenantOpts: tenantOpts, ExpiresAt: now.Add(time.Hour)})
+		_ = svc.Redeem(expiring)
+		_ = svc.Redeem(lasting)
+		svc.now = func() time.Time { return now.Add(2 * time.Minute) }
+
+		deleted, err := svc.DeleteExpired()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		assert.ErrorIs(t, svc.Redeem(lasting), ErrUploadTokenUsed)
+	})
+
+	t.Run("a token with a foreign id is refused", func(t *testing.T) {
+		svc := newService(t)
+		signed, _ := domain.SignUploadToken([]byte("secret"), domain.UploadToken{Id: "../jobs", ExpiresAt: time.Now().Add(time.Minute)})
+
+		_, err := svc.Verify(signed)
+
+		assert.ErrorIs(t, err, domain.ErrInvalidUploadToken)
+	})
+}
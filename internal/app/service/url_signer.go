@@ -0,0 +1,36 @@
+package appsvc
+
+import (
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"time"
+)
+
+type UrlSignerInterface interface {
+	// Sign returns the signature of urls serving the image until expiresAt
+	Sign(name string, tenantOpts domain.TenantOpts, expiresAt time.Time) string
+	// Verify reports whether the signature was made by Sign and has not expired
+	Verify(name string, tenantOpts domain.TenantOpts, expiresAt time.Time, signature string) bool
+}
+
+type hmacUrlSigner struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func (h hmacUrlSigner) Sign(name string, tenantOpts domain.TenantOpts, expiresAt time.Time) string {
+	return domain.SignImageUrl(h.secret, name, tenantOpts, expiresAt)
+}
+
+func (h hmacUrlSigner) Verify(name string, tenantOpts domain.TenantOpts, expiresAt time.Time, signature string) bool {
+	return domain.VerifyImageUrl(h.secret, name, tenantOpts, expiresAt, signature, h.now())
+}
+
+// NewHmacUrlSigner signs urls with secret, which every instance serving images
+// must share.
+func NewHmacUrlSigner(secret []byte) (UrlSignerInterface, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("url signing secret is required")
+	}
+	return hmacUrlSigner{secret: secret, now: time.Now}, nil
+}
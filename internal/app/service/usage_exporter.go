@@ -0,0 +1,51 @@
+package appsvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type UsageExporterInterface interface {
+	// Export writes the usage snapshots taken at takenAt and returns the path
+	// of the export
+	Export(takenAt time.Time, snapshots []domain.UsageSnapshot) (string, error)
+}
+
+// fileUsageExporter writes each export to a json lines file of its own, named
+// after the time it was taken, for billing to pick up.
+type fileUsageExporter struct {
+	dir string
+}
+
+func (f fileUsageExporter) Export(takenAt time.Time, snapshots []domain.UsageSnapshot) (string, error) {
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for _, snapshot := range snapshots {
+		if err := encoder.Encode(snapshot); err != nil {
+			return "", err
+		}
+	}
+
+	path := filepath.Join(f.dir, "usage-"+takenAt.UTC().Format("20060102T150405Z")+".jsonl")
+	// billing never picks up a partial export
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content.Bytes(), 0666); err != nil {
+		return "", fmt.Errorf("error while writing file %s", err.Error())
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("error while writing file %s", err.Error())
+	}
+	return path, nil
+}
+
+func NewFileUsageExporter(dir string) (UsageExporterInterface, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("error while making directory %s", err.Error())
+	}
+	return fileUsageExporter{dir: dir}, nil
+}
@@ -0,0 +1,55 @@
+package appsvc
+
+import (
+	"bufio"
+	"encoding/json"
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageExport(t *testing.T) {
+	t.Run("every snapshot is written as a line of the export", func(t *testing.T) {
+		dir := t.TempDir()
+		exporter, err := NewFileUsageExporter(dir)
+		if err != nil {
+			t.Fatalf("error creating exporter: %v", err)
+		}
+		takenAt := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+		snapshots := []domain.UsageSnapshot{
+			{
+				TenantOpts: domain.TenantOpts{TenantCode: "tenant1", OrgCode: "org1"},
+				TakenAt:    takenAt,
+				Usage:      domain.Usage{Originals: domain.UsageCount{Objects: 1, Bytes: 10}},
+				Quota:      &domain.QuotaConfig{MaxBytes: 100},
+			},
+			{
+				TenantOpts: domain.TenantOpts{TenantCode: "tenant2", OrgCode: "org2"},
+				TakenAt:    takenAt,
+			},
+		}
+
+		path, err := exporter.Export(takenAt, snapshots)
+
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "usage-20261019T123000Z.jsonl"), path)
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("error opening export: %v", err)
+		}
+		defer file.Close()
+		var exported []domain.UsageSnapshot
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var snapshot domain.UsageSnapshot
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &snapshot))
+			exported = append(exported, snapshot)
+		}
+		assert.Equal(t, snapshots, exported)
+		_, err = os.Stat(path + ".tmp")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
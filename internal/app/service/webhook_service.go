@@ -0,0 +1,179 @@
+package appsvc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrWebhookRejected = errors.New("webhook rejected the delivery")
+
+const (
+	webhookTimeout = 10 * time.Second
+	// webhookResponseLimit is how much of a response is read before the
+	// connection is reused, receivers are expected to answer with an empty body
+	webhookResponseLimit = 4096
+	eventIdLength        = 16
+
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+type WebhookServiceInterface interface {
+	// Deliver posts the event to the webhook and records the attempt in the
+	// delivery log. A receiver answering anything but 2xx fails the delivery.
+	Deliver(ctx context.Context, webhook domain.WebhookConfig, event domain.Event, attempt int) error
+	// Deliveries returns the latest deliveries of the tenant, newest first
+	Deliveries(tenantOpts domain.TenantOpts, limit int) ([]domain.WebhookDelivery, error)
+}
+
+// httpWebhookService appends the delivery log of each tenant to a json lines
+// file in its log directory.
+type httpWebhookService struct {
+	client *http.Client
+	logDir string
+	mu     *sync.Mutex
+	now    func() time.Time
+}
+
+func (h httpWebhookService) Deliver(ctx context.Context, webhook domain.WebhookConfig, event domain.Event, attempt int) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	start := h.now()
+	delivery := domain.WebhookDelivery{
+		EventId:     event.Id,
+		EventType:   event.Type,
+		Url:         webhook.Url,
+		Attempt:     attempt,
+		DeliveredAt: start,
+	}
+
+	statusCode, deliveryErr := h.post(ctx, webhook, event, payload, start)
+	delivery.StatusCode = statusCode
+	delivery.DurationMs = h.now().Sub(start).Milliseconds()
+	delivery.Success = deliveryErr == nil
+	if deliveryErr != nil {
+		delivery.Error = deliveryErr.Error()
+	}
+	if err = h.record(event.TenantOpts, delivery); err != nil {
+		return errors.Join(deliveryErr, err)
+	}
+	return deliveryErr
+}
+
+func (h httpWebhookService) post(ctx context.Context, webhook domain.WebhookConfig, event domain.Event, payload []byte, timestamp time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type.String())
+	req.Header.Set(WebhookIdHeader, event.Id)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+domain.SignWebhookPayload(webhook.Secret, timestamp, payload))
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, webhookResponseLimit))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("%w: status %d", ErrWebhookRejected, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+func (h httpWebhookService) record(tenantOpts domain.TenantOpts, delivery domain.WebhookDelivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.OpenFile(deliveryLogFile(h.logDir, tenantOpts), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("error while opening delivery log %s", err.Error())
+	}
+	defer file.Close()
+	if _, err = file.Write(append(content, '\n')); err != nil {
+		return fmt.Errorf("error while writing delivery log %s", err.Error())
+	}
+	return nil
+}
+
+func (h httpWebhookService) Deliveries(tenantOpts domain.TenantOpts, limit int) ([]domain.WebhookDelivery, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.Open(deliveryLogFile(h.logDir, tenantOpts))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []domain.WebhookDelivery{}, nil
+		}
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	defer file.Close()
+
+	// keep the last limit entries of the log
+	var deliveries []domain.WebhookDelivery
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var delivery domain.WebhookDelivery
+		if err = json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			// a line torn by a crash only loses its own entry
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if len(deliveries) > limit {
+			deliveries = deliveries[1:]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+
+	latest := make([]domain.WebhookDelivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		latest = append(latest, deliveries[i])
+	}
+	return latest, nil
+}
+
+func NewHttpWebhookService(logDir string) (WebhookServiceInterface, error) {
+	if err := os.MkdirAll(logDir, 0750); err != nil {
+		return nil, fmt.Errorf("error while making directory %s", err.Error())
+	}
+	return httpWebhookService{
+		client: &http.Client{Timeout: webhookTimeout},
+		logDir: logDir,
+		mu:     &sync.Mutex{},
+		now:    time.Now,
+	}, nil
+}
+
+func deliveryLogFile(logDir string, tenantOpts domain.TenantOpts) string {
+	return filepath.Join(logDir, fmt.Sprintf("%s-%s.jsonl", tenantOpts.TenantCode, tenantOpts.OrgCode))
+}
+
+func GenerateEventId() string {
+	return randomId(eventIdLength)
+}
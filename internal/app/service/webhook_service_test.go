@@ -0,0 +1,97 @@
+package appsvc
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhook(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	event := domain.Event{
+		Id:         "event1",
+		Type:       domain.EventType_IMAGE_UPLOADED,
+		TenantOpts: tenantOpts,
+		ImageName:  "testimagename1",
+		CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("a delivery is signed and logged", func(t *testing.T) {
+		var received domain.Event
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload, _ := io.ReadAll(r.Body)
+			timestamp, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+			signature := "sha256=" + domain.SignWebhookPayload("secret", time.Unix(timestamp, 0), payload)
+			if r.Header.Get(WebhookSignatureHeader) != signature || r.Header.Get(WebhookEventHeader) != "image.uploaded" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.Unmarshal(payload, &received)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		svc, err := NewHttpWebhookService(t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating webhook service: %v", err)
+		}
+
+		err = svc.Deliver(context.Background(), domain.WebhookConfig{Url: receiver.URL, Secret: "secret"}, event, 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, event, received)
+		deliveries, err := svc.Deliveries(tenantOpts, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, deliveries, 1) {
+			assert.True(t, deliveries[0].Success)
+			assert.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+			assert.Equal(t, "event1", deliveries[0].EventId)
+		}
+	})
+
+	t.Run("a rejected delivery fails and is logged", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer receiver.Close()
+
+		svc, err := NewHttpWebhookService(t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating webhook service: %v", err)
+		}
+
+		for attempt := 1; attempt <= 3; attempt++ {
+			err = svc.Deliver(context.Background(), domain.WebhookConfig{Url: receiver.URL}, event, attempt)
+			assert.ErrorIs(t, err, ErrWebhookRejected)
+		}
+
+		deliveries, err := svc.Deliveries(tenantOpts, 2)
+		assert.NoError(t, err)
+		if assert.Len(t, deliveries, 2) {
+			// newest first
+			assert.Equal(t, 3, deliveries[0].Attempt)
+			assert.Equal(t, 2, deliveries[1].Attempt)
+			assert.False(t, deliveries[0].Success)
+			assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+		}
+	})
+
+	t.Run("a tenant without deliveries", func(t *testing.T) {
+		svc, err := NewHttpWebhookService(t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating webhook service: %v", err)
+		}
+
+		deliveries, err := svc.Deliveries(tenantOpts, 10)
+
+		assert.NoError(t, err)
+		assert.Empty(t, deliveries)
+	})
+}
@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scope is what a principal is allowed to do with the images of its tenant.
+type Scope int
+
+const (
+	Scope_READ Scope = iota
+	Scope_WRITE
+)
+
+func (s Scope) String() string {
+	switch s {
+	case Scope_READ:
+		return "read"
+	case Scope_WRITE:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+func ScopeFromString(scopeStr string) (Scope, error) {
+	switch strings.ToLower(scopeStr) {
+	case "read":
+		return Scope_READ, nil
+	case "write":
+		return Scope_WRITE, nil
+	default:
+		return -1, fmt.Errorf("unsupported scope: %v", scopeStr)
+	}
+}
+
+func (s Scope) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Scope) UnmarshalText(text []byte) error {
+	scope, err := ScopeFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*s = scope
+	return nil
+}
+
+// Principal is the authenticated caller, it only ever acts on its own tenant.
+type Principal struct {
+	// Subject names the api key or the subject of the token
+	Subject    string
+	TenantOpts TenantOpts
+	Scopes     []Scope
+}
+
+func (p Principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// ApiKey is an api key as kept at rest, only the hash of the key is stored.
+type ApiKey struct {
+	Id string `json:"id"`
+	// Hash is the hex encoded SHA-256 of the key, see HashApiKey
+	Hash       string     `json:"hash"`
+	TenantOpts TenantOpts `json:"tenant"`
+	Scopes     []Scope    `json:"scopes"`
+}
+
+// HashApiKey returns the hex encoded SHA-256 of the key. Keys are long random
+// strings, so a fast hash is enough to keep them from leaking along with the
+// key file.
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScopeFromString(t *testing.T) {
+	for _, scope := range []Scope{Scope_READ, Scope_WRITE} {
+		res, err := ScopeFromString(scope.String())
+		assert.NoError(t, err)
+		assert.Equal(t, scope, res)
+	}
+	_, err := ScopeFromString("admin")
+	assert.Error(t, err)
+}
+
+func TestHashApiKey(t *testing.T) {
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", HashApiKey("secret"))
+}
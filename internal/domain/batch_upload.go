@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	ErrInvalidArchive      = errors.New("invalid zip archive")
+	ErrArchiveTooLarge     = errors.New("zip archive is too large")
+	ErrTooManyArchiveFiles = errors.New("zip archive has too many files")
+)
+
+// Codes of the errors a file of a batch upload can fail with.
+const (
+	BatchErrorUnsupportedFormat = "unsupported_format"
+	BatchErrorInvalidFile       = "invalid_file"
+	BatchErrorQuotaExceeded     = "quota_exceeded"
+	BatchErrorInternal          = "internal_error"
+)
+
+// BatchFile is one file of a batch upload. Its content is only read by Open
+// once the file is uploaded, so that a batch is not held in memory at once.
+type BatchFile struct {
+	Name string
+	// Size is the size of the content as declared by the request or archive
+	Size int64
+	Open func() (io.ReadCloser, error)
+	// Err is set for files which could not be read, they are reported without
+	// being uploaded
+	Err error
+}
+
+// BatchUpload is the outcome of uploading one file of a batch.
+type BatchUpload struct {
+	File      string      `json:"file"`
+	ImageName string      `json:"imgName,omitempty"`
+	Error     *BatchError `json:"error,omitempty"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ExtractZipArchive lists the files of a zip archive, skipping directories and
+// the hidden files archivers add. maxFiles and maxBytes bound the number of
+// files and their declared uncompressed size. The archive can not be trusted to
+// declare sizes truthfully, so the files fail to read past their declared size.
+// The archive must stay open until the files are read.
+func ExtractZipArchive(archive io.ReaderAt, size int64, maxFiles int, maxBytes int64) ([]BatchFile, error) {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+	var files []BatchFile
+	remaining := maxBytes
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() || hiddenArchiveEntry(entry.Name) {
+			continue
+		}
+		if len(files) == maxFiles {
+			return nil, ErrTooManyArchiveFiles
+		}
+		if entry.UncompressedSize64 > uint64(remaining) {
+			return nil, ErrArchiveTooLarge
+		}
+		remaining -= int64(entry.UncompressedSize64)
+		files = append(files, BatchFile{
+			Name: entry.Name,
+			Size: int64(entry.UncompressedSize64),
+			Open: func() (io.ReadCloser, error) { return openArchiveEntry(entry) },
+		})
+	}
+	return files, nil
+}
+
+func openArchiveEntry(entry *zip.File) (io.ReadCloser, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+	return &archiveEntryReader{ReadCloser: rc, remaining: int64(entry.UncompressedSize64)}, nil
+}
+
+// archiveEntryReader fails once more than the declared size was read.
+type archiveEntryReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (a *archiveEntryReader) Read(p []byte) (int, error) {
+	n, err := a.ReadCloser.Read(p)
+	a.remaining -= int64(n)
+	if a.remaining < 0 {
+		return n, ErrArchiveTooLarge
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, ErrInvalidArchive
+	}
+	return n, err
+}
+
+// hiddenArchiveEntry catches dot files and the resource forks macOS adds to the
+// archives it creates.
+func hiddenArchiveEntry(name string) bool {
+	for _, segment := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func zipArchive(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("error creating zip entry: %v", err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("error closing zip: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestExtractZipArchive(t *testing.T) {
+	t.Run("files are extracted and hidden files skipped", func(t *testing.T) {
+		archive := zipArchive(t, map[string]string{
+			"photos/":                 "",
+			"photos/a.jpg":            "image a",
+			"b.png":                   "image b",
+			".DS_Store":               "finder",
+			"__MACOSX/photos/._a.jpg": "fork",
+		})
+
+		files, err := ExtractZipArchive(archive, archive.Size(), 10, 1024)
+
+		assert.NoError(t, err)
+		contents := map[string]string{}
+		for _, file := range files {
+			rc, err := file.Open()
+			assert.NoError(t, err)
+			content, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			_ = rc.Close()
+			assert.Equal(t, int64(len(content)), file.Size)
+			contents[file.Name] = string(content)
+		}
+		assert.Equal(t, map[string]string{"photos/a.jpg": "image a", "b.png": "image b"}, contents)
+	})
+
+	t.Run("the uncompressed size is bounded", func(t *testing.T) {
+		archive := zipArchive(t, map[string]string{"a.jpg": string(make([]byte, 2048))})
+
+		_, err := ExtractZipArchive(archive, archive.Size(), 10, 1024)
+
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+	})
+
+	t.Run("a file fails to read past its declared size", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := zip.NewWriter(&buf)
+		w, _ := writer.CreateRaw(&zip.FileHeader{Name: "a.jpg", Method: zip.Store,
+			CompressedSize64: 2048, UncompressedSize64: 16})
+		_, _ = w.Write(make([]byte, 2048))
+		_ = writer.Close()
+		archive := bytes.NewReader(buf.Bytes())
+
+		files, err := ExtractZipArchive(archive, archive.Size(), 10, 1024)
+		assert.NoError(t, err)
+		rc, err := files[0].Open()
+		assert.NoError(t, err)
+		defer rc.Close()
+		content, err := io.ReadAll(rc)
+
+		assert.Error(t, err)
+		assert.LessOrEqual(t, len(content), 16)
+	})
+
+	t.Run("the number of files is bounded", func(t *testing.T) {
+		archive := zipArchive(t, map[string]string{"a.jpg": "a", "b.jpg": "b", "c.jpg": "c"})
+
+		_, err := ExtractZipArchive(archive, archive.Size(), 2, 1024)
+
+		assert.ErrorIs(t, err, ErrTooManyArchiveFiles)
+	})
+
+	t.Run("a file which is not a zip archive is refused", func(t *testing.T) {
+		archive := bytes.NewReader([]byte("not a zip"))
+
+		_, err := ExtractZipArchive(archive, archive.Size(), 10, 1024)
+
+		assert.ErrorIs(t, err, ErrInvalidArchive)
+	})
+}
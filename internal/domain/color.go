@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Color struct {
+	R, G, B, A uint8
+}
+
+// ParseHexColor parses colors in the rgb, rrggbb and rrggbbaa hex notations, with
+// or without a leading '#'. Colors without an alpha component are fully opaque.
+func ParseHexColor(str string) (Color, error) {
+	hex := strings.TrimPrefix(str, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return Color{}, fmt.Errorf("not a valid color: %v", str)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("not a valid color: %v", str)
+	}
+	return Color{
+		R: uint8(value >> 24),
+		G: uint8(value >> 16),
+		B: uint8(value >> 8),
+		A: uint8(value),
+	}, nil
+}
+
+func (c Color) Hex() string {
+	if c.A == 0xff {
+		return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte("#" + c.Hex()), nil
+}
+
+func (c *Color) UnmarshalText(text []byte) error {
+	color, err := ParseHexColor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = color
+	return nil
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	testCases := []struct {
+		str      string
+		expected Color
+		isValid  bool
+	}{
+		{str: "ff0000", expected: Color{R: 255, A: 255}, isValid: true},
+		{str: "#00ff0080", expected: Color{G: 255, A: 128}, isValid: true},
+		{str: "fff", expected: Color{R: 255, G: 255, B: 255, A: 255}, isValid: true},
+		{str: "#12", isValid: false},
+		{str: "gggggg", isValid: false},
+	}
+	for _, tc := range testCases {
+		res, err := ParseHexColor(tc.str)
+		if !tc.isValid {
+			assert.Error(t, err, tc.str)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+	}
+}
+
+func TestColorHex(t *testing.T) {
+	assert.Equal(t, "ff0000", Color{R: 255, A: 255}.Hex())
+	assert.Equal(t, "00ff0080", Color{G: 255, A: 128}.Hex())
+}
+
+func TestColorText(t *testing.T) {
+	text, err := Color{R: 255, A: 255}.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "#ff0000", string(text))
+
+	var color Color
+	assert.NoError(t, color.UnmarshalText([]byte("#00ff0080")))
+	assert.Equal(t, Color{G: 255, A: 128}, color)
+	assert.Error(t, color.UnmarshalText([]byte("red")))
+}
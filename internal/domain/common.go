@@ -3,6 +3,7 @@ package domain
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -16,6 +17,19 @@ func (ar AR) String() string {
 	return fmt.Sprintf("%d:%d", ar.Width, ar.Height)
 }
 
+func (ar AR) MarshalText() ([]byte, error) {
+	return []byte(ar.String()), nil
+}
+
+func (ar *AR) UnmarshalText(text []byte) error {
+	aspectRatio, err := ParseAspectRatio(string(text))
+	if err != nil {
+		return err
+	}
+	*ar = aspectRatio
+	return nil
+}
+
 func (ar AR) Float64() float64 {
 	return float64(ar.Width) / float64(ar.Height)
 }
@@ -60,6 +74,13 @@ const (
 	ImageType_WEBP
 	ImageType_JPEG
 	ImageType_PNG
+	ImageType_GIF
+	ImageType_HEIC
+	ImageType_TIFF
+	ImageType_BMP
+	ImageType_JXL
+	ImageType_SVG
+	ImageType_PDF
 )
 
 func (imgT ImageType) String() string {
@@ -72,6 +93,20 @@ func (imgT ImageType) String() string {
 		return "jpeg"
 	case ImageType_PNG:
 		return "png"
+	case ImageType_GIF:
+		return "gif"
+	case ImageType_HEIC:
+		return "heic"
+	case ImageType_TIFF:
+		return "tiff"
+	case ImageType_BMP:
+		return "bmp"
+	case ImageType_JXL:
+		return "jxl"
+	case ImageType_SVG:
+		return "svg"
+	case ImageType_PDF:
+		return "pdf"
 	case ImageType_AUTO:
 		return "auto"
 	default:
@@ -101,6 +136,22 @@ func ImageTypeFromString(imgTypeStr string) (ImageType, error) {
 		return ImageType_PNG, nil
 	case ".png":
 		return ImageType_PNG, nil
+	case "gif":
+		return ImageType_GIF, nil
+	case ".gif":
+		return ImageType_GIF, nil
+	case "heic", ".heic", "heif", ".heif":
+		return ImageType_HEIC, nil
+	case "tiff", ".tiff", "tif", ".tif":
+		return ImageType_TIFF, nil
+	case "bmp", ".bmp":
+		return ImageType_BMP, nil
+	case "jxl", ".jxl":
+		return ImageType_JXL, nil
+	case "svg", ".svg":
+		return ImageType_SVG, nil
+	case "pdf", ".pdf":
+		return ImageType_PDF, nil
 	case "auto":
 		return ImageType_AUTO, nil
 	default:
@@ -108,9 +159,75 @@ func ImageTypeFromString(imgTypeStr string) (ImageType, error) {
 	}
 }
 
+func (imgT ImageType) MarshalText() ([]byte, error) {
+	return []byte(imgT.String()), nil
+}
+
+func (imgT *ImageType) UnmarshalText(text []byte) error {
+	imageType, err := ImageTypeFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*imgT = imageType
+	return nil
+}
+
+// IsOutputFormat reports whether images can be served in the format. The other
+// formats are only accepted as parent images.
+func (imgT ImageType) IsOutputFormat() bool {
+	switch imgT {
+	case ImageType_AVIF, ImageType_WEBP, ImageType_JPEG, ImageType_PNG, ImageType_GIF, ImageType_JXL:
+		return true
+	default:
+		return false
+	}
+}
+
+// OutputFallback returns the format children of a parent image in this format
+// are served in when no specific format is requested.
+func (imgT ImageType) OutputFallback() ImageType {
+	switch {
+	case imgT.IsOutputFormat():
+		return imgT
+	case imgT == ImageType_HEIC:
+		return ImageType_JPEG
+	default:
+		return ImageType_PNG
+	}
+}
+
+// IsVector reports whether the format is rendered at a chosen density.
+func (imgT ImageType) IsVector() bool {
+	return imgT == ImageType_SVG || imgT == ImageType_PDF
+}
+
+// IsPaged reports whether the format may hold several independent pages.
+func (imgT ImageType) IsPaged() bool {
+	return imgT == ImageType_TIFF || imgT == ImageType_PDF
+}
+
+var ErrInvalidTenant = errors.New("invalid tenant-code or org-code")
+
+// tenantCodePattern keeps "-", which joins the codes in storage directories and
+// tenant config keys, and path separators out of tenant and org codes.
+var tenantCodePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
 type TenantOpts struct {
-	TenantCode string
-	OrgCode    string
+	TenantCode string `json:"tenantCode"`
+	OrgCode    string `json:"orgCode"`
+}
+
+// Validate reports whether both codes are set and can be joined unambiguously.
+func (t TenantOpts) Validate() error {
+	if !ValidTenantCode(t.TenantCode) || !ValidTenantCode(t.OrgCode) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// ValidTenantCode reports whether code is usable as a tenant or org code.
+func ValidTenantCode(code string) bool {
+	return tenantCodePattern.MatchString(code)
 }
 
 func GreatCommonFactor(a int, b int) int {
@@ -124,4 +241,7 @@ type ImageSpec struct {
 	Width  int
 	Height int
 	Format ImageType
+	// Variant distinguishes children of the same dimensions and format that were
+	// built with extra operations (e.g. a watermark); empty for plain resizes
+	Variant string
 }
@@ -41,3 +41,75 @@ func TestGreatCommonFactor(t *testing.T) {
 		assert.Equal(t, tc.expected, res)
 	}
 }
+
+func TestImageTypeFromString(t *testing.T) {
+	testCases := []struct {
+		str      string
+		expected ImageType
+		isValid  bool
+	}{
+		{str: "jpg", expected: ImageType_JPEG, isValid: true},
+		{str: ".webp", expected: ImageType_WEBP, isValid: true},
+		{str: "gif", expected: ImageType_GIF, isValid: true},
+		{str: ".gif", expected: ImageType_GIF, isValid: true},
+		{str: "heif", expected: ImageType_HEIC, isValid: true},
+		{str: ".tif", expected: ImageType_TIFF, isValid: true},
+		{str: ".jxl", expected: ImageType_JXL, isValid: true},
+		{str: "svg", expected: ImageType_SVG, isValid: true},
+		{str: ".pdf", expected: ImageType_PDF, isValid: true},
+		{str: "bmpx", isValid: false},
+	}
+	for _, tc := range testCases {
+		res, err := ImageTypeFromString(tc.str)
+		if !tc.isValid {
+			assert.Error(t, err, tc.str)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+		assert.Equal(t, tc.expected.String(), res.String())
+	}
+}
+
+func TestImageTypeOutputFallback(t *testing.T) {
+	testCases := []struct {
+		imgType  ImageType
+		expected ImageType
+	}{
+		{imgType: ImageType_WEBP, expected: ImageType_WEBP},
+		{imgType: ImageType_JXL, expected: ImageType_JXL},
+		{imgType: ImageType_HEIC, expected: ImageType_JPEG},
+		{imgType: ImageType_TIFF, expected: ImageType_PNG},
+		{imgType: ImageType_BMP, expected: ImageType_PNG},
+		{imgType: ImageType_SVG, expected: ImageType_PNG},
+		{imgType: ImageType_PDF, expected: ImageType_PNG},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.imgType.OutputFallback(), tc.imgType)
+	}
+}
+
+func TestImageTypeText(t *testing.T) {
+	text, err := ImageType_JPEG.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", string(text))
+
+	var imgType ImageType
+	assert.NoError(t, imgType.UnmarshalText([]byte("webp")))
+	assert.Equal(t, ImageType_WEBP, imgType)
+	assert.Error(t, imgType.UnmarshalText([]byte("psd")))
+}
+
+func TestTenantOptsValidate(t *testing.T) {
+	assert.NoError(t, TenantOpts{TenantCode: "tenant_1", OrgCode: "Org2"}.Validate())
+	for _, tenantOpts := range []TenantOpts{
+		{TenantCode: "", OrgCode: "org"},
+		{TenantCode: "tenant", OrgCode: ""},
+		{TenantCode: "a-b", OrgCode: "c"},
+		{TenantCode: "a", OrgCode: "b-c"},
+		{TenantCode: "..", OrgCode: "org"},
+		{TenantCode: "tenant", OrgCode: "a/b"},
+	} {
+		assert.ErrorIs(t, tenantOpts.Validate(), ErrInvalidTenant, tenantOpts)
+	}
+}
@@ -0,0 +1,11 @@
+package domain
+
+// Compression reports how an image was fitted into a byte budget.
+type Compression struct {
+	// Quality is the encoder quality in range 1..100
+	Quality int `json:"quality"`
+	// Width and Height are the dimensions the image was shrunk to, which only
+	// differ from the requested ones when the lowest quality didn't fit
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
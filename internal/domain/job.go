@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type JobState int
+
+const (
+	JobState_PENDING JobState = iota
+	JobState_RUNNING
+	JobState_SUCCEEDED
+	JobState_FAILED
+	// JobState_DEAD is the state of a job which failed all of its attempts and
+	// was moved to the dead-letter store
+	JobState_DEAD
+)
+
+func (js JobState) String() string {
+	switch js {
+	case JobState_PENDING:
+		return "pending"
+	case JobState_RUNNING:
+		return "running"
+	case JobState_SUCCEEDED:
+		return "succeeded"
+	case JobState_FAILED:
+		return "failed"
+	case JobState_DEAD:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+func JobStateFromString(jobStateStr string) (JobState, error) {
+	switch strings.ToLower(jobStateStr) {
+	case "pending":
+		return JobState_PENDING, nil
+	case "running":
+		return JobState_RUNNING, nil
+	case "succeeded":
+		return JobState_SUCCEEDED, nil
+	case "failed":
+		return JobState_FAILED, nil
+	case "dead":
+		return JobState_DEAD, nil
+	default:
+		return -1, fmt.Errorf("unsupported job state: %v", jobStateStr)
+	}
+}
+
+func (js JobState) MarshalText() ([]byte, error) {
+	return []byte(js.String()), nil
+}
+
+func (js *JobState) UnmarshalText(text []byte) error {
+	jobState, err := JobStateFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*js = jobState
+	return nil
+}
+
+// EagerStatus tracks the eager transformations of an uploaded image.
+type EagerStatus struct {
+	State     JobState `json:"state"`
+	Total     int      `json:"total"`
+	Completed int      `json:"completed"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+type JobType int
+
+const (
+	// JobType_EAGER renders the eager transformations of an uploaded image
+	JobType_EAGER JobType = iota
+	// JobType_REPROCESS drops the derived data of images and builds it again
+	JobType_REPROCESS
+	// JobType_PURGE deletes images along with everything derived from them
+	JobType_PURGE
+	// JobType_WEBHOOK delivers an event to a webhook of the tenant
+	JobType_WEBHOOK
+	// JobType_PRERENDER renders the variants of a srcset ahead of their requests
+	JobType_PRERENDER
+)
+
+func (jt JobType) String() string {
+	switch jt {
+	case JobType_EAGER:
+		return "eager"
+	case JobType_REPROCESS:
+		return "reprocess"
+	case JobType_PURGE:
+		return "purge"
+	case JobType_WEBHOOK:
+		return "webhook"
+	case JobType_PRERENDER:
+		return "prerender"
+	default:
+		return "unknown"
+	}
+}
+
+func JobTypeFromString(jobTypeStr string) (JobType, error) {
+	switch strings.ToLower(jobTypeStr) {
+	case "eager":
+		return JobType_EAGER, nil
+	case "reprocess":
+		return JobType_REPROCESS, nil
+	case "purge":
+		return JobType_PURGE, nil
+	case "webhook":
+		return JobType_WEBHOOK, nil
+	case "prerender":
+		return JobType_PRERENDER, nil
+	default:
+		return -1, fmt.Errorf("unsupported job type: %v", jobTypeStr)
+	}
+}
+
+func (jt JobType) MarshalText() ([]byte, error) {
+	return []byte(jt.String()), nil
+}
+
+func (jt *JobType) UnmarshalText(text []byte) error {
+	jobType, err := JobTypeFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*jt = jobType
+	return nil
+}
+
+// Job is a unit of background work on the images of a tenant.
+type Job struct {
+	Id         string     `json:"id"`
+	Type       JobType    `json:"type"`
+	TenantOpts TenantOpts `json:"tenantOpts"`
+	// Images are the names of the images the job works on; a reprocess job
+	// without images works on all images of the tenant
+	Images []string `json:"images,omitempty"`
+	// Eager are the transformations rendered by an eager or prerender job
+	Eager []EagerTransformation `json:"eager,omitempty"`
+	// Event is delivered by a webhook job to the tenant's webhook at WebhookUrl
+	Event      *Event `json:"event,omitempty"`
+	WebhookUrl string `json:"webhookUrl,omitempty"`
+
+	State       JobState  `json:"state"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// NextRunAt is the earliest time a pending job is picked up again
+	NextRunAt time.Time `json:"nextRunAt"`
+}
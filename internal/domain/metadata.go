@@ -0,0 +1,196 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type Resolution struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type GpsCoordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Altitude is in meters above sea level
+	Altitude *float64 `json:"altitude,omitempty"`
+}
+
+type ImageMetadata struct {
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	Format ImageType `json:"format"`
+	// Orientation is the EXIF orientation in range 1..8, zero when the image has none
+	Orientation int    `json:"orientation,omitempty"`
+	ColorSpace  string `json:"colorSpace"`
+	// BitDepth is the number of bits per channel
+	BitDepth   int    `json:"bitDepth"`
+	HasAlpha   bool   `json:"hasAlpha"`
+	IccProfile string `json:"iccProfile,omitempty"`
+	// Dpi is the resolution in dots per inch, nil when the image doesn't declare one
+	Dpi *Resolution `json:"dpi,omitempty"`
+	// Pages counts the pages of documents and multi-page images
+	Pages int `json:"pages"`
+	// Frames counts the frames of animations
+	Frames      int             `json:"frames"`
+	CameraMake  string          `json:"cameraMake,omitempty"`
+	CameraModel string          `json:"cameraModel,omitempty"`
+	CaptureDate *time.Time      `json:"captureDate,omitempty"`
+	Gps         *GpsCoordinates `json:"gps,omitempty"`
+	HasExif     bool            `json:"hasExif"`
+	HasIptc     bool            `json:"hasIptc"`
+	HasXmp      bool            `json:"hasXmp"`
+}
+
+// exifFieldPattern splits the values libvips reports for exif fields, e.g.
+// "52/1 31/1 1234/100 (52, 31, 12.34, Rational, 3 components, 24 bytes)", into
+// the raw value and its human readable form.
+var exifFieldPattern = regexp.MustCompile(`^(.*) \((.*), [A-Za-z ]+, \d+ components?, \d+ bytes?\)$`)
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// ApplyExif fills in the camera, capture date and location from the exif fields
+// of an image as reported by libvips, keyed like "exif-ifd0-Make".
+func (m *ImageMetadata) ApplyExif(exif map[string]string) {
+	if len(exif) == 0 {
+		return
+	}
+	m.HasExif = true
+	m.CameraMake = exifText(exif["exif-ifd0-Make"])
+	m.CameraModel = exifText(exif["exif-ifd0-Model"])
+
+	for _, field := range []string{"exif-ifd2-DateTimeOriginal", "exif-ifd2-DateTimeDigitized", "exif-ifd0-DateTime"} {
+		captureDate, err := time.Parse(exifDateLayout, exifText(exif[field]))
+		if err == nil {
+			m.CaptureDate = &captureDate
+			break
+		}
+	}
+
+	latitude, latOk := exifCoordinate(exif["exif-ifd3-GPSLatitude"], exif["exif-ifd3-GPSLatitudeRef"], "S")
+	longitude, lonOk := exifCoordinate(exif["exif-ifd3-GPSLongitude"], exif["exif-ifd3-GPSLongitudeRef"], "W")
+	if !latOk || !lonOk {
+		return
+	}
+	m.Gps = &GpsCoordinates{Latitude: latitude, Longitude: longitude}
+	if altitudes, ok := exifRationals(exif["exif-ifd3-GPSAltitude"]); ok && len(altitudes) == 1 {
+		altitude := altitudes[0]
+		if exifRaw(exif["exif-ifd3-GPSAltitudeRef"]) == "1" {
+			altitude = -altitude
+		}
+		m.Gps.Altitude = &altitude
+	}
+}
+
+func exifRaw(value string) string {
+	if match := exifFieldPattern.FindStringSubmatch(value); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return strings.TrimSpace(value)
+}
+
+func exifText(value string) string {
+	if match := exifFieldPattern.FindStringSubmatch(value); match != nil {
+		return strings.TrimSpace(match[2])
+	}
+	return strings.TrimSpace(value)
+}
+
+func exifRationals(value string) ([]float64, bool) {
+	raw := exifRaw(value)
+	if raw == "" {
+		return nil, false
+	}
+	var rationals []float64
+	for _, part := range strings.Fields(raw) {
+		numerator, denominator, found := strings.Cut(part, "/")
+		n, err := strconv.ParseFloat(numerator, 64)
+		if err != nil {
+			return nil, false
+		}
+		d := 1.0
+		if found {
+			if d, err = strconv.ParseFloat(denominator, 64); err != nil || d == 0 {
+				return nil, false
+			}
+		}
+		rationals = append(rationals, n/d)
+	}
+	return rationals, true
+}
+
+// exifCoordinate converts degrees, minutes and seconds to decimal degrees which
+// are negative for the negativeRef hemisphere.
+func exifCoordinate(value, ref, negativeRef string) (float64, bool) {
+	parts, ok := exifRationals(value)
+	if !ok || len(parts) != 3 {
+		return 0, false
+	}
+	coordinate := parts[0] + parts[1]/60 + parts[2]/3600
+	if strings.EqualFold(exifText(ref), negativeRef) {
+		coordinate = -coordinate
+	}
+	return math.Round(coordinate*1e6) / 1e6, true
+}
+
+// IccProfileDescription returns the description of an ICC profile, e.g.
+// "sRGB IEC61966-2.1", or an empty string if it has none.
+func IccProfileDescription(profile []byte) string {
+	const headerSize = 128
+	if len(profile) < headerSize+4 {
+		return ""
+	}
+	tagCount := int(binary.BigEndian.Uint32(profile[headerSize:]))
+	for i := 0; i < tagCount; i++ {
+		entry := headerSize + 4 + i*12
+		if entry+12 > len(profile) {
+			return ""
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || size < 12 || offset+size > len(profile) {
+			return ""
+		}
+		return iccText(profile[offset : offset+size])
+	}
+	return ""
+}
+
+// iccText decodes the ICC v2 textDescriptionType and the ICC v4
+// multiLocalizedUnicodeType, taking the first record of the latter.
+func iccText(tag []byte) string {
+	switch string(tag[:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+length > len(tag) {
+			return ""
+		}
+		return string(bytes.TrimRight(tag[12:12+length], "\x00"))
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	default:
+		return ""
+	}
+}
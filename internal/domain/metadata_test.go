@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestApplyExif(t *testing.T) {
+	t.Run("camera, capture date and location are read", func(t *testing.T) {
+		var metadata ImageMetadata
+
+		metadata.ApplyExif(map[string]string{
+			"exif-ifd0-Make":              "Canon (Canon, ASCII, 6 components, 6 bytes)",
+			"exif-ifd0-Model":             "Canon EOS 5D (Canon EOS 5D, ASCII, 13 components, 13 bytes)",
+			"exif-ifd2-DateTimeOriginal":  "2021:07:14 09:30:00 (2021:07:14 09:30:00, ASCII, 20 components, 20 bytes)",
+			"exif-ifd3-GPSLatitude":       "52/1 31/1 1200/100 (52, 31, 12.00, Rational, 3 components, 24 bytes)",
+			"exif-ifd3-GPSLatitudeRef":    "N (N, ASCII, 2 components, 2 bytes)",
+			"exif-ifd3-GPSLongitude":      "13/1 24/1 0/1 (13, 24, 0, Rational, 3 components, 24 bytes)",
+			"exif-ifd3-GPSLongitudeRef":   "W (W, ASCII, 2 components, 2 bytes)",
+			"exif-ifd3-GPSAltitude":       "345/10 (34.5 m, Rational, 1 components, 8 bytes)",
+			"exif-ifd3-GPSAltitudeRef":    "1 (Below sea level, Byte, 1 components, 1 bytes)",
+			"exif-ifd0-ResolutionUnit":    "2 (Inch, Short, 1 components, 2 bytes)",
+			"exif-ifd2-ExposureTime":      "1/200 (1/200 sec., Rational, 1 components, 8 bytes)",
+			"exif-ifd2-DateTimeDigitized": "garbage",
+		})
+
+		altitude := -34.5
+		assert.True(t, metadata.HasExif)
+		assert.Equal(t, "Canon", metadata.CameraMake)
+		assert.Equal(t, "Canon EOS 5D", metadata.CameraModel)
+		assert.Equal(t, time.Date(2021, 7, 14, 9, 30, 0, 0, time.UTC), *metadata.CaptureDate)
+		assert.Equal(t, &GpsCoordinates{Latitude: 52.52, Longitude: -13.4, Altitude: &altitude}, metadata.Gps)
+	})
+	t.Run("incomplete locations are left out", func(t *testing.T) {
+		var metadata ImageMetadata
+
+		metadata.ApplyExif(map[string]string{
+			"exif-ifd3-GPSLatitude":    "52/1 31/1 1200/100 (52, 31, 12.00, Rational, 3 components, 24 bytes)",
+			"exif-ifd3-GPSLatitudeRef": "N (N, ASCII, 2 components, 2 bytes)",
+		})
+
+		assert.True(t, metadata.HasExif)
+		assert.Nil(t, metadata.Gps)
+		assert.Nil(t, metadata.CaptureDate)
+	})
+	t.Run("images without exif", func(t *testing.T) {
+		var metadata ImageMetadata
+
+		metadata.ApplyExif(map[string]string{})
+
+		assert.Equal(t, ImageMetadata{}, metadata)
+	})
+}
+
+func iccProfile(tag []byte) []byte {
+	profile := make([]byte, 128+4+12)
+	binary.BigEndian.PutUint32(profile[128:], 1)
+	copy(profile[132:], "desc")
+	binary.BigEndian.PutUint32(profile[136:], uint32(len(profile)))
+	binary.BigEndian.PutUint32(profile[140:], uint32(len(tag)))
+	return append(profile, tag...)
+}
+
+func TestIccProfileDescription(t *testing.T) {
+	t.Run("icc v2 text description", func(t *testing.T) {
+		text := "sRGB IEC61966-2.1\x00"
+		tag := append([]byte("desc\x00\x00\x00\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(text)))...)
+		tag = append(tag, text...)
+
+		assert.Equal(t, "sRGB IEC61966-2.1", IccProfileDescription(iccProfile(tag)))
+	})
+	t.Run("icc v4 multi localized unicode", func(t *testing.T) {
+		text := utf16.Encode([]rune("Display P3"))
+		tag := []byte("mluc\x00\x00\x00\x00")
+		tag = binary.BigEndian.AppendUint32(tag, 1)
+		tag = binary.BigEndian.AppendUint32(tag, 12)
+		tag = append(tag, "enUS"...)
+		tag = binary.BigEndian.AppendUint32(tag, uint32(len(text)*2))
+		tag = binary.BigEndian.AppendUint32(tag, 28)
+		for _, unit := range text {
+			tag = binary.BigEndian.AppendUint16(tag, unit)
+		}
+
+		assert.Equal(t, "Display P3", IccProfileDescription(iccProfile(tag)))
+	})
+	t.Run("truncated profiles", func(t *testing.T) {
+		assert.Equal(t, "", IccProfileDescription([]byte("acsp")))
+		assert.Equal(t, "", IccProfileDescription(iccProfile([]byte("desc\x00\x00\x00\x00\x00\x00\x00\xff"))))
+	})
+}
@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidOriginName = errors.New("invalid origin image name")
+
+// originNamePattern keeps pulled images to names which are a single, plain path
+// segment both at the origin and in storage.
+var originNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,254}$`)
+
+type OriginConfig struct {
+	// BaseUrl is the url originals missing from storage are requested from,
+	// followed by their name
+	BaseUrl string `json:"baseUrl"`
+}
+
+// ImageUrl returns the url of the original named name at the origin.
+func (oc OriginConfig) ImageUrl(name string) (string, error) {
+	if !originNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		return "", ErrInvalidOriginName
+	}
+	return strings.TrimSuffix(oc.BaseUrl, "/") + "/" + url.PathEscape(name), nil
+}
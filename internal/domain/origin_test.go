@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOriginImageUrl(t *testing.T) {
+	testCases := []struct {
+		baseUrl     string
+		name        string
+		expected    string
+		expectedErr error
+	}{
+		{baseUrl: "https://bucket.example.com/images", name: "cat.jpg", expected: "https://bucket.example.com/images/cat.jpg"},
+		{baseUrl: "https://bucket.example.com/images/", name: "cat_2-b.png", expected: "https://bucket.example.com/images/cat_2-b.png"},
+		{baseUrl: "https://bucket.example.com", name: "..", expectedErr: ErrInvalidOriginName},
+		{baseUrl: "https://bucket.example.com", name: "a..b", expectedErr: ErrInvalidOriginName},
+		{baseUrl: "https://bucket.example.com", name: ".hidden", expectedErr: ErrInvalidOriginName},
+		{baseUrl: "https://bucket.example.com", name: "cat.jpg?x=1", expectedErr: ErrInvalidOriginName},
+		{baseUrl: "https://bucket.example.com", name: "", expectedErr: ErrInvalidOriginName},
+	}
+	for _, tc := range testCases {
+		res, err := OriginConfig{BaseUrl: tc.baseUrl}.ImageUrl(tc.name)
+
+		if tc.expectedErr != nil {
+			assert.ErrorIs(t, err, tc.expectedErr, tc.name)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+	}
+}
@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Gravity int
+
+const (
+	Gravity_CENTER Gravity = iota
+	Gravity_NORTH
+	Gravity_SOUTH
+	Gravity_EAST
+	Gravity_WEST
+	Gravity_NORTH_EAST
+	Gravity_NORTH_WEST
+	Gravity_SOUTH_EAST
+	Gravity_SOUTH_WEST
+)
+
+func (g Gravity) String() string {
+	switch g {
+	case Gravity_CENTER:
+		return "center"
+	case Gravity_NORTH:
+		return "north"
+	case Gravity_SOUTH:
+		return "south"
+	case Gravity_EAST:
+		return "east"
+	case Gravity_WEST:
+		return "west"
+	case Gravity_NORTH_EAST:
+		return "northeast"
+	case Gravity_NORTH_WEST:
+		return "northwest"
+	case Gravity_SOUTH_EAST:
+		return "southeast"
+	case Gravity_SOUTH_WEST:
+		return "southwest"
+	default:
+		return "unknown"
+	}
+}
+
+func GravityFromString(gravityStr string) (Gravity, error) {
+	switch strings.ToLower(gravityStr) {
+	case "center", "centre", "":
+		return Gravity_CENTER, nil
+	case "north", "n":
+		return Gravity_NORTH, nil
+	case "south", "s":
+		return Gravity_SOUTH, nil
+	case "east", "e":
+		return Gravity_EAST, nil
+	case "west", "w":
+		return Gravity_WEST, nil
+	case "northeast", "ne":
+		return Gravity_NORTH_EAST, nil
+	case "northwest", "nw":
+		return Gravity_NORTH_WEST, nil
+	case "southeast", "se":
+		return Gravity_SOUTH_EAST, nil
+	case "southwest", "sw":
+		return Gravity_SOUTH_WEST, nil
+	default:
+		return -1, fmt.Errorf("unsupported gravity: %v", gravityStr)
+	}
+}
+
+func (g Gravity) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+func (g *Gravity) UnmarshalText(text []byte) error {
+	gravity, err := GravityFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*g = gravity
+	return nil
+}
+
+// Position returns the top-left coordinates of an inner box placed inside an
+// outer box according to the gravity, keeping margin pixels away from the edges
+// it is anchored to.
+func (g Gravity) Position(outerWidth, outerHeight, innerWidth, innerHeight, margin int) (int, int) {
+	left := (outerWidth - innerWidth) / 2
+	top := (outerHeight - innerHeight) / 2
+
+	switch g {
+	case Gravity_NORTH, Gravity_NORTH_EAST, Gravity_NORTH_WEST:
+		top = margin
+	case Gravity_SOUTH, Gravity_SOUTH_EAST, Gravity_SOUTH_WEST:
+		top = outerHeight - innerHeight - margin
+	}
+	switch g {
+	case Gravity_WEST, Gravity_NORTH_WEST, Gravity_SOUTH_WEST:
+		left = margin
+	case Gravity_EAST, Gravity_NORTH_EAST, Gravity_SOUTH_EAST:
+		left = outerWidth - innerWidth - margin
+	}
+	return left, top
+}
+
+type OverlayOpts struct {
+	Gravity Gravity
+	Margin  int
+	// Opacity is in range (0, 1]; zero is treated as fully opaque
+	Opacity float64
+	// Scale is the overlay width relative to the base image width; zero keeps the overlay size
+	Scale float64
+	Tile  bool
+}
+
+type WatermarkConfig struct {
+	// ImageName is the name of a parent image of the same tenant used as the logo
+	ImageName string  `json:"imageName"`
+	Gravity   Gravity `json:"gravity"`
+	Margin    int     `json:"margin"`
+	Opacity   float64 `json:"opacity"`
+	Scale     float64 `json:"scale"`
+	Tile      bool    `json:"tile"`
+	// EnforceAboveWidth forces the watermark on every output wider than the given
+	// number of pixels regardless of the request; zero disables enforcement
+	EnforceAboveWidth int `json:"enforceAboveWidth"`
+}
+
+func (wc WatermarkConfig) OverlayOpts() OverlayOpts {
+	return OverlayOpts{
+		Gravity: wc.Gravity,
+		Margin:  wc.Margin,
+		Opacity: wc.Opacity,
+		Scale:   wc.Scale,
+		Tile:    wc.Tile,
+	}
+}
+
+func (wc WatermarkConfig) String() string {
+	return fmt.Sprintf("wm:%s:%s:%d:%g:%g:%t",
+		wc.ImageName, wc.Gravity, wc.Margin, wc.Opacity, wc.Scale, wc.Tile)
+}
+
+type TextOpts struct {
+	Text string
+	// Font is a font family from the bundled font directory; empty uses the default font
+	Font  string
+	Size  int
+	Color Color
+	// Background fills a box behind the text when set
+	Background *Color
+	Gravity    Gravity
+	Margin     int
+	// WrapWidth wraps the text at the given number of pixels; zero wraps at the image width
+	WrapWidth int
+}
+
+func (to TextOpts) String() string {
+	background := ""
+	if to.Background != nil {
+		background = to.Background.Hex()
+	}
+	return fmt.Sprintf("text:%q:%s:%d:%s:%s:%s:%d:%d",
+		to.Text, to.Font, to.Size, to.Color.Hex(), background, to.Gravity, to.Margin, to.WrapWidth)
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGravityPosition(t *testing.T) {
+	testCases := []struct {
+		gravity      Gravity
+		margin       int
+		expectedLeft int
+		expectedTop  int
+	}{
+		{gravity: Gravity_CENTER, margin: 10, expectedLeft: 40, expectedTop: 20},
+		{gravity: Gravity_NORTH, margin: 10, expectedLeft: 40, expectedTop: 10},
+		{gravity: Gravity_SOUTH, margin: 10, expectedLeft: 40, expectedTop: 30},
+		{gravity: Gravity_EAST, margin: 10, expectedLeft: 70, expectedTop: 20},
+		{gravity: Gravity_WEST, margin: 10, expectedLeft: 10, expectedTop: 20},
+		{gravity: Gravity_NORTH_WEST, margin: 0, expectedLeft: 0, expectedTop: 0},
+		{gravity: Gravity_SOUTH_EAST, margin: 5, expectedLeft: 75, expectedTop: 35},
+	}
+	for _, tc := range testCases {
+		left, top := tc.gravity.Position(100, 60, 20, 20, tc.margin)
+		assert.Equal(t, tc.expectedLeft, left, tc.gravity)
+		assert.Equal(t, tc.expectedTop, top, tc.gravity)
+	}
+}
+
+func TestGravityFromString(t *testing.T) {
+	testCases := []struct {
+		str      string
+		expected Gravity
+		isValid  bool
+	}{
+		{str: "", expected: Gravity_CENTER, isValid: true},
+		{str: "southeast", expected: Gravity_SOUTH_EAST, isValid: true},
+		{str: "NW", expected: Gravity_NORTH_WEST, isValid: true},
+		{str: "middle", isValid: false},
+	}
+	for _, tc := range testCases {
+		res, err := GravityFromString(tc.str)
+		if !tc.isValid {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+	}
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"errors"
+	"image"
+	"sort"
+)
+
+var ErrNoOpaquePixels = errors.New("image has no opaque pixels")
+
+// paletteAlphaThreshold is the alpha below which pixels are too transparent to
+// count towards the colors of an image
+const paletteAlphaThreshold = 128
+
+type PaletteColor struct {
+	Color Color `json:"color"`
+	// Percentage is the share of the opaque pixels of the image close to the color
+	Percentage float64 `json:"percentage"`
+}
+
+type ColorAnalysis struct {
+	Dominant Color `json:"dominant"`
+	// Palette is ordered by decreasing percentage
+	Palette []PaletteColor `json:"palette"`
+}
+
+// ExtractPalette reduces the opaque pixels of the image to at most count colors
+// using median cut. Images of a few thousand pixels are plenty to get a stable
+// palette.
+func ExtractPalette(img *image.NRGBA, count int) (ColorAnalysis, error) {
+	var pixels [][3]uint8
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			pixel := img.NRGBAAt(x, y)
+			if pixel.A < paletteAlphaThreshold {
+				continue
+			}
+			pixels = append(pixels, [3]uint8{pixel.R, pixel.G, pixel.B})
+		}
+	}
+	if len(pixels) == 0 {
+		return ColorAnalysis{}, ErrNoOpaquePixels
+	}
+
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < count {
+		// split the box with the widest channel range at the median of that channel
+		widest, widestChannel, widestRange := -1, 0, 0
+		for i, box := range boxes {
+			channel, channelRange := widestChannelOf(box)
+			if channelRange > widestRange {
+				widest, widestChannel, widestRange = i, channel, channelRange
+			}
+		}
+		if widest < 0 {
+			// every box holds a single color
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(a, b int) bool {
+			return box[a][widestChannel] < box[b][widestChannel]
+		})
+		// keep pixels sharing the median value together so flat areas of a
+		// single color don't get averaged with their neighbours
+		medianValue := box[len(box)/2][widestChannel]
+		split := sort.Search(len(box), func(i int) bool { return box[i][widestChannel] >= medianValue })
+		if split == 0 {
+			split = sort.Search(len(box), func(i int) bool { return box[i][widestChannel] > medianValue })
+		}
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make([]PaletteColor, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b int
+		for _, pixel := range box {
+			r += int(pixel[0])
+			g += int(pixel[1])
+			b += int(pixel[2])
+		}
+		palette = append(palette, PaletteColor{
+			Color: Color{
+				R: uint8((r + len(box)/2) / len(box)),
+				G: uint8((g + len(box)/2) / len(box)),
+				B: uint8((b + len(box)/2) / len(box)),
+				A: 0xff,
+			},
+			Percentage: float64(len(box)) * 100 / float64(len(pixels)),
+		})
+	}
+	sort.SliceStable(palette, func(a, b int) bool {
+		return palette[a].Percentage > palette[b].Percentage
+	})
+	return ColorAnalysis{
+		Dominant: palette[0].Color,
+		Palette:  palette,
+	}, nil
+}
+
+func widestChannelOf(pixels [][3]uint8) (int, int) {
+	minimum := [3]uint8{255, 255, 255}
+	var maximum [3]uint8
+	for _, pixel := range pixels {
+		for c := 0; c < 3; c++ {
+			minimum[c] = min(minimum[c], pixel[c])
+			maximum[c] = max(maximum[c], pixel[c])
+		}
+	}
+	channel, channelRange := 0, 0
+	for c := 0; c < 3; c++ {
+		if int(maximum[c])-int(minimum[c]) > channelRange {
+			channel, channelRange = c, int(maximum[c])-int(minimum[c])
+		}
+	}
+	return channel, channelRange
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestExtractPalette(t *testing.T) {
+	t.Run("colors are ordered by their share of the image", func(t *testing.T) {
+		img := solidImage(10, 10, color.NRGBA{R: 255, A: 255})
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 10; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+
+		analysis, err := ExtractPalette(img, 2)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Color{R: 255, A: 255}, analysis.Dominant)
+		assert.Equal(t, []PaletteColor{
+			{Color: Color{R: 255, A: 255}, Percentage: 70},
+			{Color: Color{B: 255, A: 255}, Percentage: 30},
+		}, analysis.Palette)
+	})
+	t.Run("the palette is never larger than the number of distinct colors", func(t *testing.T) {
+		analysis, err := ExtractPalette(solidImage(4, 4, color.NRGBA{G: 128, A: 255}), 5)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []PaletteColor{{Color: Color{G: 128, A: 255}, Percentage: 100}}, analysis.Palette)
+	})
+	t.Run("transparent pixels are ignored", func(t *testing.T) {
+		img := solidImage(4, 4, color.NRGBA{R: 255})
+		img.SetNRGBA(0, 0, color.NRGBA{G: 255, A: 255})
+
+		analysis, err := ExtractPalette(img, 3)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Color{G: 255, A: 255}, analysis.Dominant)
+		assert.Len(t, analysis.Palette, 1)
+
+		_, err = ExtractPalette(image.NewNRGBA(image.Rect(0, 0, 4, 4)), 3)
+		assert.ErrorIs(t, err, ErrNoOpaquePixels)
+	})
+}
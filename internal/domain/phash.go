@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// PerceptualHash is a 64 bit difference hash (dHash) of an image. Images which
+// look alike have hashes that differ in few bits, whatever their size,
+// compression or small crops.
+type PerceptualHash uint64
+
+// MaxPerceptualHashDistance is the distance between two hashes differing in every bit
+const MaxPerceptualHashDistance = 64
+
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// DHash computes the difference hash of the image: the image is reduced to a 9x8
+// grid of gray levels, translucent pixels composited on white, and every bit
+// tells whether a cell is darker than its right neighbour.
+func DHash(img *image.NRGBA) (PerceptualHash, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width == 0 || height == 0 {
+		return 0, fmt.Errorf("can not hash an empty image")
+	}
+
+	var grid [dHashHeight][dHashWidth]float64
+	for gy := 0; gy < dHashHeight; gy++ {
+		top, bottom := cellBounds(gy, dHashHeight, height)
+		for gx := 0; gx < dHashWidth; gx++ {
+			left, right := cellBounds(gx, dHashWidth, width)
+			var sum float64
+			for y := top; y < bottom; y++ {
+				for x := left; x < right; x++ {
+					pixel := img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+					gray := 0.299*float64(pixel.R) + 0.587*float64(pixel.G) + 0.114*float64(pixel.B)
+					alpha := float64(pixel.A) / 255
+					sum += gray*alpha + 255*(1-alpha)
+				}
+			}
+			grid[gy][gx] = sum / float64((bottom-top)*(right-left))
+		}
+	}
+
+	var hash PerceptualHash
+	for gy := 0; gy < dHashHeight; gy++ {
+		for gx := 0; gx < dHashWidth-1; gx++ {
+			hash <<= 1
+			if grid[gy][gx] < grid[gy][gx+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// cellBounds returns the range of pixels covered by a cell of a grid with cells
+// cells laid over size pixels. Every cell covers at least one pixel.
+func cellBounds(cell, cells, size int) (int, int) {
+	start := cell * size / cells
+	end := (cell + 1) * size / cells
+	if end <= start {
+		end = min(start+1, size)
+		start = end - 1
+	}
+	return start, end
+}
+
+// Distance is the number of bits the hashes differ in.
+func (ph PerceptualHash) Distance(other PerceptualHash) int {
+	return bits.OnesCount64(uint64(ph ^ other))
+}
+
+func (ph PerceptualHash) String() string {
+	return fmt.Sprintf("%016x", uint64(ph))
+}
+
+func ParsePerceptualHash(str string) (PerceptualHash, error) {
+	value, err := strconv.ParseUint(str, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid perceptual hash: %v", str)
+	}
+	return PerceptualHash(value), nil
+}
+
+type SimilarImage struct {
+	Name     string `json:"imgName"`
+	Distance int    `json:"distance"`
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradientImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), A: 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	t.Run("a horizontal gradient brightening to the right sets every bit", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 18, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 18; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 14), G: uint8(x * 14), B: uint8(x * 14), A: 255})
+			}
+		}
+
+		hash, err := DHash(img)
+
+		assert.NoError(t, err)
+		assert.Equal(t, PerceptualHash(0xffffffffffffffff), hash)
+	})
+	t.Run("a solid image sets no bit", func(t *testing.T) {
+		hash, err := DHash(solidImage(40, 30, color.NRGBA{R: 12, G: 200, B: 99, A: 255}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, PerceptualHash(0), hash)
+	})
+	t.Run("rescaled images hash alike", func(t *testing.T) {
+		small, err := DHash(gradientImage(36, 32))
+		assert.NoError(t, err)
+		large, err := DHash(gradientImage(72, 64))
+		assert.NoError(t, err)
+
+		assert.LessOrEqual(t, small.Distance(large), 4)
+	})
+	t.Run("images smaller than the grid", func(t *testing.T) {
+		_, err := DHash(solidImage(3, 2, color.NRGBA{A: 255}))
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestPerceptualHash(t *testing.T) {
+	assert.Equal(t, 0, PerceptualHash(0xff).Distance(0xff))
+	assert.Equal(t, 64, PerceptualHash(0).Distance(0xffffffffffffffff))
+	assert.Equal(t, "00000000000000ff", PerceptualHash(0xff).String())
+
+	hash, err := ParsePerceptualHash("f0e1d2c3b4a59687")
+	assert.NoError(t, err)
+	assert.Equal(t, PerceptualHash(0xf0e1d2c3b4a59687), hash)
+	_, err = ParsePerceptualHash("not a hash")
+	assert.Error(t, err)
+}
@@ -0,0 +1,293 @@
+package domain
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+type PlaceholderType int
+
+const (
+	PlaceholderType_BLURHASH PlaceholderType = iota
+	PlaceholderType_THUMBHASH
+	PlaceholderType_LQIP
+)
+
+var PlaceholderTypes = []PlaceholderType{
+	PlaceholderType_BLURHASH,
+	PlaceholderType_THUMBHASH,
+	PlaceholderType_LQIP,
+}
+
+func (pt PlaceholderType) String() string {
+	switch pt {
+	case PlaceholderType_BLURHASH:
+		return "blurhash"
+	case PlaceholderType_THUMBHASH:
+		return "thumbhash"
+	case PlaceholderType_LQIP:
+		return "lqip"
+	default:
+		return "unknown"
+	}
+}
+
+func PlaceholderTypeFromString(placeholderTypeStr string) (PlaceholderType, error) {
+	switch strings.ToLower(placeholderTypeStr) {
+	case "blurhash":
+		return PlaceholderType_BLURHASH, nil
+	case "thumbhash":
+		return PlaceholderType_THUMBHASH, nil
+	case "lqip":
+		return PlaceholderType_LQIP, nil
+	default:
+		return -1, fmt.Errorf("unsupported placeholder type: %v", placeholderTypeStr)
+	}
+}
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// EncodeBlurHash encodes the image into a BlurHash (https://blurha.sh) made of
+// xComponents by yComponents cosine components, each in range 1..9. Small
+// images of a few dozen pixels give the same result much faster.
+func EncodeBlurHash(img *image.NRGBA, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components out of range: %dx%d", xComponents, yComponents)
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("can not encode an empty image")
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * basisY
+					pixel := img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+					r += basis * sRGBToLinear(pixel.R)
+					g += basis * sRGBToLinear(pixel.G)
+					b += basis * sRGBToLinear(pixel.B)
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximumValue := 0.0
+		for _, factor := range ac {
+			actualMaximumValue = math.Max(actualMaximumValue,
+				math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantisedMaximumValue := int(math.Max(0, math.Min(82, math.Floor(actualMaximumValue*166-0.5))))
+		maximumValue = float64(quantisedMaximumValue+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximumValue, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		quantR := quantiseBlurHashAC(factor[0], maximumValue)
+		quantG := quantiseBlurHashAC(factor[1], maximumValue)
+		quantB := quantiseBlurHashAC(factor[2], maximumValue)
+		hash.WriteString(encodeBase83(quantR*19*19+quantG*19+quantB, 2))
+	}
+	return hash.String(), nil
+}
+
+func quantiseBlurHashAC(value, maximumValue float64) int {
+	return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+}
+
+func encodeBase83(value int, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = base83Characters[digit]
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// EncodeThumbHash encodes the image into a ThumbHash (https://evanw.github.io/thumbhash).
+// The image must fit in 100x100 pixels.
+func EncodeThumbHash(img *image.NRGBA) ([]byte, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w == 0 || h == 0 || w > 100 || h > 100 {
+		return nil, fmt.Errorf("%dx%d doesn't fit in 100x100", w, h)
+	}
+
+	// determine the average color
+	var avgR, avgG, avgB, avgA float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pixel := img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			alpha := float64(pixel.A) / 255
+			avgR += alpha / 255 * float64(pixel.R)
+			avgG += alpha / 255 * float64(pixel.G)
+			avgB += alpha / 255 * float64(pixel.B)
+			avgA += alpha
+		}
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(w*h)
+	lLimit := 7.0
+	if hasAlpha {
+		// use fewer luminance bits if there's alpha
+		lLimit = 5
+	}
+	maxSide := float64(max(w, h))
+	lx := max(1, int(jsRound(lLimit*float64(w)/maxSide)))
+	ly := max(1, int(jsRound(lLimit*float64(h)/maxSide)))
+
+	// convert the image from RGBA to LPQA, composited atop the average color
+	l := make([]float64, w*h) // luminance
+	p := make([]float64, w*h) // yellow - blue
+	q := make([]float64, w*h) // red - green
+	a := make([]float64, w*h) // alpha
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pixel := img.NRGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			alpha := float64(pixel.A) / 255
+			r := avgR*(1-alpha) + alpha/255*float64(pixel.R)
+			g := avgG*(1-alpha) + alpha/255*float64(pixel.G)
+			b := avgB*(1-alpha) + alpha/255*float64(pixel.B)
+			i := x + y*w
+			l[i] = (r + g + b) / 3
+			p[i] = (r+g)/2 - b
+			q[i] = r - g
+			a[i] = alpha
+		}
+	}
+
+	// encode using the DCT into DC (constant) and normalized AC (varying) terms
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		var ac []float64
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				f := 0.0
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(w * h)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+	var aDC, aScale float64
+	var aAC []float64
+	if hasAlpha {
+		aDC, aAC, aScale = encodeChannel(a, 5, 5)
+	}
+
+	// write the constants
+	isLandscape := w > h
+	header24 := int(jsRound(63*lDC)) |
+		int(jsRound(31.5+31.5*pDC))<<6 |
+		int(jsRound(31.5+31.5*qDC))<<12 |
+		int(jsRound(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := ly
+	if !isLandscape {
+		header16 = lx
+	}
+	header16 |= int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if isLandscape {
+		header16 |= 1 << 15
+	}
+	hash := []byte{
+		byte(header24 & 255), byte((header24 >> 8) & 255), byte(header24 >> 16),
+		byte(header16 & 255), byte(header16 >> 8),
+	}
+	acs := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		hash = append(hash, byte(int(jsRound(15*aDC))|int(jsRound(15*aScale))<<4))
+		acs = append(acs, aAC)
+	}
+
+	// write the varying factors, two per byte
+	acStart := len(hash)
+	acIndex := 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			position := acStart + acIndex>>1
+			if position == len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[position] |= byte(int(jsRound(15*f)) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+	return hash, nil
+}
+
+// jsRound rounds half up like javascript's Math.round which the reference
+// implementations of the placeholder hashes rely on.
+func jsRound(value float64) float64 {
+	return math.Floor(value + 0.5)
+}
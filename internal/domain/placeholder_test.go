@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidImage(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestPlaceholderTypeFromString(t *testing.T) {
+	for _, placeholderType := range PlaceholderTypes {
+		res, err := PlaceholderTypeFromString(placeholderType.String())
+		assert.NoError(t, err)
+		assert.Equal(t, placeholderType, res)
+	}
+	_, err := PlaceholderTypeFromString("jpeg")
+	assert.Error(t, err)
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	t.Run("the dc component holds the average color", func(t *testing.T) {
+		hash, err := EncodeBlurHash(solidImage(8, 6, color.NRGBA{R: 255, A: 255}), 4, 3)
+
+		assert.NoError(t, err)
+		assert.Len(t, hash, 4+2*4*3)
+		assert.Equal(t, "L", hash[:1])
+		assert.Equal(t, "TI:j", hash[2:6])
+	})
+	t.Run("a single component has no ac components", func(t *testing.T) {
+		hash, err := EncodeBlurHash(solidImage(8, 6, color.NRGBA{R: 255, A: 255}), 1, 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "00TI:j", hash)
+	})
+	t.Run("the hash length depends on the number of components", func(t *testing.T) {
+		img := solidImage(8, 6, color.NRGBA{B: 255, A: 255})
+		img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, A: 255})
+
+		hash, err := EncodeBlurHash(img, 3, 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, hash, 4+2*3*2)
+		assert.Equal(t, "B", hash[:1])
+	})
+	t.Run("components out of range are rejected", func(t *testing.T) {
+		_, err := EncodeBlurHash(solidImage(8, 6, color.NRGBA{A: 255}), 10, 3)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestEncodeThumbHash(t *testing.T) {
+	t.Run("an opaque landscape image", func(t *testing.T) {
+		hash, err := EncodeThumbHash(solidImage(40, 20, color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+
+		assert.NoError(t, err)
+		assert.Zero(t, hash[2]&0x80, "alpha flag")
+		assert.NotZero(t, hash[4]&0x80, "landscape flag")
+		// a white image has full luminance and no chroma
+		assert.Equal(t, byte(63), hash[0]&0x3f)
+	})
+	t.Run("a translucent portrait image", func(t *testing.T) {
+		hash, err := EncodeThumbHash(solidImage(20, 40, color.NRGBA{R: 255, A: 128}))
+
+		assert.NoError(t, err)
+		assert.NotZero(t, hash[2]&0x80, "alpha flag")
+		assert.Zero(t, hash[4]&0x80, "landscape flag")
+	})
+	t.Run("images larger than 100px are rejected", func(t *testing.T) {
+		_, err := EncodeThumbHash(solidImage(101, 20, color.NRGBA{A: 255}))
+
+		assert.Error(t, err)
+	})
+}
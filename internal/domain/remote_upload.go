@@ -0,0 +1,8 @@
+package domain
+
+// RemoteUpload is the outcome of uploading one of a batch of remote urls.
+type RemoteUpload struct {
+	Url       string `json:"url"`
+	ImageName string `json:"imgName,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUploadMetadata = errors.New("invalid upload metadata")
+
+// MaxResumableUploadSize is the largest resumable upload accepted, the complete
+// upload is read into memory to be processed.
+const MaxResumableUploadSize = 1 << 30
+
+// ResumableUpload is an upload sent in chunks over several requests, following
+// the tus protocol (https://tus.io/protocols/resumable-upload).
+type ResumableUpload struct {
+	Id         string     `json:"id"`
+	TenantOpts TenantOpts `json:"tenantOpts"`
+	// Length is the size of the whole upload, Offset the number of bytes received
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// ExpiresAt is when an incomplete upload is dropped
+	ExpiresAt time.Time `json:"expiresAt"`
+	// ImageName is the name of the uploaded image once the upload is complete
+	ImageName string `json:"imgName,omitempty"`
+}
+
+func (ru ResumableUpload) Received() bool {
+	return ru.Offset == ru.Length
+}
+
+// ParseUploadMetadata parses the tus Upload-Metadata header, a comma separated
+// list of keys each optionally followed by a space and its base64 encoded value.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " ") {
+			return nil, ErrInvalidUploadMetadata
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, ErrInvalidUploadMetadata
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalidUploadMetadata
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// FormatUploadMetadata formats metadata the way ParseUploadMetadata parses it.
+func FormatUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
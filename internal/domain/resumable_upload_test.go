@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	testCases := []struct {
+		header      string
+		expected    map[string]string
+		expectedErr error
+	}{
+		{header: "", expected: map[string]string{}},
+		{
+			header:   "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential",
+			expected: map[string]string{"filename": "world_domination_plan.pdf", "is_confidential": ""},
+		},
+		{header: "filename not-base64!", expectedErr: ErrInvalidUploadMetadata},
+		{header: "filename YQ==,filename Yg==", expectedErr: ErrInvalidUploadMetadata},
+		{header: ",filename YQ==", expectedErr: ErrInvalidUploadMetadata},
+	}
+	for _, tc := range testCases {
+		res, err := ParseUploadMetadata(tc.header)
+
+		if tc.expectedErr != nil {
+			assert.ErrorIs(t, err, tc.expectedErr, tc.header)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+
+		// formatting round trips
+		parsed, err := ParseUploadMetadata(FormatUploadMetadata(res))
+		assert.NoError(t, err)
+		assert.Equal(t, res, parsed)
+	}
+}
@@ -0,0 +1,44 @@
+package domainsvc
+
+import (
+	"context"
+	"errors"
+	appsvc "example.com/imageProc/internal/app/service"
+	"example.com/imageProc/internal/domain"
+	"fmt"
+)
+
+var ErrUnauthenticated = errors.New("invalid credentials")
+
+type AuthServiceInterface interface {
+	AuthenticateApiKey(ctx context.Context, key string) (domain.Principal, error)
+	AuthenticateJwt(ctx context.Context, token string) (domain.Principal, error)
+}
+
+type AuthService struct {
+	authService appsvc.AuthServiceInterface
+}
+
+func (a AuthService) AuthenticateApiKey(ctx context.Context, key string) (domain.Principal, error) {
+	return authenticated(a.authService.AuthenticateApiKey(key))
+}
+
+func (a AuthService) AuthenticateJwt(ctx context.Context, token string) (domain.Principal, error) {
+	return authenticated(a.authService.AuthenticateJwt(token))
+}
+
+func authenticated(principal domain.Principal, err error) (domain.Principal, error) {
+	if err != nil {
+		if errors.Is(err, appsvc.ErrInvalidCredentials) {
+			return domain.Principal{}, ErrUnauthenticated
+		}
+		return domain.Principal{}, fmt.Errorf("internal error: %v", err)
+	}
+	return principal, nil
+}
+
+func NewAuthService(authSvc appsvc.AuthServiceInterface) AuthServiceInterface {
+	return AuthService{
+		authService: authSvc,
+	}
+}
@@ -0,0 +1,41 @@
+package domainsvc
+
+import (
+	"context"
+	"errors"
+	appsvc "example.com/imageProc/internal/app/service"
+	"example.com/imageProc/internal/domain"
+	"example.com/imageProc/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	principal := domain.Principal{
+		Subject:    "ci",
+		TenantOpts: domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"},
+		Scopes:     []domain.Scope{domain.Scope_READ},
+	}
+
+	mockAuthSvc := new(mock.AuthService)
+	mockAuthSvc.On("AuthenticateApiKey", "secret-key").Return(principal, nil)
+	mockAuthSvc.On("AuthenticateApiKey", "other-key").Return(domain.Principal{}, appsvc.ErrInvalidCredentials)
+	mockAuthSvc.On("AuthenticateJwt", "a.b.c").
+		Return(domain.Principal{}, errors.Join(appsvc.ErrInvalidCredentials, errors.New("token is expired")))
+	mockAuthSvc.On("AuthenticateJwt", "d.e.f").Return(domain.Principal{}, errors.New("disk error"))
+
+	svc := NewAuthService(mockAuthSvc)
+
+	res, err := svc.AuthenticateApiKey(ctx, "secret-key")
+	assert.NoError(t, err)
+	assert.Equal(t, principal, res)
+
+	_, err = svc.AuthenticateApiKey(ctx, "other-key")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = svc.AuthenticateJwt(ctx, "a.b.c")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = svc.AuthenticateJwt(ctx, "d.e.f")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnauthenticated)
+}
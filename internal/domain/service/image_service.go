@@ -2,11 +2,21 @@ package domainsvc
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	appsvc "example.com/imageProc/internal/app/service"
 	"example.com/imageProc/internal/domain"
 	"fmt"
+	"io"
 	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type GetImageOpts struct {
@@ -16,142 +26,1482 @@ type GetImageOpts struct {
 	Height     *int
 	Ar         *domain.AR
 	Type       domain.ImageType
+	// Watermark requests the tenant configured watermark; nil leaves it to the tenant configuration
+	Watermark *bool
+	Text      *domain.TextOpts
+	Shape     *domain.ShapeOpts
+	// Frame selects a single zero based frame of an animated image to be served as a still
+	Frame *int
+	// Page selects a one based page of multi-page parents such as TIFF and PDF
+	Page *int
+	// Density is the dpi vector parents such as SVG and PDF are rasterized at
+	Density *int
+	// Dpr is the device pixel ratio the requested width and height are multiplied by
+	Dpr *float64
+	// MaxBytes is the size the encoded image must fit in, reached by lowering the
+	// encoder quality and then the dimensions
+	MaxBytes *int
+	// Access is checked against the visibility of the image before anything is served
+	Access domain.Access
+}
+
+type SrcsetOpts struct {
+	TenantOpts domain.TenantOpts
+	Name       string
+	Widths     []int
+	Ar         *domain.AR
+	// Access is checked against the visibility of the image, the variant urls
+	// carry the signature presented or one issued to the principal
+	Access domain.Access
+	// Prerender queues every variant to be rendered in the background so the
+	// first visitors don't wait for them. It is ignored without credentials
+	Prerender bool
 }
 
 type ImageServiceInterface interface {
 	Upload(ctx context.Context, imageByte []byte, tenantOpts domain.TenantOpts) (string, error)
+	// UploadFromUrl uploads the image fetched from a remote http(s) url
+	UploadFromUrl(ctx context.Context, url string, tenantOpts domain.TenantOpts) (string, error)
+	// UploadFromUrls uploads a batch of remote urls, reporting the outcome of
+	// each in the order of the urls
+	UploadFromUrls(ctx context.Context, urls []string, tenantOpts domain.TenantOpts) []domain.RemoteUpload
+	// UploadBatch uploads a batch of files, reporting the outcome of each in the
+	// order of the files
+	UploadBatch(ctx context.Context, files []domain.BatchFile, tenantOpts domain.TenantOpts) []domain.BatchUpload
+	// IssueUploadToken signs a token letting clients without the tenant's
+	// credentials upload to it within the constraints of the token
+	IssueUploadToken(ctx context.Context, token domain.UploadToken) (domain.UploadToken, string, error)
+	// UploadWithToken uploads the image to the tenant of the signed token,
+	// returning its name along with the tenant
+	UploadWithToken(ctx context.Context, signedToken string, imageByte []byte) (string, domain.TenantOpts, error)
+	// CreateUpload starts a resumable upload of length bytes
+	CreateUpload(ctx context.Context, length int64, metadata map[string]string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error)
+	GetUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error)
+	// AppendUpload writes a chunk of a resumable upload at offset and uploads
+	// the image once all of it was received
+	AppendUpload(ctx context.Context, id string, offset int64, chunk io.Reader, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error)
+	TerminateUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) error
 	GetImage(ctx context.Context, opts GetImageOpts) ([]byte, error)
+	// GetCompressedImage is GetImage reporting how the image was fitted into the
+	// MaxBytes budget; the report is empty without a budget
+	GetCompressedImage(ctx context.Context, opts GetImageOpts) ([]byte, domain.Compression, error)
+	GetOriginalImage(ctx context.Context, name string, access domain.Access, tenantOpts domain.TenantOpts) ([]byte, domain.ImageType, error)
+	GetVisibility(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.Visibility, error)
+	SetVisibility(ctx context.Context, name string, visibility domain.Visibility, tenantOpts domain.TenantOpts) error
+	// SignImageUrl returns the signature of urls serving the image until expiresAt
+	SignImageUrl(ctx context.Context, name string, expiresAt time.Time, tenantOpts domain.TenantOpts) string
+	GetPlaceholders(ctx context.Context, name string, types []domain.PlaceholderType, access domain.Access, tenantOpts domain.TenantOpts) (map[domain.PlaceholderType]string, error)
+	GetColors(ctx context.Context, name string, count int, access domain.Access, tenantOpts domain.TenantOpts) (domain.ColorAnalysis, error)
+	FindSimilar(ctx context.Context, name string, distance int, tenantOpts domain.TenantOpts) ([]domain.SimilarImage, error)
+	GetMetadata(ctx context.Context, name string, access domain.Access, tenantOpts domain.TenantOpts) (domain.ImageMetadata, error)
+	GetSrcset(ctx context.Context, opts SrcsetOpts) (domain.Srcset, error)
+	GetEagerStatus(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.EagerStatus, error)
+	// Reprocess queues a job dropping the derived data of the images, all of the
+	// tenant's without names, and building it again
+	Reprocess(ctx context.Context, names []string, tenantOpts domain.TenantOpts) (domain.Job, error)
+	// Purge queues a job deleting the image along with everything derived from it
+	Purge(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.Job, error)
+	GetJob(ctx context.Context, id string, tenantOpts domain.TenantOpts) (domain.Job, error)
+	// HandleJob runs a job claimed from the job queue
+	HandleJob(ctx context.Context, job domain.Job) error
+	GetWebhookDeliveries(ctx context.Context, limit int, tenantOpts domain.TenantOpts) ([]domain.WebhookDelivery, error)
+	// GetUsage returns the storage used by the tenant along with its quota
+	GetUsage(ctx context.Context, tenantOpts domain.TenantOpts) (domain.UsageSnapshot, error)
+	// SnapshotUsage returns the usage of every tenant, for billing
+	SnapshotUsage(ctx context.Context) ([]domain.UsageSnapshot, error)
+}
+
+type ImageService struct {
+	processorService    appsvc.ImageProcessingServiceInterface
+	storageService      appsvc.ImageStorageServiceInterface
+	tenantConfigService appsvc.TenantConfigServiceInterface
+	jobQueue            appsvc.JobQueueInterface
+	webhookService      appsvc.WebhookServiceInterface
+	remoteFetchService  appsvc.RemoteFetchServiceInterface
+	uploadStore         appsvc.ResumableUploadStoreInterface
+	uploadTokenService  appsvc.UploadTokenServiceInterface
+	urlSigner           appsvc.UrlSignerInterface
+}
+
+var (
+	ErrNotFound               = errors.New("no primary image found")
+	ErrUnsupportedImageFormat = errors.New("unsupported image format")
+	ErrUnsupportedFont        = errors.New("unsupported font")
+	ErrInvalidFrame           = errors.New("invalid frame")
+	ErrInvalidPage            = errors.New("invalid page")
+	ErrNoColors               = errors.New("image has no opaque pixels")
+	ErrBudgetUnreachable      = errors.New("image does not fit in the byte budget")
+	ErrNoEagerStatus          = errors.New("no eager transformations")
+	ErrJobNotFound            = errors.New("job not found")
+	ErrInvalidRemoteUrl       = errors.New("invalid remote url")
+	ErrForbiddenRemoteAddress = errors.New("remote address is not allowed")
+	ErrRemoteTooLarge         = errors.New("remote image is too large")
+	ErrRemoteFetchFailed      = errors.New("error fetching remote image")
+	ErrUploadNotFound         = errors.New("upload not found")
+	ErrUploadTooLarge         = errors.New("upload is too large")
+	ErrUploadOffsetMismatch   = errors.New("upload offset does not match")
+	ErrUploadExceedsLength    = errors.New("upload exceeds its length")
+	ErrUploadLocked           = errors.New("upload is being written to")
+	ErrInvalidUploadToken     = errors.New("invalid upload token")
+	ErrUploadTokenExpired     = errors.New("upload token expired")
+	ErrUploadTokenUsed        = errors.New("upload token was already used")
+	ErrFormatNotAllowed       = errors.New("image format is not allowed by the upload token")
+	ErrAccessDenied           = errors.New("access to the image is denied")
+	ErrStorageQuotaExceeded   = errors.New("storage quota exceeded")
+	ErrObjectQuotaExceeded    = errors.New("object quota exceeded")
+)
+
+const (
+	// lqipSize is the longest side of base64 encoded previews
+	lqipSize = 16
+	// blurHashSize is the longest side images are shrunk to before hashing, the
+	// hash only keeps a handful of components so more pixels add nothing
+	blurHashSize       = 32
+	blurHashComponentX = 4
+	blurHashComponentY = 3
+	// thumbHashSize is the largest image a ThumbHash can be computed from
+	thumbHashSize = 100
+
+	perceptualHashKey = "phash"
+	metadataKey       = "metadata"
+	eagerStatusKey    = "eager"
+	visibilityKey     = "visibility"
+
+	// remoteUploadConcurrency bounds the remote urls of a batch fetched at once
+	remoteUploadConcurrency = 4
+	// batchUploadConcurrency bounds the files of a batch processed at once
+	batchUploadConcurrency = 4
+
+	// webhookAttempts keeps a delivery retried for about twenty minutes, riding
+	// out receiver restarts
+	webhookAttempts = 9
+
+	// srcsetSignatureExpiry is how long the variant urls signed for a principal
+	// are valid, long enough for a page to load its images
+	srcsetSignatureExpiry = time.Hour
+)
+
+func (i ImageService) Upload(ctx context.Context, imageByte []byte, tenantOpts domain.TenantOpts) (string, error) {
+	return i.storeParentImage(ctx, "", imageByte, tenantOpts)
+}
+
+// reserveQuota checks that an image of size bytes fits in the quota of the
+// tenant, evicting derivatives to make room when the quota leaves them out.
+// The usage is the running count of the storage. Uploads running at once are
+// checked against the same usage, together they may overshoot the quota by the
+// images in flight.
+func (i ImageService) reserveQuota(quota *domain.QuotaConfig, size int64, tenantOpts domain.TenantOpts) error {
+	if quota == nil {
+		return nil
+	}
+	usage, err := i.storageService.Usage(tenantOpts)
+	if err != nil {
+		return fmt.Errorf("internal error: %v", err)
+	}
+	err = quota.Check(usage, size)
+	switch {
+	case errors.Is(err, domain.ErrStorageQuotaExceeded):
+		return ErrStorageQuotaExceeded
+	case errors.Is(err, domain.ErrObjectQuotaExceeded):
+		return ErrObjectQuotaExceeded
+	}
+	return i.evictDerivatives(quota.Overflow(usage, size), tenantOpts)
+}
+
+// reserveDerivativeQuota reports whether a child image of size bytes fits in
+// the quota of the tenant, evicting other derivatives to make room when the
+// quota leaves them out. A child image past the quota is served uncached.
+func (i ImageService) reserveDerivativeQuota(quota *domain.QuotaConfig, size int64, tenantOpts domain.TenantOpts) (bool, error) {
+	if quota == nil {
+		return true, nil
+	}
+	usage, err := i.storageService.Usage(tenantOpts)
+	if err != nil {
+		return false, fmt.Errorf("internal error: %v", err)
+	}
+	if quota.CheckDerivative(usage, size) != nil {
+		return false, nil
+	}
+	return true, i.evictDerivatives(quota.Overflow(usage, size), tenantOpts)
+}
+
+// evictDerivatives deletes child images of the tenant until overflow bytes were
+// freed. Attachments are kept, child images are rendered again on request.
+func (i ImageService) evictDerivatives(overflow int64, tenantOpts domain.TenantOpts) error {
+	if overflow <= 0 {
+		return nil
+	}
+	names, err := i.storageService.ListParentImages(tenantOpts)
+	if err != nil {
+		return fmt.Errorf("internal error: %v", err)
+	}
+	for _, name := range names {
+		deleted, err := i.storageService.DeleteChildImages(name, tenantOpts)
+		if err != nil && !errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return fmt.Errorf("internal error: %v", err)
+		}
+		overflow -= deleted.Bytes
+		if overflow <= 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// GetUsage returns the storage used by the tenant along with its quota.
+func (i ImageService) GetUsage(ctx context.Context, tenantOpts domain.TenantOpts) (domain.UsageSnapshot, error) {
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(tenantOpts)
+	if err != nil {
+		return domain.UsageSnapshot{}, errors.New("internal error")
+	}
+	usage, err := i.storageService.Usage(tenantOpts)
+	if err != nil {
+		return domain.UsageSnapshot{}, fmt.Errorf("internal error: %v", err)
+	}
+	return domain.UsageSnapshot{
+		TenantOpts: tenantOpts,
+		TakenAt:    time.Now().UTC(),
+		Usage:      usage,
+		Quota:      tenantConfig.Quota,
+	}, nil
+}
+
+func (i ImageService) SnapshotUsage(ctx context.Context) ([]domain.UsageSnapshot, error) {
+	tenants, err := i.storageService.ListTenants()
+	if err != nil {
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	snapshots := make([]domain.UsageSnapshot, 0, len(tenants))
+	for _, tenantOpts := range tenants {
+		// billing counts the storage again rather than trust the running count
+		if _, err = i.storageService.RecountUsage(tenantOpts); err != nil {
+			return nil, fmt.Errorf("internal error: %v", err)
+		}
+		snapshot, err := i.GetUsage(ctx, tenantOpts)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// storeParentImage stores a new parent image along with its perceptual hash,
+// then notifies the tenant's webhooks and queues its eager transformations. An
+// empty name lets the storage name the image, originals pulled from the origin
+// keep theirs. Either way the image is held to the quota of the tenant.
+func (i ImageService) storeParentImage(ctx context.Context, name string, imageByte []byte, tenantOpts domain.TenantOpts) (string, error) {
+	format, err := i.processorService.GetFormat(imageByte)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrUnsupportedImageFormat) {
+			return "", ErrUnsupportedImageFormat
+		}
+		return "", fmt.Errorf("internal error: %v", err)
+	}
+	if format == domain.ImageType_SVG {
+		imageByte, err = domain.SanitizeSvg(imageByte)
+		if err != nil {
+			return "", ErrUnsupportedImageFormat
+		}
+	}
+
+	hash, err := i.processorService.PerceptualHash(imageByte)
+	if err != nil {
+		return "", fmt.Errorf("internal error: %v", err)
+	}
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(tenantOpts)
+	if err != nil {
+		return "", errors.New("internal error")
+	}
+	if err = i.reserveQuota(tenantConfig.Quota, int64(len(imageByte)), tenantOpts); err != nil {
+		return "", err
+	}
+
+	imgId := name
+	if imgId == "" {
+		imgId, err = i.storageService.StoreParentImage(imageByte, format, tenantOpts)
+	} else {
+		err = i.storageService.StoreNamedParentImage(imgId, imageByte, format, tenantOpts)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = i.storageService.StoreParentAttachment(imgId, perceptualHashKey, []byte(hash.String()), tenantOpts)
+	if err != nil {
+		return "", err
+	}
+	i.publish(tenantConfig, domain.Event{Type: domain.EventType_IMAGE_UPLOADED, TenantOpts: tenantOpts, ImageName: imgId})
+	if len(tenantConfig.Eager) > 0 {
+		status := domain.EagerStatus{State: domain.JobState_PENDING, Total: len(tenantConfig.Eager)}
+		if err = i.storeEagerStatus(imgId, status, tenantOpts); err != nil {
+			return "", err
+		}
+		_, err = i.jobQueue.Enqueue(domain.Job{
+			Type:       domain.JobType_EAGER,
+			TenantOpts: tenantOpts,
+			Images:     []string{imgId},
+			Eager:      tenantConfig.Eager,
+		})
+		if err != nil {
+			return "", fmt.Errorf("internal error: %v", err)
+		}
+	}
+	return imgId, nil
+}
+
+// getParentImage returns the parent image, pulling it from the tenant's origin
+// when it is not stored yet. Concurrent first requests may pull it more than
+// once, which only costs the origin a request.
+func (i ImageService) getParentImage(ctx context.Context, name string, tenantOpts domain.TenantOpts) ([]byte, error) {
+	parentImage, err := i.storageService.GetParentImage(name, tenantOpts)
+	if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return parentImage, err
+	}
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(tenantOpts)
+	if err != nil {
+		return nil, errors.New("internal error")
+	}
+	if tenantConfig.Origin == nil || tenantConfig.Origin.BaseUrl == "" {
+		return nil, appsvc.ErrNoMatchingFile
+	}
+	url, err := tenantConfig.Origin.ImageUrl(name)
+	if err != nil {
+		return nil, appsvc.ErrNoMatchingFile
+	}
+
+	originImage, err := i.remoteFetchService.Fetch(ctx, url)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrRemoteNotFound) || errors.Is(err, appsvc.ErrRemoteNotImage) {
+			return nil, appsvc.ErrNoMatchingFile
+		}
+		return nil, fmt.Errorf("error pulling from origin: %w", err)
+	}
+	if _, err = i.storeParentImage(ctx, name, originImage, tenantOpts); err != nil {
+		if errors.Is(err, ErrUnsupportedImageFormat) {
+			return nil, appsvc.ErrNoMatchingFile
+		}
+		return nil, err
+	}
+	// svg originals are stored sanitized
+	return i.storageService.GetParentImage(name, tenantOpts)
+}
+
+func (i ImageService) UploadFromUrl(ctx context.Context, url string, tenantOpts domain.TenantOpts) (string, error) {
+	image, err := i.remoteFetchService.Fetch(ctx, url)
+	if err != nil {
+		switch {
+		case errors.Is(err, appsvc.ErrInvalidRemoteUrl):
+			return "", ErrInvalidRemoteUrl
+		case errors.Is(err, appsvc.ErrForbiddenRemoteAddress):
+			return "", ErrForbiddenRemoteAddress
+		case errors.Is(err, appsvc.ErrRemoteTooLarge):
+			return "", ErrRemoteTooLarge
+		case errors.Is(err, appsvc.ErrRemoteNotImage):
+			return "", ErrUnsupportedImageFormat
+		default:
+			return "", fmt.Errorf("%w: %v", ErrRemoteFetchFailed, err)
+		}
+	}
+	return i.Upload(ctx, image, tenantOpts)
+}
+
+func (i ImageService) UploadFromUrls(ctx context.Context, urls []string, tenantOpts domain.TenantOpts) []domain.RemoteUpload {
+	results := make([]domain.RemoteUpload, len(urls))
+	slots := make(chan struct{}, remoteUploadConcurrency)
+	var wg sync.WaitGroup
+	for idx, url := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[idx] = domain.RemoteUpload{Url: url}
+			imgName, err := i.UploadFromUrl(ctx, url, tenantOpts)
+			if err != nil {
+				results[idx].Error = err.Error()
+				return
+			}
+			results[idx].ImageName = imgName
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (i ImageService) UploadBatch(ctx context.Context, files []domain.BatchFile, tenantOpts domain.TenantOpts) []domain.BatchUpload {
+	results := make([]domain.BatchUpload, len(files))
+	slots := make(chan struct{}, batchUploadConcurrency)
+	var wg sync.WaitGroup
+	for idx, file := range files {
+		results[idx] = domain.BatchUpload{File: file.Name}
+		if file.Err != nil {
+			results[idx].Error = &domain.BatchError{Code: domain.BatchErrorInvalidFile, Message: file.Err.Error()}
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// files are read one slot at a time, bounding the memory a batch takes
+			content, err := readBatchFile(file)
+			if err != nil {
+				results[idx].Error = &domain.BatchError{Code: domain.BatchErrorInvalidFile, Message: err.Error()}
+				return
+			}
+			imgName, err := i.Upload(ctx, content, tenantOpts)
+			if err != nil {
+				results[idx].Error = batchError(err)
+				return
+			}
+			results[idx].ImageName = imgName
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func readBatchFile(file domain.BatchFile) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, errors.New("failed to open the file")
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, errors.New("failed to read the file")
+	}
+	return content, nil
+}
+
+func (i ImageService) IssueUploadToken(ctx context.Context, token domain.UploadToken) (domain.UploadToken, string, error) {
+	token, signed, err := i.uploadTokenService.Issue(token)
+	if err != nil {
+		return domain.UploadToken{}, "", fmt.Errorf("internal error: %v", err)
+	}
+	return token, signed, nil
+}
+
+// UploadWithToken checks the image against the constraints of the token before
+// redeeming a single use token, so that a refused image does not use it up. The
+// token is redeemed before the upload so that concurrent requests can not both
+// use it, and released again if the upload fails, e.g. on a full quota.
+func (i ImageService) UploadWithToken(ctx context.Context, signedToken string, imageByte []byte) (string, domain.TenantOpts, error) {
+	token, err := i.uploadTokenService.Verify(signedToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrUploadTokenExpired) {
+			return "", domain.TenantOpts{}, ErrUploadTokenExpired
+		}
+		if errors.Is(err, domain.ErrInvalidUploadToken) {
+			return "", domain.TenantOpts{}, ErrInvalidUploadToken
+		}
+		return "", domain.TenantOpts{}, fmt.Errorf("internal error: %v", err)
+	}
+	if int64(len(imageByte)) > token.MaxBytes {
+		return "", token.TenantOpts, ErrUploadTooLarge
+	}
+	format, err := i.processorService.GetFormat(imageByte)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrUnsupportedImageFormat) {
+			return "", token.TenantOpts, ErrUnsupportedImageFormat
+		}
+		return "", token.TenantOpts, fmt.Errorf("internal error: %v", err)
+	}
+	if !token.AllowsFormat(format) {
+		return "", token.TenantOpts, ErrFormatNotAllowed
+	}
+	if token.SingleUse {
+		if err = i.uploadTokenService.Redeem(token); err != nil {
+			if errors.Is(err, appsvc.ErrUploadTokenUsed) {
+				return "", token.TenantOpts, ErrUploadTokenUsed
+			}
+			return "", token.TenantOpts, fmt.Errorf("internal error: %v", err)
+		}
+	}
+	imgName, err := i.Upload(ctx, imageByte, token.TenantOpts)
+	if err != nil && token.SingleUse {
+		if releaseErr := i.uploadTokenService.Release(token); releaseErr != nil {
+			return "", token.TenantOpts, errors.Join(err, fmt.Errorf("internal error: %v", releaseErr))
+		}
+	}
+	return imgName, token.TenantOpts, err
+}
+
+func (i ImageService) CreateUpload(ctx context.Context, length int64, metadata map[string]string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	upload, err := i.uploadStore.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: length, Metadata: metadata})
+	if err != nil {
+		if errors.Is(err, appsvc.ErrUploadTooLarge) {
+			return domain.ResumableUpload{}, ErrUploadTooLarge
+		}
+		return domain.ResumableUpload{}, fmt.Errorf("internal error: %v", err)
+	}
+	return upload, nil
+}
+
+// GetUpload returns the upload if it belongs to the tenant, uploads of other
+// tenants are reported as missing.
+func (i ImageService) GetUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	upload, err := i.uploadStore.Get(id)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrUploadNotFound) {
+			return domain.ResumableUpload{}, ErrUploadNotFound
+		}
+		return domain.ResumableUpload{}, fmt.Errorf("internal error: %v", err)
+	}
+	if upload.TenantOpts != tenantOpts {
+		return domain.ResumableUpload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// AppendUpload uploads the image once the last chunk arrives. Should that fail
+// the data is kept, and an empty chunk at the final offset tries again. Until
+// the upload is processed such retries are refused with ErrUploadLocked.
+func (i ImageService) AppendUpload(ctx context.Context, id string, offset int64, chunk io.Reader, tenantOpts domain.TenantOpts) (domain.ResumableUpload, error) {
+	upload, err := i.GetUpload(ctx, id, tenantOpts)
+	if err != nil {
+		return domain.ResumableUpload{}, err
+	}
+	if upload.ImageName != "" {
+		if offset != upload.Offset {
+			return upload, ErrUploadOffsetMismatch
+		}
+		return upload, nil
+	}
+
+	upload, err = i.uploadStore.Append(id, offset, chunk)
+	if err != nil {
+		switch {
+		case errors.Is(err, appsvc.ErrUploadNotFound):
+			return domain.ResumableUpload{}, ErrUploadNotFound
+		case errors.Is(err, appsvc.ErrUploadOffsetMismatch):
+			return upload, ErrUploadOffsetMismatch
+		case errors.Is(err, appsvc.ErrUploadExceedsLength):
+			return upload, ErrUploadExceedsLength
+		case errors.Is(err, appsvc.ErrUploadLocked):
+			return domain.ResumableUpload{}, ErrUploadLocked
+		default:
+			return upload, fmt.Errorf("internal error: %v", err)
+		}
+	}
+	if !upload.Received() {
+		return upload, nil
+	}
+	// the store keeps the received upload locked until it is completed, a
+	// retried last chunk arriving meanwhile is refused instead of uploading the
+	// image a second time
+	defer i.uploadStore.Release(id)
+
+	image, err := i.uploadStore.Read(id)
+	if err != nil {
+		return upload, fmt.Errorf("internal error: %v", err)
+	}
+	imgName, err := i.Upload(ctx, image, tenantOpts)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedImageFormat) {
+			// sending the same bytes again can not help
+			_ = i.uploadStore.Delete(id)
+		}
+		return upload, err
+	}
+	upload, err = i.uploadStore.Complete(id, imgName)
+	if err != nil {
+		return upload, fmt.Errorf("internal error: %v", err)
+	}
+	return upload, nil
+}
+
+func (i ImageService) TerminateUpload(ctx context.Context, id string, tenantOpts domain.TenantOpts) error {
+	if _, err := i.GetUpload(ctx, id, tenantOpts); err != nil {
+		return err
+	}
+	if err := i.uploadStore.Delete(id); err != nil {
+		if errors.Is(err, appsvc.ErrUploadNotFound) {
+			return ErrUploadNotFound
+		}
+		return fmt.Errorf("internal error: %v", err)
+	}
+	return nil
+}
+
+func (i ImageService) Reprocess(ctx context.Context, names []string, tenantOpts domain.TenantOpts) (domain.Job, error) {
+	job, err := i.jobQueue.Enqueue(domain.Job{
+		Type:       domain.JobType_REPROCESS,
+		TenantOpts: tenantOpts,
+		Images:     names,
+	})
+	if err != nil {
+		return domain.Job{}, fmt.Errorf("internal error: %v", err)
+	}
+	return job, nil
+}
+
+func (i ImageService) Purge(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.Job, error) {
+	job, err := i.jobQueue.Enqueue(domain.Job{
+		Type:       domain.JobType_PURGE,
+		TenantOpts: tenantOpts,
+		Images:     []string{name},
+	})
+	if err != nil {
+		return domain.Job{}, fmt.Errorf("internal error: %v", err)
+	}
+	return job, nil
+}
+
+// GetJob returns the job if it belongs to the tenant, jobs of other tenants are
+// reported as missing.
+func (i ImageService) GetJob(ctx context.Context, id string, tenantOpts domain.TenantOpts) (domain.Job, error) {
+	job, err := i.jobQueue.GetJob(id)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrJobNotFound) {
+			return domain.Job{}, ErrJobNotFound
+		}
+		return domain.Job{}, fmt.Errorf("internal error: %v", err)
+	}
+	if job.TenantOpts != tenantOpts {
+		return domain.Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+func (i ImageService) HandleJob(ctx context.Context, job domain.Job) error {
+	switch job.Type {
+	case domain.JobType_EAGER:
+		var errs []error
+		for _, name := range job.Images {
+			errs = append(errs, i.renderEager(ctx, name, job.TenantOpts, job.Eager))
+		}
+		return errors.Join(errs...)
+	case domain.JobType_PRERENDER:
+		for _, name := range job.Images {
+			i.prerender(ctx, name, job.TenantOpts, job.Eager)
+		}
+		return nil
+	case domain.JobType_REPROCESS:
+		return i.reprocess(ctx, job.Images, job.TenantOpts)
+	case domain.JobType_PURGE:
+		tenantConfig, err := i.tenantConfigService.GetTenantConfig(job.TenantOpts)
+		if err != nil {
+			return errors.New("internal error")
+		}
+		var errs []error
+		for _, name := range job.Images {
+			err := i.storageService.DeleteParentImage(name, job.TenantOpts)
+			if err != nil {
+				// the image might be gone after an attempt which failed later on
+				if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			i.publish(tenantConfig, domain.Event{Type: domain.EventType_IMAGE_DELETED, TenantOpts: job.TenantOpts, ImageName: name})
+		}
+		return errors.Join(errs...)
+	case domain.JobType_WEBHOOK:
+		return i.deliver(ctx, job)
+	default:
+		return fmt.Errorf("unsupported job type: %v", job.Type)
+	}
+}
+
+// publish queues the delivery of the event to every webhook of the tenant which
+// is subscribed to it. Notifications never fail the operation they report on,
+// so queueing errors are dropped.
+func (i ImageService) publish(tenantConfig domain.TenantConfig, event domain.Event) {
+	event.Id = appsvc.GenerateEventId()
+	event.CreatedAt = time.Now()
+	for _, webhook := range tenantConfig.Webhooks {
+		if !webhook.Subscribed(event.Type) {
+			continue
+		}
+		_, _ = i.jobQueue.Enqueue(domain.Job{
+			Type:        domain.JobType_WEBHOOK,
+			TenantOpts:  event.TenantOpts,
+			Event:       &event,
+			WebhookUrl:  webhook.Url,
+			MaxAttempts: webhookAttempts,
+		})
+	}
+}
+
+// deliver delivers the event of a webhook job with the current configuration of
+// the webhook, so that a rotated secret applies to pending deliveries too.
+func (i ImageService) deliver(ctx context.Context, job domain.Job) error {
+	if job.Event == nil {
+		return errors.New("webhook job without event")
+	}
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(job.TenantOpts)
+	if err != nil {
+		return errors.New("internal error")
+	}
+	for _, webhook := range tenantConfig.Webhooks {
+		if webhook.Url == job.WebhookUrl {
+			return i.webhookService.Deliver(ctx, webhook, *job.Event, job.Attempts)
+		}
+	}
+	// the webhook was removed since the event was published
+	return nil
+}
+
+func (i ImageService) GetWebhookDeliveries(ctx context.Context, limit int, tenantOpts domain.TenantOpts) ([]domain.WebhookDelivery, error) {
+	deliveries, err := i.webhookService.Deliveries(tenantOpts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("internal error: %v", err)
+	}
+	return deliveries, nil
+}
+
+// reprocess drops the child images and attachments of the images, then builds
+// the perceptual hash and the eager transformations of the current tenant
+// configuration again. Everything else is built again when it is requested.
+func (i ImageService) reprocess(ctx context.Context, names []string, tenantOpts domain.TenantOpts) error {
+	if len(names) == 0 {
+		var err error
+		names, err = i.storageService.ListParentImages(tenantOpts)
+		if err != nil {
+			return err
+		}
+	}
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(tenantOpts)
+	if err != nil {
+		return errors.New("internal error")
+	}
+
+	var errs []error
+	for _, name := range names {
+		err := i.storageService.DeleteDerivedImages(name, tenantOpts)
+		if err != nil {
+			if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if _, err = i.perceptualHash(ctx, name, tenantOpts); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(tenantConfig.Eager) > 0 {
+			errs = append(errs, i.renderEager(ctx, name, tenantOpts, tenantConfig.Eager))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetEagerStatus returns the progress of the eager transformations started by
+// the upload of the image.
+func (i ImageService) GetEagerStatus(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.EagerStatus, error) {
+	content, err := i.storageService.GetParentAttachment(name, eagerStatusKey, tenantOpts)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return domain.EagerStatus{}, ErrNoEagerStatus
+		}
+		return domain.EagerStatus{}, errors.New("internal error")
+	}
+	var status domain.EagerStatus
+	if err = json.Unmarshal(content, &status); err != nil {
+		return domain.EagerStatus{}, errors.New("internal error")
+	}
+	return status, nil
+}
+
+// renderEager renders the eager transformations of an uploaded image, recording
+// its progress as it goes.
+func (i ImageService) renderEager(ctx context.Context, name string, tenantOpts domain.TenantOpts, transformations []domain.EagerTransformation) error {
+	status := domain.EagerStatus{State: domain.JobState_RUNNING, Total: len(transformations)}
+	_ = i.storeEagerStatus(name, status, tenantOpts)
+	for _, transformation := range transformations {
+		_, _, err := i.getCompressedImage(ctx, eagerImageOpts(name, tenantOpts, transformation))
+		if err != nil {
+			status.Failed++
+			status.Errors = append(status.Errors, err.Error())
+		} else {
+			status.Completed++
+		}
+		_ = i.storeEagerStatus(name, status, tenantOpts)
+	}
+	status.State = domain.JobState_SUCCEEDED
+	if status.Failed > 0 {
+		status.State = domain.JobState_FAILED
+	}
+	if err := i.storeEagerStatus(name, status, tenantOpts); err != nil {
+		return err
+	}
+	if status.Failed > 0 {
+		return fmt.Errorf("%d of %d eager transformations failed", status.Failed, status.Total)
+	}
+	return nil
+}
+
+func (i ImageService) storeEagerStatus(name string, status domain.EagerStatus, tenantOpts domain.TenantOpts) error {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return i.storageService.StoreParentAttachment(name, eagerStatusKey, content, tenantOpts)
+}
+
+func (i ImageService) GetImage(ctx context.Context, opts GetImageOpts) ([]byte, error) {
+	image, _, err := i.GetCompressedImage(ctx, opts)
+	return image, err
+}
+
+// GetCompressedImage checks the access of the request before anything, cached
+// child images included, is served.
+func (i ImageService) GetCompressedImage(ctx context.Context, opts GetImageOpts) ([]byte, domain.Compression, error) {
+	if err := i.authorize(opts.Name, opts.Access, opts.TenantOpts); err != nil {
+		return nil, domain.Compression{}, err
+	}
+	return i.getCompressedImage(ctx, opts)
+}
+
+// getCompressedImage serves the image without checking access, for the
+// variants rendered on behalf of the tenant.
+func (i ImageService) getCompressedImage(ctx context.Context, opts GetImageOpts) ([]byte, domain.Compression, error) {
+	var parentImage []byte
+	var parentImageSpec domain.ImageSpec
+	var targetWidth, targetHeight int
+	var targetImageFormat domain.ImageType
+	// check whether parentImage needs to be fetched at first or not
+	if parentImageNeedsToBeFetched(opts) {
+		var err error
+		parentImage, parentImageSpec, err = i.fetchParentImage(ctx, opts)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+	// determineDimensions
+	targetWidth, targetHeight = determineDimensions(opts, parentImageSpec.Width, parentImageSpec.Height)
+	// determineImageFormat
+	if opts.Type == domain.ImageType_AUTO {
+		targetImageFormat = parentImageSpec.Format.OutputFallback()
+	} else {
+		targetImageFormat = opts.Type
+	}
+	// determine extra operations
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(opts.TenantOpts)
+	if err != nil {
+		return nil, domain.Compression{}, errors.New("internal error")
+	}
+	var ops []string
+	if opts.Page != nil {
+		ops = append(ops, fmt.Sprintf("page:%d", *opts.Page))
+	}
+	if opts.Density != nil {
+		ops = append(ops, fmt.Sprintf("density:%d", *opts.Density))
+	}
+	if opts.Frame != nil {
+		ops = append(ops, fmt.Sprintf("frame:%d", *opts.Frame))
+	}
+	shape := shapeForFormat(opts.Shape, targetImageFormat)
+	if shape != nil {
+		ops = append(ops, shape.String())
+	}
+	if opts.Text != nil {
+		ops = append(ops, opts.Text.String())
+	}
+	watermark := watermarkToApply(opts, tenantConfig, targetWidth)
+	if watermark != nil {
+		ops = append(ops, watermark.String())
+	}
+	if opts.MaxBytes != nil {
+		ops = append(ops, fmt.Sprintf("maxBytes:%d", *opts.MaxBytes))
+	}
+	targetSpec := domain.ImageSpec{
+		Width:   targetWidth,
+		Height:  targetHeight,
+		Format:  targetImageFormat,
+		Variant: variantKey(ops...),
+	}
+	// fetch childImage
+	childImage, err := i.storageService.GetChildImage(opts.Name, targetSpec, opts.TenantOpts)
+	if err == nil {
+		if opts.MaxBytes == nil {
+			return childImage, domain.Compression{}, nil
+		}
+		return childImage, i.cachedCompression(opts.Name, targetSpec, opts.TenantOpts), nil
+	}
+	if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return nil, domain.Compression{}, errors.New("internal error")
+	}
+	// fetch parentImage to buildImageFrom
+	if parentImage == nil {
+		parentImage, parentImageSpec, err = i.fetchParentImage(ctx, opts)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+	// buildImage then return
+	if opts.Frame != nil {
+		parentImage, err = i.processorService.ExtractFrame(parentImage, *opts.Frame)
+		if err != nil {
+			if errors.Is(err, appsvc.ErrFrameOutOfRange) {
+				return nil, domain.Compression{}, ErrInvalidFrame
+			}
+			return nil, domain.Compression{}, err
+		}
+	}
+	scale := calculateScale(parentImageSpec.Width, parentImageSpec.Height, &targetWidth, &targetHeight)
+	resizedImage, err := i.processorService.Resize(parentImage, scale)
+	if err != nil {
+		return nil, domain.Compression{}, err
+	}
+	resizedImageSpec, err := i.processorService.GetSpec(resizedImage)
+	if err != nil {
+		return nil, domain.Compression{}, err
+	}
+	var centeredImage []byte
+	switch {
+	case resizedImageSpec.Width == targetWidth && resizedImageSpec.Height == targetHeight:
+		centeredImage = resizedImage
+	case resizedImageSpec.Width == targetWidth:
+		remainder := resizedImageSpec.Height - targetHeight
+		// TODO: check if remainder < 0
+		centeredImage, err = i.processorService.Crop(resizedImage, 0, remainder/2, resizedImageSpec.Width, targetHeight)
+	case resizedImageSpec.Height == targetHeight:
+		remainder := resizedImageSpec.Width - targetWidth
+		// TODO: check if remainder < 0
+		centeredImage, err = i.processorService.Crop(resizedImage, remainder/2, 0, targetWidth, resizedImageSpec.Height)
+	}
+	if err != nil {
+		return nil, domain.Compression{}, err
+	}
+	// apply extra operations
+	if shape != nil {
+		centeredImage, err = i.processorService.Shape(centeredImage, *shape)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+	if opts.Text != nil {
+		centeredImage, err = i.processorService.DrawText(centeredImage, *opts.Text)
+		if err != nil {
+			if errors.Is(err, appsvc.ErrUnsupportedFont) {
+				return nil, domain.Compression{}, ErrUnsupportedFont
+			}
+			return nil, domain.Compression{}, err
+		}
+	}
+	if watermark != nil {
+		logo, err := i.getParentImage(ctx, watermark.ImageName, opts.TenantOpts)
+		if err != nil {
+			return nil, domain.Compression{}, fmt.Errorf("error fetching watermark image: %v", err)
+		}
+		centeredImage, err = i.processorService.Overlay(centeredImage, logo, watermark.OverlayOpts())
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+
+	var targetImage []byte
+	var compression domain.Compression
+	if opts.MaxBytes != nil {
+		targetImage, compression, err = i.processorService.Compress(centeredImage, targetImageFormat, *opts.MaxBytes)
+		if err != nil {
+			if errors.Is(err, appsvc.ErrBudgetUnreachable) {
+				return nil, domain.Compression{}, ErrBudgetUnreachable
+			}
+			return nil, domain.Compression{}, err
+		}
+	} else {
+		targetImage, err = i.processorService.Export(centeredImage, targetImageFormat)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+	// cache image before return, unless it does not fit in the quota
+	cached, err := i.reserveDerivativeQuota(tenantConfig.Quota, int64(len(targetImage)), opts.TenantOpts)
+	if err != nil {
+		return nil, domain.Compression{}, err
+	}
+	if !cached {
+		return targetImage, compression, nil
+	}
+	err = i.storageService.StoreChildImage(targetImage, opts.Name, targetSpec, opts.TenantOpts)
+	if err != nil {
+		return nil, domain.Compression{}, err
+	}
+	i.publish(tenantConfig, domain.Event{
+		Type:       domain.EventType_VARIANT_GENERATED,
+		TenantOpts: opts.TenantOpts,
+		ImageName:  opts.Name,
+		Variant: &domain.EventVariant{
+			Width:   targetSpec.Width,
+			Height:  targetSpec.Height,
+			Format:  targetSpec.Format,
+			Variant: targetSpec.Variant,
+		},
+	})
+	if opts.MaxBytes != nil {
+		content, err := json.Marshal(compression)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+		err = i.storageService.StoreParentAttachment(opts.Name, compressionKey(targetSpec), content, opts.TenantOpts)
+		if err != nil {
+			return nil, domain.Compression{}, err
+		}
+	}
+
+	return targetImage, compression, nil
 }
 
-type ImageService struct {
-	processorService appsvc.ImageProcessingServiceInterface
-	storageService   appsvc.ImageStorageServiceInterface
+// cachedCompression returns the compression report stored along with a child
+// image; a missing report only costs the caller the quality header.
+func (i ImageService) cachedCompression(name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) domain.Compression {
+	var compression domain.Compression
+	content, err := i.storageService.GetParentAttachment(name, compressionKey(spec), tenantOpts)
+	if err == nil {
+		_ = json.Unmarshal(content, &compression)
+	}
+	return compression
 }
 
-var (
-	ErrNotFound               = errors.New("no primary image found")
-	ErrUnsupportedImageFormat = errors.New("unsupported image format")
-)
+// GetOriginalImage returns the parent image as it was stored along with its format.
+func (i ImageService) GetOriginalImage(ctx context.Context, name string, access domain.Access, tenantOpts domain.TenantOpts) ([]byte, domain.ImageType, error) {
+	if err := i.authorize(name, access, tenantOpts); err != nil {
+		return nil, -1, err
+	}
+	parentImage, err := i.getParentImage(ctx, name, tenantOpts)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return nil, -1, ErrNotFound
+		}
+		if errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrObjectQuotaExceeded) {
+			return nil, -1, err
+		}
+		return nil, -1, errors.New("internal error")
+	}
+	format, err := i.processorService.GetFormat(parentImage)
+	if err != nil {
+		return nil, -1, errors.New("internal error")
+	}
+	return parentImage, format, nil
+}
 
-func (i ImageService) Upload(ctx context.Context, imageByte []byte, tenantOpts domain.TenantOpts) (string, error) {
-	format, err := i.processorService.GetFormat(imageByte)
+// GetVisibility returns the visibility set for the image, or else the default
+// visibility of the tenant.
+func (i ImageService) GetVisibility(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.Visibility, error) {
+	stored, err := i.storageService.GetParentSetting(name, visibilityKey, tenantOpts)
+	if err == nil {
+		visibility, err := domain.VisibilityFromString(string(stored))
+		if err != nil {
+			return -1, fmt.Errorf("internal error: %v", err)
+		}
+		return visibility, nil
+	}
+	if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return -1, fmt.Errorf("internal error: %v", err)
+	}
+	tenantConfig, err := i.tenantConfigService.GetTenantConfig(tenantOpts)
 	if err != nil {
-		if errors.Is(err, appsvc.ErrUnsupportedImageFormat) {
-			return "", ErrUnsupportedImageFormat
+		return -1, errors.New("internal error")
+	}
+	return tenantConfig.Visibility, nil
+}
+
+func (i ImageService) SetVisibility(ctx context.Context, name string, visibility domain.Visibility, tenantOpts domain.TenantOpts) error {
+	if _, err := i.storageService.GetParentImage(name, tenantOpts); err != nil {
+		if errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return ErrNotFound
 		}
-		return "", fmt.Errorf("internal error: %v", err)
+		return fmt.Errorf("internal error: %v", err)
 	}
+	return i.storageService.StoreParentSetting(name, visibilityKey, []byte(visibility.String()), tenantOpts)
+}
+
+func (i ImageService) SignImageUrl(ctx context.Context, name string, expiresAt time.Time, tenantOpts domain.TenantOpts) string {
+	return i.urlSigner.Sign(name, tenantOpts, expiresAt)
+}
 
-	imgId, err := i.storageService.StoreParentImage(imageByte, format, tenantOpts)
+// authorize checks that the access presented is enough for the visibility of
+// the image. Signed urls grant access to images of any visibility.
+func (i ImageService) authorize(name string, access domain.Access, tenantOpts domain.TenantOpts) error {
+	visibility, err := i.GetVisibility(context.Background(), name, tenantOpts)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return imgId, nil
+	switch visibility {
+	case domain.Visibility_PUBLIC:
+		return nil
+	case domain.Visibility_PRIVATE:
+		if access.Principal != nil && access.Principal.TenantOpts == tenantOpts {
+			return nil
+		}
+	}
+	if access.Signature != "" && i.urlSigner.Verify(name, tenantOpts, access.Expires, access.Signature) {
+		return nil
+	}
+	return ErrAccessDenied
 }
 
-func (i ImageService) GetImage(ctx context.Context, opts GetImageOpts) ([]byte, error) {
+// GetPlaceholders returns the requested low quality placeholders of the parent
+// image. Placeholders are computed once and cached alongside the parent image.
+func (i ImageService) GetPlaceholders(ctx context.Context, name string, types []domain.PlaceholderType, access domain.Access, tenantOpts domain.TenantOpts) (map[domain.PlaceholderType]string, error) {
+	if err := i.authorize(name, access, tenantOpts); err != nil {
+		return nil, err
+	}
+	placeholders := make(map[domain.PlaceholderType]string, len(types))
 	var parentImage []byte
 	var parentImageSpec domain.ImageSpec
-	var targetWidth, targetHeight int
-	var targetImageFormat domain.ImageType
-	// check whether parentImage needs to be fetched at first or not
-	if parentImageNeedsToBeFetched(opts) {
-		var err error
-		parentImage, err = i.storageService.GetParentImage(opts.Name, opts.TenantOpts)
-		if err != nil {
-			if errors.Is(err, appsvc.ErrNoMatchingFile) {
-				return nil, ErrNotFound
-			}
+	for _, placeholderType := range types {
+		key := "placeholder-" + placeholderType.String()
+		cached, err := i.storageService.GetParentAttachment(name, key, tenantOpts)
+		if err == nil {
+			placeholders[placeholderType] = string(cached)
+			continue
+		}
+		if !errors.Is(err, appsvc.ErrNoMatchingFile) {
 			return nil, errors.New("internal error")
 		}
-		parentImageSpec, err = i.processorService.GetSpec(parentImage)
+		if parentImage == nil {
+			opts := NewServiceGetImageOpts().SetName(name).SetTenantOpts(tenantOpts)
+			parentImage, parentImageSpec, err = i.fetchParentImage(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		placeholder, err := i.computePlaceholder(parentImage, parentImageSpec, placeholderType)
 		if err != nil {
-			return nil, errors.New("internal error")
+			return nil, err
 		}
+		err = i.storageService.StoreParentAttachment(name, key, []byte(placeholder), tenantOpts)
+		if err != nil {
+			return nil, err
+		}
+		placeholders[placeholderType] = placeholder
 	}
-	// determineDimensions
-	targetWidth, targetHeight = determineDimensions(opts, parentImageSpec.Width, parentImageSpec.Height)
-	// determineImageFormat
-	if opts.Type == domain.ImageType_AUTO {
-		targetImageFormat = parentImageSpec.Format
-	} else {
-		targetImageFormat = opts.Type
+	return placeholders, nil
+}
+
+// GetColors returns the dominant color and a palette of at most count colors of
+// the parent image. The analysis is computed once per count and cached alongside
+// the parent image.
+func (i ImageService) GetColors(ctx context.Context, name string, count int, access domain.Access, tenantOpts domain.TenantOpts) (domain.ColorAnalysis, error) {
+	if err := i.authorize(name, access, tenantOpts); err != nil {
+		return domain.ColorAnalysis{}, err
 	}
-	// fetch childImage
-	childImage, err := i.storageService.GetChildImage(opts.Name, targetImageFormat, targetWidth, targetHeight, opts.TenantOpts)
+	key := fmt.Sprintf("colors-%d", count)
+	cached, err := i.storageService.GetParentAttachment(name, key, tenantOpts)
 	if err == nil {
-		return childImage, nil
+		var analysis domain.ColorAnalysis
+		if err = json.Unmarshal(cached, &analysis); err == nil {
+			return analysis, nil
+		}
+	} else if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return domain.ColorAnalysis{}, errors.New("internal error")
 	}
-	if !errors.Is(err, appsvc.ErrNoMatchingFile) {
-		return nil, errors.New("internal error")
+
+	opts := NewServiceGetImageOpts().SetName(name).SetTenantOpts(tenantOpts)
+	parentImage, _, err := i.fetchParentImage(ctx, opts)
+	if err != nil {
+		return domain.ColorAnalysis{}, err
 	}
-	// fetch parentImage to buildImageFrom
-	if parentImage == nil {
-		parentImage, err = i.storageService.GetParentImage(opts.Name, opts.TenantOpts)
+	analysis, err := i.processorService.AnalyzeColors(parentImage, count)
+	if err != nil {
+		if errors.Is(err, domain.ErrNoOpaquePixels) {
+			return domain.ColorAnalysis{}, ErrNoColors
+		}
+		return domain.ColorAnalysis{}, err
+	}
+	content, err := json.Marshal(analysis)
+	if err != nil {
+		return domain.ColorAnalysis{}, err
+	}
+	err = i.storageService.StoreParentAttachment(name, key, content, tenantOpts)
+	if err != nil {
+		return domain.ColorAnalysis{}, err
+	}
+	return analysis, nil
+}
+
+// GetSrcset plans the variants of a responsive image, one per requested width,
+// with the heights GetImage would serve them at. The variant urls carry no other
+// credentials than a signature: the one presented, or for non-public images
+// planned for a principal, one signed for them.
+func (i ImageService) GetSrcset(ctx context.Context, opts SrcsetOpts) (domain.Srcset, error) {
+	if err := i.authorize(opts.Name, opts.Access, opts.TenantOpts); err != nil {
+		return domain.Srcset{}, err
+	}
+	var srcset domain.Srcset
+	if opts.Access.Signature != "" {
+		srcset.Expires, srcset.Signature = opts.Access.Expires, opts.Access.Signature
+	} else {
+		visibility, err := i.GetVisibility(ctx, opts.Name, opts.TenantOpts)
 		if err != nil {
-			if errors.Is(err, appsvc.ErrNoMatchingFile) {
-				return nil, ErrNotFound
+			return domain.Srcset{}, err
+		}
+		if visibility != domain.Visibility_PUBLIC {
+			srcset.Expires = time.Now().Add(srcsetSignatureExpiry).Truncate(time.Second).UTC()
+			srcset.Signature = i.urlSigner.Sign(opts.Name, opts.TenantOpts, srcset.Expires)
+		}
+	}
+	getImageOpts := NewServiceGetImageOpts().SetName(opts.Name).SetTenantOpts(opts.TenantOpts)
+	if opts.Ar != nil {
+		getImageOpts = getImageOpts.SetAr(*opts.Ar)
+	}
+	_, parentImageSpec, err := i.fetchParentImage(ctx, getImageOpts)
+	if err != nil {
+		return domain.Srcset{}, err
+	}
+
+	srcset.Fallback = parentImageSpec.Format.OutputFallback()
+	for _, format := range []domain.ImageType{domain.ImageType_AVIF, domain.ImageType_WEBP} {
+		if format != srcset.Fallback {
+			srcset.Formats = append(srcset.Formats, format)
+		}
+	}
+	for _, width := range opts.Widths {
+		variantWidth, variantHeight := determineDimensions(getImageOpts.SetWidth(width),
+			parentImageSpec.Width, parentImageSpec.Height)
+		srcset.Variants = append(srcset.Variants, domain.ImageVariant{Width: variantWidth, Height: variantHeight})
+	}
+
+	// the rendering is left to the job workers, which bound how much of it runs
+	// at once, and to requests whose credentials were issued by the tenant
+	if opts.Prerender && (opts.Access.Principal != nil || opts.Access.Signature != "") {
+		var transformations []domain.EagerTransformation
+		for _, format := range append(slices.Clone(srcset.Formats), srcset.Fallback) {
+			for _, width := range opts.Widths {
+				transformations = append(transformations,
+					domain.EagerTransformation{Width: width, Ar: opts.Ar, Format: format})
 			}
-			return nil, errors.New("internal error")
 		}
-		parentImageSpec, err = i.processorService.GetSpec(parentImage)
-		if err != nil {
-			return nil, errors.New("internal error")
+		_, _ = i.jobQueue.Enqueue(domain.Job{
+			Type:       domain.JobType_PRERENDER,
+			TenantOpts: opts.TenantOpts,
+			Images:     []string{opts.Name},
+			Eager:      transformations,
+		})
+	}
+	return srcset, nil
+}
+
+// prerender renders the variants with the options the srcset urls request them
+// with so that they share the cache entries. Failures only cost the cache.
+func (i ImageService) prerender(ctx context.Context, name string, tenantOpts domain.TenantOpts, transformations []domain.EagerTransformation) {
+	for _, transformation := range transformations {
+		_, _, _ = i.getCompressedImage(ctx, eagerImageOpts(name, tenantOpts, transformation))
+	}
+}
+
+// GetMetadata returns the metadata of the parent image as it was uploaded. It is
+// read once and cached alongside the parent image.
+func (i ImageService) GetMetadata(ctx context.Context, name string, access domain.Access, tenantOpts domain.TenantOpts) (domain.ImageMetadata, error) {
+	if err := i.authorize(name, access, tenantOpts); err != nil {
+		return domain.ImageMetadata{}, err
+	}
+	cached, err := i.storageService.GetParentAttachment(name, metadataKey, tenantOpts)
+	if err == nil {
+		var metadata domain.ImageMetadata
+		if err = json.Unmarshal(cached, &metadata); err == nil {
+			return metadata, nil
 		}
+	} else if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return domain.ImageMetadata{}, errors.New("internal error")
 	}
-	// buildImage then return
-	scale := calculateScale(parentImageSpec.Width, parentImageSpec.Height, &targetWidth, &targetHeight)
-	resizedImage, err := i.processorService.Resize(parentImage, scale)
+
+	parentImage, err := i.getParentImage(ctx, name, tenantOpts)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return domain.ImageMetadata{}, ErrNotFound
+		}
+		if errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrObjectQuotaExceeded) {
+			return domain.ImageMetadata{}, err
+		}
+		return domain.ImageMetadata{}, errors.New("internal error")
 	}
-	resizedImageSpec, err := i.processorService.GetSpec(resizedImage)
+	metadata, err := i.processorService.GetMetadata(parentImage)
 	if err != nil {
-		return nil, err
+		return domain.ImageMetadata{}, err
 	}
-	var centeredImage []byte
-	switch {
-	case resizedImageSpec.Width == targetWidth && resizedImageSpec.Height == targetHeight:
-		centeredImage = resizedImage
-	case resizedImageSpec.Width == targetWidth:
-		remainder := resizedImageSpec.Height - targetHeight
-		// TODO: check if remainder < 0
-		centeredImage, err = i.processorService.Crop(resizedImage, 0, remainder/2, resizedImageSpec.Width, targetHeight)
-	case resizedImageSpec.Height == targetHeight:
-		remainder := resizedImageSpec.Width - targetWidth
-		// TODO: check if remainder < 0
-		centeredImage, err = i.processorService.Crop(resizedImage, remainder/2, 0, targetWidth, resizedImageSpec.Height)
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return domain.ImageMetadata{}, err
 	}
+	err = i.storageService.StoreParentAttachment(name, metadataKey, content, tenantOpts)
 	if err != nil {
-		return nil, err
+		return domain.ImageMetadata{}, err
 	}
+	return metadata, nil
+}
 
-	targetImage, err := i.processorService.Export(centeredImage, targetImageFormat)
+// FindSimilar returns the images of the tenant whose perceptual hash is at most
+// distance bits away from the one of the named image, closest first.
+func (i ImageService) FindSimilar(ctx context.Context, name string, distance int, tenantOpts domain.TenantOpts) ([]domain.SimilarImage, error) {
+	hash, err := i.perceptualHash(ctx, name, tenantOpts)
 	if err != nil {
 		return nil, err
 	}
-	// cache image before return
-	err = i.storageService.StoreChildImage(targetImage,
-		opts.Name,
-		domain.ImageSpec{
-			Width:  targetWidth,
-			Height: targetHeight,
-			Format: targetImageFormat,
-		},
-		opts.TenantOpts,
-	)
+	names, err := i.storageService.ListParentImages(tenantOpts)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("internal error")
+	}
+
+	similarImages := []domain.SimilarImage{}
+	for _, candidate := range names {
+		if candidate == name {
+			continue
+		}
+		candidateHash, err := i.perceptualHash(ctx, candidate, tenantOpts)
+		if err != nil {
+			// an unreadable image must not keep moderators from the others
+			continue
+		}
+		if d := hash.Distance(candidateHash); d <= distance {
+			similarImages = append(similarImages, domain.SimilarImage{Name: candidate, Distance: d})
+		}
+	}
+	sort.Slice(similarImages, func(a, b int) bool {
+		if similarImages[a].Distance != similarImages[b].Distance {
+			return similarImages[a].Distance < similarImages[b].Distance
+		}
+		return similarImages[a].Name < similarImages[b].Name
+	})
+	return similarImages, nil
+}
+
+// perceptualHash returns the perceptual hash stored at upload, computing and
+// storing it for images uploaded before hashes were.
+func (i ImageService) perceptualHash(ctx context.Context, name string, tenantOpts domain.TenantOpts) (domain.PerceptualHash, error) {
+	stored, err := i.storageService.GetParentAttachment(name, perceptualHashKey, tenantOpts)
+	if err == nil {
+		if hash, err := domain.ParsePerceptualHash(string(stored)); err == nil {
+			return hash, nil
+		}
+	} else if !errors.Is(err, appsvc.ErrNoMatchingFile) {
+		return 0, errors.New("internal error")
+	}
+
+	opts := NewServiceGetImageOpts().SetName(name).SetTenantOpts(tenantOpts)
+	parentImage, _, err := i.fetchParentImage(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := i.processorService.PerceptualHash(parentImage)
+	if err != nil {
+		return 0, err
+	}
+	err = i.storageService.StoreParentAttachment(name, perceptualHashKey, []byte(hash.String()), tenantOpts)
+	if err != nil {
+		return 0, err
+	}
+	return hash, nil
+}
+
+func (i ImageService) computePlaceholder(parentImage []byte, parentImageSpec domain.ImageSpec, placeholderType domain.PlaceholderType) (string, error) {
+	switch placeholderType {
+	case domain.PlaceholderType_LQIP:
+		thumbnail, err := i.thumbnail(parentImage, parentImageSpec, lqipSize)
+		if err != nil {
+			return "", err
+		}
+		preview, err := i.processorService.Export(thumbnail, domain.ImageType_WEBP)
+		if err != nil {
+			return "", err
+		}
+		return "data:image/webp;base64," + base64.StdEncoding.EncodeToString(preview), nil
+	case domain.PlaceholderType_BLURHASH:
+		thumbnail, err := i.thumbnail(parentImage, parentImageSpec, blurHashSize)
+		if err != nil {
+			return "", err
+		}
+		pixels, err := i.processorService.GetPixels(thumbnail)
+		if err != nil {
+			return "", err
+		}
+		return domain.EncodeBlurHash(pixels, blurHashComponentX, blurHashComponentY)
+	case domain.PlaceholderType_THUMBHASH:
+		thumbnail, err := i.thumbnail(parentImage, parentImageSpec, thumbHashSize)
+		if err != nil {
+			return "", err
+		}
+		pixels, err := i.processorService.GetPixels(thumbnail)
+		if err != nil {
+			return "", err
+		}
+		hash, err := domain.EncodeThumbHash(pixels)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(hash), nil
+	default:
+		return "", fmt.Errorf("unsupported placeholder type: %v", placeholderType)
+	}
+}
+
+// thumbnail shrinks the image so its longest side is at most size pixels.
+func (i ImageService) thumbnail(image []byte, spec domain.ImageSpec, size int) ([]byte, error) {
+	longestSide := max(spec.Width, spec.Height)
+	if longestSide <= size {
+		return image, nil
+	}
+	return i.processorService.Resize(image, float64(size)/float64(longestSide))
+}
+
+// fetchParentImage fetches the parent image along with its spec. Vector parents,
+// and multi-page parents when a page is requested, are rasterized first so the
+// spec reflects the requested page and density.
+func (i ImageService) fetchParentImage(ctx context.Context, opts GetImageOpts) ([]byte, domain.ImageSpec, error) {
+	parentImage, err := i.getParentImage(ctx, opts.Name, opts.TenantOpts)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrNoMatchingFile) {
+			return nil, domain.ImageSpec{}, ErrNotFound
+		}
+		if errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrObjectQuotaExceeded) {
+			return nil, domain.ImageSpec{}, err
+		}
+		return nil, domain.ImageSpec{}, errors.New("internal error")
+	}
+	parentImageSpec, err := i.processorService.GetSpec(parentImage)
+	if err != nil {
+		return nil, domain.ImageSpec{}, errors.New("internal error")
+	}
+	if !needsRasterization(opts, parentImageSpec.Format) {
+		return parentImage, parentImageSpec, nil
 	}
 
-	return targetImage, nil
+	page, density := 0, 0
+	if opts.Page != nil {
+		page = *opts.Page - 1
+	}
+	if opts.Density != nil {
+		density = *opts.Density
+	}
+	rasterizedImage, err := i.processorService.Rasterize(parentImage, page, density)
+	if err != nil {
+		if errors.Is(err, appsvc.ErrPageOutOfRange) {
+			return nil, domain.ImageSpec{}, ErrInvalidPage
+		}
+		return nil, domain.ImageSpec{}, err
+	}
+	rasterizedImageSpec, err := i.processorService.GetSpec(rasterizedImage)
+	if err != nil {
+		return nil, domain.ImageSpec{}, errors.New("internal error")
+	}
+	// the original format still decides the format of auto requests
+	rasterizedImageSpec.Format = parentImageSpec.Format
+	return rasterizedImage, rasterizedImageSpec, nil
 }
 
 func NewImageService(storageSvc appsvc.ImageStorageServiceInterface,
-	processorSvc appsvc.ImageProcessingServiceInterface) ImageServiceInterface {
+	processorSvc appsvc.ImageProcessingServiceInterface,
+	tenantConfigSvc appsvc.TenantConfigServiceInterface,
+	jobQueue appsvc.JobQueueInterface,
+	webhookSvc appsvc.WebhookServiceInterface,
+	remoteFetchSvc appsvc.RemoteFetchServiceInterface,
+	uploadStore appsvc.ResumableUploadStoreInterface,
+	uploadTokenSvc appsvc.UploadTokenServiceInterface,
+	urlSigner appsvc.UrlSignerInterface) ImageServiceInterface {
 	return ImageService{
-		storageService:   storageSvc,
-		processorService: processorSvc,
+		storageService:      storageSvc,
+		processorService:    processorSvc,
+		tenantConfigService: tenantConfigSvc,
+		jobQueue:            jobQueue,
+		webhookService:      webhookSvc,
+		remoteFetchService:  remoteFetchSvc,
+		uploadStore:         uploadStore,
+		uploadTokenService:  uploadTokenSvc,
+		urlSigner:           urlSigner,
 	}
 }
 
@@ -185,6 +1535,51 @@ func (gio GetImageOpts) SetFormat(format domain.ImageType) GetImageOpts {
 	return gio
 }
 
+func (gio GetImageOpts) SetWatermark(watermark bool) GetImageOpts {
+	gio.Watermark = &watermark
+	return gio
+}
+
+func (gio GetImageOpts) SetText(text domain.TextOpts) GetImageOpts {
+	gio.Text = &text
+	return gio
+}
+
+func (gio GetImageOpts) SetShape(shape domain.ShapeOpts) GetImageOpts {
+	gio.Shape = &shape
+	return gio
+}
+
+func (gio GetImageOpts) SetFrame(frame int) GetImageOpts {
+	gio.Frame = &frame
+	return gio
+}
+
+func (gio GetImageOpts) SetPage(page int) GetImageOpts {
+	gio.Page = &page
+	return gio
+}
+
+func (gio GetImageOpts) SetDensity(density int) GetImageOpts {
+	gio.Density = &density
+	return gio
+}
+
+func (gio GetImageOpts) SetMaxBytes(maxBytes int) GetImageOpts {
+	gio.MaxBytes = &maxBytes
+	return gio
+}
+
+func (gio GetImageOpts) SetDpr(dpr float64) GetImageOpts {
+	gio.Dpr = &dpr
+	return gio
+}
+
+func (gio GetImageOpts) SetAccess(access domain.Access) GetImageOpts {
+	gio.Access = access
+	return gio
+}
+
 func NewServiceGetImageOpts() GetImageOpts {
 	return GetImageOpts{}
 }
@@ -198,33 +1593,46 @@ func determineDimensions(opts GetImageOpts, originalDimensions ...int) (int, int
 		originalAr = domain.NewAspectRatioFrom(originalWidth, originalHeight)
 	}
 
+	// requested dimensions are in css pixels, the device pixel ratio turns them
+	// into physical ones
+	width := applyDpr(opts.Width, opts.Dpr)
+	height := applyDpr(opts.Height, opts.Dpr)
+
 	if opts.Ar != nil {
-		if opts.Width != nil && opts.Height != nil {
-			return *opts.Width, *opts.Height
+		if width != nil && height != nil {
+			return *width, *height
 		}
-		if opts.Width != nil {
-			return *opts.Width, heightFromAspectRatio(*opts.Width, *opts.Ar)
+		if width != nil {
+			return *width, heightFromAspectRatio(*width, *opts.Ar)
 		}
-		if opts.Height != nil {
-			return widthFromAspectRatio(*opts.Height, *opts.Ar), *opts.Height
+		if height != nil {
+			return widthFromAspectRatio(*height, *opts.Ar), *height
 		}
 		if originalAr.Float64() > opts.Ar.Float64() {
 			return widthFromAspectRatio(originalHeight, *opts.Ar), originalHeight
 		}
 		return originalWidth, heightFromAspectRatio(originalWidth, *opts.Ar)
 	}
-	if opts.Width != nil && opts.Height != nil {
-		return *opts.Width, *opts.Height
+	if width != nil && height != nil {
+		return *width, *height
 	}
-	if opts.Width != nil {
-		return *opts.Width, heightFromAspectRatio(*opts.Width, originalAr)
+	if width != nil {
+		return *width, heightFromAspectRatio(*width, originalAr)
 	}
-	if opts.Height != nil {
-		return widthFromAspectRatio(*opts.Height, originalAr), *opts.Height
+	if height != nil {
+		return widthFromAspectRatio(*height, originalAr), *height
 	}
 	return originalWidth, originalHeight
 }
 
+func applyDpr(dimension *int, dpr *float64) *int {
+	if dimension == nil || dpr == nil {
+		return dimension
+	}
+	scaled := int(math.Round(float64(*dimension) * *dpr))
+	return &scaled
+}
+
 func heightFromAspectRatio(width int, ar domain.AR) int {
 	return int(math.Round(float64(width) / ar.Float64()))
 }
@@ -256,3 +1664,84 @@ func originalDimensionsNeeded(opts GetImageOpts) bool {
 	return (opts.Ar == nil && (opts.Width == nil || opts.Height == nil)) ||
 		(opts.Ar != nil && (opts.Width == nil && opts.Height == nil))
 }
+
+// watermarkToApply returns the tenant watermark if it is either requested or
+// enforced for outputs of the given width.
+func watermarkToApply(opts GetImageOpts, tenantConfig domain.TenantConfig, targetWidth int) *domain.WatermarkConfig {
+	watermark := tenantConfig.Watermark
+	if watermark == nil || watermark.ImageName == "" {
+		return nil
+	}
+	if watermark.EnforceAboveWidth > 0 && targetWidth > watermark.EnforceAboveWidth {
+		return watermark
+	}
+	if opts.Watermark != nil && *opts.Watermark {
+		return watermark
+	}
+	return nil
+}
+
+// batchError reports the failure of a file in a batch without exposing the
+// details of internal errors.
+func batchError(err error) *domain.BatchError {
+	if errors.Is(err, ErrUnsupportedImageFormat) {
+		return &domain.BatchError{Code: domain.BatchErrorUnsupportedFormat, Message: err.Error()}
+	}
+	if errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrObjectQuotaExceeded) {
+		return &domain.BatchError{Code: domain.BatchErrorQuotaExceeded, Message: err.Error()}
+	}
+	return &domain.BatchError{Code: domain.BatchErrorInternal, Message: "failed to upload the file"}
+}
+
+// variantKey derives a short, stable cache key from the descriptions of the
+// extra operations applied to a child image.
+func variantKey(ops ...string) string {
+	if len(ops) == 0 {
+		return ""
+	}
+	sum := sha1.Sum([]byte(strings.Join(ops, ";")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// eagerImageOpts are the options GetImage is called with to render the eager
+// transformation, matching the ones of a request for the same variant.
+func eagerImageOpts(name string, tenantOpts domain.TenantOpts, transformation domain.EagerTransformation) GetImageOpts {
+	opts := NewServiceGetImageOpts().SetName(name).SetTenantOpts(tenantOpts).SetFormat(transformation.Format)
+	if transformation.Width > 0 {
+		opts = opts.SetWidth(transformation.Width)
+	}
+	if transformation.Height > 0 {
+		opts = opts.SetHeight(transformation.Height)
+	}
+	if transformation.Ar != nil {
+		opts = opts.SetAr(*transformation.Ar)
+	}
+	return opts
+}
+
+func compressionKey(spec domain.ImageSpec) string {
+	return fmt.Sprintf("compression-%s-%dx%d-%s", spec.Format, spec.Width, spec.Height, spec.Variant)
+}
+
+// shapeForFormat resolves the background of the requested shape for the target
+// format. Formats without an alpha channel get the background flattened in,
+// falling back to white when it is missing or not fully opaque.
+func shapeForFormat(shape *domain.ShapeOpts, format domain.ImageType) *domain.ShapeOpts {
+	if shape == nil {
+		return nil
+	}
+	resolved := *shape
+	if format.SupportsAlpha() {
+		return &resolved
+	}
+	background := domain.Color{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	if shape.Background != nil && shape.Background.A == 0xff {
+		background = *shape.Background
+	}
+	resolved.Background = &background
+	return &resolved
+}
+
+func needsRasterization(opts GetImageOpts, format domain.ImageType) bool {
+	return format.IsVector() || (format.IsPaged() && opts.Page != nil)
+}
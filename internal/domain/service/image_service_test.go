@@ -1,11 +1,20 @@
 package domainsvc
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	appsvc "example.com/imageProc/internal/app/service"
 	"example.com/imageProc/internal/domain"
 	"example.com/imageProc/internal/mock"
 	"github.com/stretchr/testify/assert"
+	testifymock "github.com/stretchr/testify/mock"
+	"image"
+	"io"
 	"testing"
+	"time"
 )
 
 func TestUpload(t *testing.T) {
@@ -16,153 +25,1915 @@ func TestUpload(t *testing.T) {
 		imgFormat := domain.ImageType_WEBP
 		tenantOpts := domain.TenantOpts{}
 
-		mockStorageSvc := new(mock.ImageStorageService)
-		mockImageProcessingSvc := new(mock.ImageProcessingService)
+		svc, mocks := newTestImageService()
 
-		mockImageProcessingSvc.On("GetFormat", img).Return(imgFormat, nil)
-		mockStorageSvc.On("StoreParentImage", img, imgFormat, tenantOpts).Return(imgName, nil)
+		mocks.processor.On("GetFormat", img).Return(imgFormat, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(0xf0e1d2c3b4a59687), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, imgFormat, tenantOpts).Return(imgName, nil)
+		mocks.storage.On("StoreParentAttachment", imgName, "phash", []byte("f0e1d2c3b4a59687"), tenantOpts).
+			Return(nil)
 
-		svc := NewImageService(mockStorageSvc, mockImageProcessingSvc)
+		imgId, err := svc.Upload(ctx, img, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, imgName, imgId)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+}
+
+func TestUploadQuota(t *testing.T) {
+	img := []byte("valid image")
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	usage := domain.Usage{
+		Originals:   domain.UsageCount{Objects: 2, Bytes: 100},
+		Derivatives: domain.UsageCount{Objects: 4, Bytes: 50},
+	}
+
+	testCases := []struct {
+		name        string
+		quota       domain.QuotaConfig
+		evicted     bool
+		expectedErr error
+	}{
+		{name: "an image within the quota is stored", quota: domain.QuotaConfig{MaxBytes: 200, MaxObjects: 10}},
+		{name: "an image past the byte quota is refused", quota: domain.QuotaConfig{MaxBytes: 150},
+			expectedErr: ErrStorageQuotaExceeded},
+		{name: "an image past the object quota is refused", quota: domain.QuotaConfig{MaxObjects: 6},
+			expectedErr: ErrObjectQuotaExceeded},
+		{name: "derivatives are evicted to make room", quota: domain.QuotaConfig{MaxBytes: 150, EvictDerivatives: true},
+			evicted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, mocks := newTestImageService()
+
+			mocks.processor.On("GetFormat", img).Return(domain.ImageType_WEBP, nil)
+			mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+			mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{Quota: &tc.quota}, nil)
+			mocks.storage.On("Usage", tenantOpts).Return(usage, nil)
+			mocks.storage.On("ListParentImages", tenantOpts).Return([]string{"a", "b"}, nil)
+			mocks.storage.On("DeleteChildImages", "a", tenantOpts).Return(domain.UsageCount{Objects: 4, Bytes: 50}, nil)
+			mocks.storage.On("StoreParentImage", img, domain.ImageType_WEBP, tenantOpts).Return("image", nil)
+			mocks.storage.On("StoreParentAttachment", "image", "phash", []byte("0000000000000001"), tenantOpts).
+				Return(nil)
+
+			imgName, err := svc.Upload(context.Background(), img, tenantOpts)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				mocks.storage.AssertNotCalled(t, "StoreParentImage", img, domain.ImageType_WEBP, tenantOpts)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "image", imgName)
+			if tc.evicted {
+				mocks.storage.AssertCalled(t, "DeleteChildImages", "a", tenantOpts)
+				mocks.storage.AssertNotCalled(t, "DeleteChildImages", "b", tenantOpts)
+			} else {
+				mocks.storage.AssertNotCalled(t, "DeleteChildImages", "a", tenantOpts)
+			}
+		})
+	}
+}
+
+func TestUploadSvg(t *testing.T) {
+	t.Run("an svg is sanitized before it is stored", func(t *testing.T) {
+		img := []byte(`<svg onload="alert(1)"><script>alert(2)</script><rect width="1" height="1"/></svg>`)
+		sanitizedImg := []byte(`<svg><rect width="1" height="1"></rect></svg>`)
+		tenantOpts := domain.TenantOpts{}
+
+		svc, mocks := newTestImageService()
+
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_SVG, nil)
+		mocks.processor.On("PerceptualHash", sanitizedImg).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", sanitizedImg, domain.ImageType_SVG, tenantOpts).Return("svgimage", nil)
+		mocks.storage.On("StoreParentAttachment", "svgimage", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		imgId, err := svc.Upload(context.Background(), img, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "svgimage", imgId)
+		mocks.storage.AssertExpectations(t)
+	})
+}
+
+func TestUploadFromUrl(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("the fetched image is uploaded", func(t *testing.T) {
+		ctx := context.Background()
+		img := []byte("remote image")
+
+		svc, mocks := newTestImageService()
+
+		mocks.remoteFetch.On("Fetch", ctx, "https://legacy/image.jpeg").Return(img, nil)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		imgId, err := svc.UploadFromUrl(ctx, "https://legacy/image.jpeg", tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "testimagename1", imgId)
+		mocks.storage.AssertExpectations(t)
+	})
+
+	testCases := []struct {
+		fetchErr    error
+		expectedErr error
+	}{
+		{fetchErr: appsvc.ErrInvalidRemoteUrl, expectedErr: ErrInvalidRemoteUrl},
+		{fetchErr: appsvc.ErrForbiddenRemoteAddress, expectedErr: ErrForbiddenRemoteAddress},
+		{fetchErr: appsvc.ErrRemoteTooLarge, expectedErr: ErrRemoteTooLarge},
+		{fetchErr: appsvc.ErrRemoteNotImage, expectedErr: ErrUnsupportedImageFormat},
+		{fetchErr: appsvc.ErrRemoteFetchFailed, expectedErr: ErrRemoteFetchFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.fetchErr.Error(), func(t *testing.T) {
+			ctx := context.Background()
+
+			svc, mocks := newTestImageService()
+
+			mocks.remoteFetch.On("Fetch", ctx, "https://legacy/image.jpeg").Return([]byte(nil), tc.fetchErr)
+
+			_, err := svc.UploadFromUrl(ctx, "https://legacy/image.jpeg", tenantOpts)
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+			mocks.storage.AssertNotCalled(t, "StoreParentImage")
+		})
+	}
+}
+
+func TestUploadFromUrls(t *testing.T) {
+	t.Run("the outcome of every url is reported in order", func(t *testing.T) {
+		ctx := context.Background()
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+		img := []byte("remote image")
+
+		svc, mocks := newTestImageService()
+
+		mocks.remoteFetch.On("Fetch", ctx, "https://legacy/1.jpeg").Return(img, nil)
+		mocks.remoteFetch.On("Fetch", ctx, "http://10.0.0.1/2.jpeg").Return([]byte(nil), appsvc.ErrForbiddenRemoteAddress)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		results := svc.UploadFromUrls(ctx, []string{"https://legacy/1.jpeg", "http://10.0.0.1/2.jpeg"}, tenantOpts)
+
+		assert.Equal(t, []domain.RemoteUpload{
+			{Url: "https://legacy/1.jpeg", ImageName: "testimagename1"},
+			{Url: "http://10.0.0.1/2.jpeg", Error: ErrForbiddenRemoteAddress.Error()},
+		}, results)
+	})
+}
+
+func TestUploadBatch(t *testing.T) {
+	t.Run("the outcome of every file is reported in order", func(t *testing.T) {
+		ctx := context.Background()
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+		img := []byte("valid image")
+		notImg := []byte("not an image")
+		broken := []byte("broken image")
+
+		svc, mocks := newTestImageService()
+
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("GetFormat", notImg).Return(domain.ImageType(0), appsvc.ErrUnsupportedImageFormat)
+		mocks.processor.On("GetFormat", broken).Return(domain.ImageType(0), errors.New("vips error"))
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		results := svc.UploadBatch(ctx, []domain.BatchFile{
+			batchFile("a.jpg", img),
+			batchFile("b.txt", notImg),
+			{Name: "c.jpg", Err: errors.New("failed to open the file")},
+			batchFile("d.jpg", broken),
+			{Name: "e.jpg", Open: func() (io.ReadCloser, error) { return nil, errors.New("gone") }},
+		}, tenantOpts)
+
+		assert.Equal(t, []domain.BatchUpload{
+			{File: "a.jpg", ImageName: "testimagename1"},
+			{File: "b.txt", Error: &domain.BatchError{Code: domain.BatchErrorUnsupportedFormat, Message: ErrUnsupportedImageFormat.Error()}},
+			{File: "c.jpg", Error: &domain.BatchError{Code: domain.BatchErrorInvalidFile, Message: "failed to open the file"}},
+			{File: "d.jpg", Error: &domain.BatchError{Code: domain.BatchErrorInternal, Message: "failed to upload the file"}},
+			{File: "e.jpg", Error: &domain.BatchError{Code: domain.BatchErrorInvalidFile, Message: "failed to open the file"}},
+		}, results)
+	})
+}
+
+func batchFile(name string, content []byte) domain.BatchFile {
+	return domain.BatchFile{
+		Name: name,
+		Size: int64(len(content)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(content)), nil },
+	}
+}
+
+func TestUploadWithToken(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	img := []byte("valid image")
+	token := domain.UploadToken{
+		Id:         "token1",
+		TenantOpts: tenantOpts,
+		MaxBytes:   1024,
+		Formats:    []domain.ImageType{domain.ImageType_JPEG},
+		SingleUse:  true,
+	}
+
+	t.Run("the image is uploaded to the tenant of the token", func(t *testing.T) {
+		ctx := context.Background()
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadToken.On("Verify", "signed").Return(token, nil)
+		mocks.uploadToken.On("Redeem", token).Return(nil)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		imgName, uploadedTo, err := svc.UploadWithToken(ctx, "signed", img)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "testimagename1", imgName)
+		assert.Equal(t, tenantOpts, uploadedTo)
+		mocks.uploadToken.AssertExpectations(t)
+	})
+
+	t.Run("a token is released when the upload fails after it was redeemed", func(t *testing.T) {
+		ctx := context.Background()
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadToken.On("Verify", "signed").Return(token, nil)
+		mocks.uploadToken.On("Redeem", token).Return(nil)
+		mocks.uploadToken.On("Release", token).Return(nil)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).
+			Return(domain.TenantConfig{Quota: &domain.QuotaConfig{MaxBytes: 4}}, nil)
+		mocks.storage.On("Usage", tenantOpts).Return(domain.Usage{}, nil)
+
+		_, _, err := svc.UploadWithToken(ctx, "signed", img)
+
+		assert.ErrorIs(t, err, ErrStorageQuotaExceeded)
+		mocks.storage.AssertNotCalled(t, "StoreParentImage")
+		mocks.uploadToken.AssertExpectations(t)
+	})
+
+	testCases := []struct {
+		name        string
+		maxBytes    int64
+		format      domain.ImageType
+		verifyErr   error
+		redeemErr   error
+		expectedErr error
+	}{
+		{name: "an invalid token", verifyErr: domain.ErrInvalidUploadToken, expectedErr: ErrInvalidUploadToken},
+		{name: "an expired token", verifyErr: domain.ErrUploadTokenExpired, expectedErr: ErrUploadTokenExpired},
+		{name: "an image too large", maxBytes: 4, expectedErr: ErrUploadTooLarge},
+		{name: "a format not allowed", format: domain.ImageType_PNG, expectedErr: ErrFormatNotAllowed},
+		{name: "a used token", redeemErr: appsvc.ErrUploadTokenUsed, expectedErr: ErrUploadTokenUsed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			tcToken := token
+			if tc.maxBytes != 0 {
+				tcToken.MaxBytes = tc.maxBytes
+			}
+			format := domain.ImageType_JPEG
+			if tc.format != 0 {
+				format = tc.format
+			}
+
+			svc, mocks := newTestImageService()
+
+			mocks.uploadToken.On("Verify", "signed").Return(tcToken, tc.verifyErr)
+			mocks.uploadToken.On("Redeem", tcToken).Return(tc.redeemErr)
+			mocks.processor.On("GetFormat", img).Return(format, nil)
+
+			_, _, err := svc.UploadWithToken(ctx, "signed", img)
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+			mocks.storage.AssertNotCalled(t, "StoreParentImage")
+			if tc.redeemErr == nil {
+				mocks.uploadToken.AssertNotCalled(t, "Redeem", tcToken)
+			}
+		})
+	}
+}
+
+func TestAppendUpload(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	img := []byte("valid image")
+
+	t.Run("the image is uploaded once the last chunk is received", func(t *testing.T) {
+		ctx := context.Background()
+		chunk := bytes.NewReader(img[4:])
+		upload := domain.ResumableUpload{Id: "upload1", TenantOpts: tenantOpts, Length: int64(len(img)), Offset: 4}
+		received := upload
+		received.Offset = upload.Length
+		completed := received
+		completed.ImageName = "testimagename1"
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadStore.On("Get", "upload1").Return(upload, nil)
+		mocks.uploadStore.On("Append", "upload1", int64(4), chunk).Return(received, nil)
+		mocks.uploadStore.On("Read", "upload1").Return(img, nil)
+		mocks.uploadStore.On("Complete", "upload1", "testimagename1").Return(completed, nil)
+		mocks.uploadStore.On("Release", "upload1").Return()
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		res, err := svc.AppendUpload(ctx, "upload1", 4, chunk, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, completed, res)
+		mocks.uploadStore.AssertExpectations(t)
+	})
+
+	t.Run("a partial upload is not processed", func(t *testing.T) {
+		ctx := context.Background()
+		chunk := bytes.NewReader(img[:4])
+		upload := domain.ResumableUpload{Id: "upload1", TenantOpts: tenantOpts, Length: int64(len(img))}
+		partial := upload
+		partial.Offset = 4
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadStore.On("Get", "upload1").Return(upload, nil)
+		mocks.uploadStore.On("Append", "upload1", int64(0), chunk).Return(partial, nil)
+
+		res, err := svc.AppendUpload(ctx, "upload1", 0, chunk, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, partial, res)
+		mocks.uploadStore.AssertNotCalled(t, "Read", "upload1")
+		mocks.storage.AssertNotCalled(t, "StoreParentImage")
+	})
+
+	t.Run("an upload which is not an image is dropped", func(t *testing.T) {
+		ctx := context.Background()
+		chunk := bytes.NewReader(img)
+		upload := domain.ResumableUpload{Id: "upload1", TenantOpts: tenantOpts, Length: int64(len(img))}
+		received := upload
+		received.Offset = upload.Length
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadStore.On("Get", "upload1").Return(upload, nil)
+		mocks.uploadStore.On("Append", "upload1", int64(0), chunk).Return(received, nil)
+		mocks.uploadStore.On("Read", "upload1").Return(img, nil)
+		mocks.uploadStore.On("Delete", "upload1").Return(nil)
+		mocks.uploadStore.On("Release", "upload1").Return()
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType(0), appsvc.ErrUnsupportedImageFormat)
+
+		_, err := svc.AppendUpload(ctx, "upload1", 0, chunk, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrUnsupportedImageFormat)
+		mocks.uploadStore.AssertCalled(t, "Delete", "upload1")
+	})
+
+	t.Run("uploads of other tenants are not found", func(t *testing.T) {
+		ctx := context.Background()
+		upload := domain.ResumableUpload{Id: "upload1", TenantOpts: domain.TenantOpts{TenantCode: "other", OrgCode: "org"}}
+
+		svc, mocks := newTestImageService()
+
+		mocks.uploadStore.On("Get", "upload1").Return(upload, nil)
+
+		_, err := svc.AppendUpload(ctx, "upload1", 0, bytes.NewReader(img), tenantOpts)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		err = svc.TerminateUpload(ctx, "upload1", tenantOpts)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		mocks.uploadStore.AssertNotCalled(t, "Append")
+		mocks.uploadStore.AssertNotCalled(t, "Delete", "upload1")
+	})
+
+	t.Run("a last chunk retried while the upload is processed is refused", func(t *testing.T) {
+		ctx := context.Background()
+		uploadStore, err := appsvc.NewFileUploadStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating upload store: %v", err)
+		}
+		upload, err := uploadStore.Create(domain.ResumableUpload{TenantOpts: tenantOpts, Length: int64(len(img))})
+		if err != nil {
+			t.Fatalf("error creating upload: %v", err)
+		}
+		processing := make(chan struct{})
+		proceed := make(chan struct{})
+
+		_, mocks := newTestImageService()
+		svc := NewImageService(mocks.storage, mocks.processor, mocks.tenantConfig, mocks.jobQueue, mocks.webhook,
+			mocks.remoteFetch, uploadStore, mocks.uploadToken, mocks.urlSigner)
+
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil).Run(func(testifymock.Arguments) {
+			close(processing)
+			<-proceed
+		})
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+
+		finished := make(chan error)
+		go func() {
+			_, err := svc.AppendUpload(ctx, upload.Id, 0, bytes.NewReader(img), tenantOpts)
+			finished <- err
+		}()
+		<-processing
+		_, err = svc.AppendUpload(ctx, upload.Id, upload.Length, bytes.NewReader(nil), tenantOpts)
+		assert.ErrorIs(t, err, ErrUploadLocked)
+		close(proceed)
+		assert.NoError(t, <-finished)
+
+		res, err := svc.AppendUpload(ctx, upload.Id, upload.Length, bytes.NewReader(nil), tenantOpts)
+		assert.NoError(t, err)
+		assert.Equal(t, "testimagename1", res.ImageName)
+		mocks.storage.AssertNumberOfCalls(t, "StoreParentImage", 1)
+	})
+
+	testCases := []struct {
+		storeErr    error
+		expectedErr error
+	}{
+		{storeErr: appsvc.ErrUploadOffsetMismatch, expectedErr: ErrUploadOffsetMismatch},
+		{storeErr: appsvc.ErrUploadExceedsLength, expectedErr: ErrUploadExceedsLength},
+		{storeErr: appsvc.ErrUploadLocked, expectedErr: ErrUploadLocked},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.storeErr.Error(), func(t *testing.T) {
+			ctx := context.Background()
+			chunk := bytes.NewReader(img)
+			upload := domain.ResumableUpload{Id: "upload1", TenantOpts: tenantOpts, Length: int64(len(img))}
+
+			svc, mocks := newTestImageService()
+
+			mocks.uploadStore.On("Get", "upload1").Return(upload, nil)
+			mocks.uploadStore.On("Append", "upload1", int64(0), chunk).Return(upload, tc.storeErr)
+
+			_, err := svc.AppendUpload(ctx, "upload1", 0, chunk, tenantOpts)
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+			mocks.uploadStore.AssertNotCalled(t, "Read", "upload1")
+		})
+	}
+}
+
+func TestOriginPull(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	tenantConfig := domain.TenantConfig{Origin: &domain.OriginConfig{BaseUrl: "https://bucket/images"}}
+
+	t.Run("a missing original is pulled from the origin and stored under its name", func(t *testing.T) {
+		img := []byte("origin image")
+		// the pull is bound to the request
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "cat.jpg", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile).Once()
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(tenantConfig, nil)
+		mocks.remoteFetch.On("Fetch", ctx, "https://bucket/images/cat.jpg").Return(img, nil)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.storage.On("StoreNamedParentImage", "cat.jpg", img, domain.ImageType_JPEG, tenantOpts).Return(nil)
+		mocks.storage.On("StoreParentAttachment", "cat.jpg", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+		mocks.storage.On("GetParentImage", "cat.jpg", tenantOpts).Return(img, nil).Once()
+
+		original, format, err := svc.GetOriginalImage(ctx, "cat.jpg", domain.Access{}, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, img, original)
+		assert.Equal(t, domain.ImageType_JPEG, format)
+		mocks.storage.AssertExpectations(t)
+	})
+
+	testCases := []struct {
+		name        string
+		fetchErr    error
+		expectedErr error
+	}{
+		{name: "an original missing at the origin too", fetchErr: appsvc.ErrRemoteNotFound, expectedErr: ErrNotFound},
+		{name: "an origin which is down", fetchErr: appsvc.ErrRemoteFetchFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, mocks := newTestImageService()
+
+			mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+				Return([]byte(nil), appsvc.ErrNoMatchingFile)
+			mocks.storage.On("GetParentImage", "cat.jpg", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+			mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(tenantConfig, nil)
+			mocks.remoteFetch.On("Fetch", context.Background(), "https://bucket/images/cat.jpg").
+				Return([]byte(nil), tc.fetchErr)
+
+			_, _, err := svc.GetOriginalImage(context.Background(), "cat.jpg", domain.Access{}, tenantOpts)
+
+			assert.Error(t, err)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NotErrorIs(t, err, ErrNotFound)
+			}
+			mocks.storage.AssertNotCalled(t, "StoreNamedParentImage")
+		})
+	}
+	t.Run("an original past the quota is not pulled into the storage", func(t *testing.T) {
+		img := []byte("origin image")
+		quotaConfig := domain.TenantConfig{
+			Origin: tenantConfig.Origin,
+			Quota:  &domain.QuotaConfig{MaxBytes: 4},
+		}
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "cat.jpg", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(quotaConfig, nil)
+		mocks.remoteFetch.On("Fetch", context.Background(), "https://bucket/images/cat.jpg").Return(img, nil)
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.storage.On("Usage", tenantOpts).Return(domain.Usage{}, nil)
+
+		_, _, err := svc.GetOriginalImage(context.Background(), "cat.jpg", domain.Access{}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrStorageQuotaExceeded)
+		mocks.storage.AssertNotCalled(t, "StoreNamedParentImage")
+	})
+}
+
+func TestGetOriginalImageAccess(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	otherTenant := domain.TenantOpts{TenantCode: "other", OrgCode: "org"}
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	img := []byte("image")
+
+	testCases := []struct {
+		name         string
+		stored       []byte
+		defaultValue domain.Visibility
+		access       domain.Access
+		validSig     bool
+		expectedErr  error
+	}{
+		{name: "public images are served to anyone", stored: []byte("public")},
+		{name: "the tenant default applies without a setting", defaultValue: domain.Visibility_PRIVATE,
+			expectedErr: ErrAccessDenied},
+		{name: "private images are denied to anonymous requests", stored: []byte("private"),
+			expectedErr: ErrAccessDenied},
+		{name: "private images are served to principals of the tenant", stored: []byte("private"),
+			access: domain.Access{Principal: &domain.Principal{TenantOpts: tenantOpts}}},
+		{name: "private images are denied to principals of other tenants", stored: []byte("private"),
+			access: domain.Access{Principal: &domain.Principal{TenantOpts: otherTenant}}, expectedErr: ErrAccessDenied},
+		{name: "private images are served through signed urls", stored: []byte("private"),
+			access: domain.Access{Expires: expires, Signature: "sig"}, validSig: true},
+		{name: "signed images are denied to principals of the tenant", stored: []byte("signed"),
+			access: domain.Access{Principal: &domain.Principal{TenantOpts: tenantOpts}}, expectedErr: ErrAccessDenied},
+		{name: "signed images are served through signed urls", stored: []byte("signed"),
+			access: domain.Access{Expires: expires, Signature: "sig"}, validSig: true},
+		{name: "signed images are denied with an invalid signature", stored: []byte("signed"),
+			access: domain.Access{Expires: expires, Signature: "sig"}, expectedErr: ErrAccessDenied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, mocks := newTestImageService()
+
+			if tc.stored != nil {
+				mocks.storage.On("GetParentSetting", "cat.jpg", "visibility", tenantOpts).Return(tc.stored, nil)
+			} else {
+				mocks.storage.On("GetParentSetting", "cat.jpg", "visibility", tenantOpts).
+					Return([]byte(nil), appsvc.ErrNoMatchingFile)
+				mocks.tenantConfig.On("GetTenantConfig", tenantOpts).
+					Return(domain.TenantConfig{Visibility: tc.defaultValue}, nil)
+			}
+			mocks.urlSigner.On("Verify", "cat.jpg", tenantOpts, expires, "sig").Return(tc.validSig)
+			mocks.storage.On("GetParentImage", "cat.jpg", tenantOpts).Return(img, nil)
+			mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+
+			original, _, err := svc.GetOriginalImage(context.Background(), "cat.jpg", tc.access, tenantOpts)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				mocks.storage.AssertNotCalled(t, "GetParentImage", "cat.jpg", tenantOpts)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, img, original)
+		})
+	}
+}
+
+func TestUploadEager(t *testing.T) {
+	t.Run("the eager transformations of the tenant are queued", func(t *testing.T) {
+		img := []byte("valid image")
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+		transformations := []domain.EagerTransformation{{Width: 320, Format: domain.ImageType_WEBP}}
+		status, _ := json.Marshal(domain.EagerStatus{State: domain.JobState_PENDING, Total: 1})
+
+		svc, mocks := newTestImageService()
+
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{Eager: transformations}, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "eager", status, tenantOpts).Return(nil)
+		job := domain.Job{
+			Type:       domain.JobType_EAGER,
+			TenantOpts: tenantOpts,
+			Images:     []string{"testimagename1"},
+			Eager:      transformations,
+		}
+		mocks.jobQueue.On("Enqueue", job).Return(job, nil)
+
+		imgId, err := svc.Upload(context.Background(), img, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "testimagename1", imgId)
+		mocks.storage.AssertExpectations(t)
+		mocks.jobQueue.AssertExpectations(t)
+	})
+}
+
+func TestUploadWebhooks(t *testing.T) {
+	t.Run("the subscribed webhooks of the tenant are notified", func(t *testing.T) {
+		img := []byte("valid image")
+		tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+		tenantConfig := domain.TenantConfig{Webhooks: []domain.WebhookConfig{
+			{Url: "http://cms/uploads", Events: []domain.EventType{domain.EventType_IMAGE_UPLOADED}},
+			{Url: "http://cms/deletions", Events: []domain.EventType{domain.EventType_IMAGE_DELETED}},
+			{Url: "http://cms/all"},
+		}}
+
+		svc, mocks := newTestImageService()
+
+		mocks.processor.On("GetFormat", img).Return(domain.ImageType_JPEG, nil)
+		mocks.processor.On("PerceptualHash", img).Return(domain.PerceptualHash(1), nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(tenantConfig, nil)
+		mocks.storage.On("StoreParentImage", img, domain.ImageType_JPEG, tenantOpts).Return("testimagename1", nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("0000000000000001"), tenantOpts).
+			Return(nil)
+		for _, url := range []string{"http://cms/uploads", "http://cms/all"} {
+			mocks.jobQueue.On("Enqueue", testifymock.MatchedBy(func(job domain.Job) bool {
+				return job.Type == domain.JobType_WEBHOOK && job.WebhookUrl == url && job.TenantOpts == tenantOpts &&
+					job.Event.Type == domain.EventType_IMAGE_UPLOADED && job.Event.ImageName == "testimagename1"
+			})).Return(domain.Job{}, nil).Once()
+		}
+
+		_, err := svc.Upload(context.Background(), img, tenantOpts)
+
+		assert.NoError(t, err)
+		mocks.jobQueue.AssertExpectations(t)
+	})
+}
+
+func TestHandleJob(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("every eager transformation is rendered and its progress recorded", func(t *testing.T) {
+		transformations := []domain.EagerTransformation{
+			{Width: 320, Height: 240, Format: domain.ImageType_WEBP},
+			{Width: 640, Height: 480, Format: domain.ImageType_AVIF},
+		}
+		statusContent := func(status domain.EagerStatus) []byte {
+			content, _ := json.Marshal(status)
+			return content
+		}
+
+		svc, mocks := newTestImageService()
+
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{Eager: transformations}, nil)
+		mocks.storage.On("GetChildImage", "testimagename1",
+			domain.ImageSpec{Width: 320, Height: 240, Format: domain.ImageType_WEBP}, tenantOpts).
+			Return([]byte("cached"), nil)
+		mocks.storage.On("GetChildImage", "testimagename1",
+			domain.ImageSpec{Width: 640, Height: 480, Format: domain.ImageType_AVIF}, tenantOpts).
+			Return([]byte(nil), errors.New("disk failure"))
+		for _, status := range []domain.EagerStatus{
+			{State: domain.JobState_RUNNING, Total: 2},
+			{State: domain.JobState_RUNNING, Total: 2, Completed: 1},
+			{State: domain.JobState_RUNNING, Total: 2, Completed: 1, Failed: 1, Errors: []string{"internal error"}},
+			{State: domain.JobState_FAILED, Total: 2, Completed: 1, Failed: 1, Errors: []string{"internal error"}},
+		} {
+			mocks.storage.On("StoreParentAttachment", "testimagename1", "eager", statusContent(status), tenantOpts).
+				Return(nil).Once()
+		}
+
+		err := svc.HandleJob(context.Background(), domain.Job{
+			Type:       domain.JobType_EAGER,
+			TenantOpts: tenantOpts,
+			Images:     []string{"testimagename1"},
+			Eager:      transformations,
+		})
+
+		// the failed transformation is retried along with the job
+		assert.Error(t, err)
+		mocks.storage.AssertExpectations(t)
+	})
+	t.Run("a prerender job renders every variant and leaves failures to the cache", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return([]byte(nil), errors.New("disk error"))
+
+		err := svc.HandleJob(context.Background(), domain.Job{
+			Type:       domain.JobType_PRERENDER,
+			TenantOpts: tenantOpts,
+			Images:     []string{"testimagename1"},
+			Eager:      []domain.EagerTransformation{{Width: 320, Format: domain.ImageType_WEBP}},
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("reprocessing all images of the tenant", func(t *testing.T) {
+		parentImage := []byte("parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("ListParentImages", tenantOpts).Return([]string{"testimagename1", "deleted"}, nil)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("DeleteDerivedImages", "testimagename1", tenantOpts).Return(nil)
+		mocks.storage.On("DeleteDerivedImages", "deleted", tenantOpts).Return(appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "phash", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 100, Height: 100, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("PerceptualHash", parentImage).Return(domain.PerceptualHash(0xff), nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "phash", []byte("00000000000000ff"), tenantOpts).
+			Return(nil)
+
+		err := svc.HandleJob(context.Background(), domain.Job{Type: domain.JobType_REPROCESS, TenantOpts: tenantOpts})
+
+		assert.NoError(t, err)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("purging an image which is already gone", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("DeleteParentImage", "testimagename1", tenantOpts).Return(nil)
+		mocks.storage.On("DeleteParentImage", "deleted", tenantOpts).Return(appsvc.ErrNoMatchingFile)
+
+		err := svc.HandleJob(context.Background(), domain.Job{
+			Type:       domain.JobType_PURGE,
+			TenantOpts: tenantOpts,
+			Images:     []string{"testimagename1", "deleted"},
+		})
+
+		assert.NoError(t, err)
+		mocks.storage.AssertExpectations(t)
+	})
+}
+
+func TestHandleWebhookJob(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	event := domain.Event{Id: "event1", Type: domain.EventType_IMAGE_DELETED, TenantOpts: tenantOpts, ImageName: "img"}
+	job := domain.Job{
+		Type:       domain.JobType_WEBHOOK,
+		TenantOpts: tenantOpts,
+		Event:      &event,
+		WebhookUrl: "http://cms/hook",
+		Attempts:   2,
+	}
+
+	t.Run("the event is delivered with the current webhook configuration", func(t *testing.T) {
+		webhook := domain.WebhookConfig{Url: "http://cms/hook", Secret: "rotated"}
+
+		svc, mocks := newTestImageService()
+
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).
+			Return(domain.TenantConfig{Webhooks: []domain.WebhookConfig{webhook}}, nil)
+		mocks.webhook.On("Deliver", context.Background(), webhook, event, 2).Return(appsvc.ErrWebhookRejected)
+
+		err := svc.HandleJob(context.Background(), job)
+
+		// the failure is left to the job queue to retry
+		assert.ErrorIs(t, err, appsvc.ErrWebhookRejected)
+		mocks.webhook.AssertExpectations(t)
+	})
+	t.Run("events of removed webhooks are dropped", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+
+		err := svc.HandleJob(context.Background(), job)
+
+		assert.NoError(t, err)
+		mocks.webhook.AssertNotCalled(t, "Deliver")
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	job := domain.Job{Id: "0123", Type: domain.JobType_PURGE, TenantOpts: tenantOpts, State: domain.JobState_RUNNING}
+
+	testCases := []struct {
+		name        string
+		tenantOpts  domain.TenantOpts
+		expectedErr error
+	}{
+		{name: "a job of the tenant", tenantOpts: tenantOpts},
+		{name: "a job of another tenant", tenantOpts: domain.TenantOpts{TenantCode: "tenant", OrgCode: "other"},
+			expectedErr: ErrJobNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, mocks := newTestImageService()
+
+			mocks.jobQueue.On("GetJob", "0123").Return(job, nil)
+
+			res, err := svc.GetJob(context.Background(), "0123", tc.tenantOpts)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, job, res)
+		})
+	}
+}
+
+func TestGetEagerStatus(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("the recorded status is returned", func(t *testing.T) {
+		status := domain.EagerStatus{State: domain.JobState_RUNNING, Total: 3, Completed: 1}
+		content, _ := json.Marshal(status)
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentAttachment", "testimagename1", "eager", tenantOpts).Return(content, nil)
+
+		res, err := svc.GetEagerStatus(context.Background(), "testimagename1", tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, status, res)
+	})
+	t.Run("an image uploaded without eager transformations", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentAttachment", "testimagename1", "eager", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+
+		_, err := svc.GetEagerStatus(context.Background(), "testimagename1", tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNoEagerStatus)
+	})
+}
+
+func TestGetImage(t *testing.T) {
+	t.Run("an image can be fetched if it exists", func(t *testing.T) {
+		testCases := []struct {
+			opts                     GetImageOpts
+			isParentNeedsToBeFetched bool
+			image                    []byte
+		}{
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetFormat(domain.ImageType_JPEG).
+					SetWidth(200).
+					SetHeight(300),
+				isParentNeedsToBeFetched: false,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetFormat(domain.ImageType_JPEG).
+					SetWidth(200).
+					SetAr(domain.AR{Width: 1, Height: 1}),
+				isParentNeedsToBeFetched: false,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetFormat(domain.ImageType_JPEG).
+					SetHeight(200).
+					SetAr(domain.AR{Width: 1, Height: 1}),
+				isParentNeedsToBeFetched: false,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetFormat(domain.ImageType_JPEG).
+					SetWidth(200).
+					SetHeight(300).
+					SetAr(domain.AR{Width: 1, Height: 1}),
+				isParentNeedsToBeFetched: false,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetWidth(200).
+					SetHeight(300).
+					SetAr(domain.AR{Width: 1, Height: 1}),
+				isParentNeedsToBeFetched: true,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetFormat(domain.ImageType_JPEG),
+				isParentNeedsToBeFetched: true,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetWidth(300).
+					SetFormat(domain.ImageType_JPEG),
+				isParentNeedsToBeFetched: true,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetHeight(300).
+					SetFormat(domain.ImageType_JPEG),
+				isParentNeedsToBeFetched: true,
+				image:                    []byte("this is an image"),
+			},
+			{
+				opts: NewServiceGetImageOpts().
+					SetName("testimagename1").
+					SetAr(domain.AR{Width: 1, Height: 1}).
+					SetFormat(domain.ImageType_JPEG),
+				isParentNeedsToBeFetched: true,
+				image:                    []byte("this is an image"),
+			},
+		}
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		for _, tc := range testCases {
+			parentImage := []byte("this is the parent image")
+			parentImageSpec := domain.ImageSpec{
+				Width:  500,
+				Height: 500,
+				Format: domain.ImageType_AVIF,
+			}
+			var childImageFormat domain.ImageType
+			if tc.opts.Type == domain.ImageType_AUTO {
+				childImageFormat = parentImageSpec.Format
+			} else {
+				childImageFormat = tc.opts.Type
+			}
+
+			normalizedWidth, normalizedHeight := determineDimensions(tc.opts, parentImageSpec.Width, parentImageSpec.Height)
+
+			mocks.storage.On("GetParentImage", tc.opts.Name, tc.opts.TenantOpts).
+				Return(parentImage, nil)
+
+			mocks.processor.On("GetSpec", parentImage).Return(parentImageSpec, nil)
+
+			mocks.tenantConfig.On("GetTenantConfig", tc.opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+
+			mocks.storage.On("GetChildImage", tc.opts.Name,
+				domain.ImageSpec{Width: normalizedWidth, Height: normalizedHeight, Format: childImageFormat},
+				tc.opts.TenantOpts).Return(tc.image, nil)
+
+			fetchedImage, err := svc.GetImage(context.Background(), tc.opts)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.image, fetchedImage)
+
+			if tc.isParentNeedsToBeFetched {
+				mocks.storage.AssertExpectations(t)
+				mocks.processor.AssertExpectations(t)
+			} else {
+				mocks.storage.AssertNotCalled(t, "GetParentImage", tc.opts.Name, tc.opts.TenantOpts)
+				mocks.processor.AssertNotCalled(t, "GetSpec", parentImage)
+			}
+		}
+	})
+}
+
+func TestGetImageWithWatermark(t *testing.T) {
+	t.Run("an enforced watermark is applied to a newly built child image", func(t *testing.T) {
+		tenantOpts := domain.TenantOpts{TenantCode: "tnt", OrgCode: "org"}
+		watermark := &domain.WatermarkConfig{
+			ImageName:         "logo",
+			Gravity:           domain.Gravity_SOUTH_EAST,
+			Margin:            10,
+			Opacity:           0.5,
+			Scale:             0.2,
+			EnforceAboveWidth: 800,
+		}
+		opts := NewServiceGetImageOpts().
+			SetName("testimagename1").
+			SetFormat(domain.ImageType_JPEG).
+			SetWidth(1000).
+			SetHeight(1000).
+			SetTenantOpts(tenantOpts)
+		targetSpec := domain.ImageSpec{
+			Width:   1000,
+			Height:  1000,
+			Format:  domain.ImageType_JPEG,
+			Variant: variantKey(watermark.String()),
+		}
+		parentImage := []byte("parent")
+		resizedImage := []byte("resized")
+		logo := []byte("logo")
+		watermarkedImage := []byte("watermarked")
+		targetImage := []byte("target")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{Watermark: watermark}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 500, Height: 500, Format: domain.ImageType_PNG}, nil)
+		mocks.processor.On("Resize", parentImage, 2.0).Return(resizedImage, nil)
+		mocks.processor.On("GetSpec", resizedImage).
+			Return(domain.ImageSpec{Width: 1000, Height: 1000, Format: domain.ImageType_PNG}, nil)
+		mocks.storage.On("GetParentImage", watermark.ImageName, tenantOpts).Return(logo, nil)
+		mocks.processor.On("Overlay", resizedImage, logo, watermark.OverlayOpts()).Return(watermarkedImage, nil)
+		mocks.processor.On("Export", watermarkedImage, domain.ImageType_JPEG).Return(targetImage, nil)
+		mocks.storage.On("StoreChildImage", targetImage, opts.Name, targetSpec, tenantOpts).Return(nil)
+
+		image, err := svc.GetImage(context.Background(), opts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, targetImage, image)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+}
+
+func TestGetImageWithText(t *testing.T) {
+	t.Run("an unsupported font is reported as such", func(t *testing.T) {
+		textOpts := domain.TextOpts{Text: "$9.99", Font: "Missing", Size: 32}
+		opts := NewServiceGetImageOpts().
+			SetName("testimagename1").
+			SetFormat(domain.ImageType_WEBP).
+			SetWidth(500).
+			SetHeight(500).
+			SetText(textOpts)
+		targetSpec := domain.ImageSpec{
+			Width:   500,
+			Height:  500,
+			Format:  domain.ImageType_WEBP,
+			Variant: variantKey(textOpts.String()),
+		}
+		parentImage := []byte("parent")
+		resizedImage := []byte("resized")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 500, Height: 500, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Resize", parentImage, 1.0).Return(resizedImage, nil)
+		mocks.processor.On("GetSpec", resizedImage).
+			Return(domain.ImageSpec{Width: 500, Height: 500, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("DrawText", resizedImage, textOpts).Return([]byte(nil), appsvc.ErrUnsupportedFont)
+
+		_, err := svc.GetImage(context.Background(), opts)
+
+		assert.ErrorIs(t, err, ErrUnsupportedFont)
+		mocks.storage.AssertNotCalled(t, "StoreChildImage")
+	})
+}
+
+func TestGetImageWithFrame(t *testing.T) {
+	t.Run("a frame out of range is reported as an invalid frame", func(t *testing.T) {
+		opts := NewServiceGetImageOpts().
+			SetName("testimagename1").
+			SetFormat(domain.ImageType_WEBP).
+			SetWidth(100).
+			SetHeight(100).
+			SetFrame(12)
+		targetSpec := domain.ImageSpec{
+			Width:   100,
+			Height:  100,
+			Format:  domain.ImageType_WEBP,
+			Variant: variantKey("frame:12"),
+		}
+		parentImage := []byte("animated parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 200, Height: 200, Format: domain.ImageType_GIF}, nil)
+		mocks.processor.On("ExtractFrame", parentImage, 12).Return([]byte(nil), appsvc.ErrFrameOutOfRange)
+
+		_, err := svc.GetImage(context.Background(), opts)
+
+		assert.ErrorIs(t, err, ErrInvalidFrame)
+		mocks.processor.AssertNotCalled(t, "Resize")
+	})
+}
+
+func TestGetCompressedImage(t *testing.T) {
+	opts := NewServiceGetImageOpts().
+		SetName("testimagename1").
+		SetFormat(domain.ImageType_JPEG).
+		SetWidth(100).
+		SetHeight(100).
+		SetMaxBytes(20000)
+	targetSpec := domain.ImageSpec{
+		Width:   100,
+		Height:  100,
+		Format:  domain.ImageType_JPEG,
+		Variant: variantKey("maxBytes:20000"),
+	}
+	compression := domain.Compression{Quality: 72, Width: 100, Height: 100}
+	compressionContent, _ := json.Marshal(compression)
+
+	t.Run("the image is compressed into the budget and the compression is cached", func(t *testing.T) {
+		parentImage := []byte("parent")
+		resizedImage := []byte("resized")
+		compressedImage := []byte("compressed")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 200, Height: 200, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Resize", parentImage, 0.5).Return(resizedImage, nil)
+		mocks.processor.On("GetSpec", resizedImage).
+			Return(domain.ImageSpec{Width: 100, Height: 100, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Compress", resizedImage, domain.ImageType_JPEG, 20000).
+			Return(compressedImage, compression, nil)
+		mocks.storage.On("StoreChildImage", compressedImage, opts.Name, targetSpec, opts.TenantOpts).Return(nil)
+		mocks.storage.On("StoreParentAttachment", opts.Name, compressionKey(targetSpec), compressionContent, opts.TenantOpts).
+			Return(nil)
+
+		image, res, err := svc.GetCompressedImage(context.Background(), opts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, compressedImage, image)
+		assert.Equal(t, compression, res)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+		mocks.processor.AssertNotCalled(t, "Export")
+	})
+	t.Run("the compression of a cached image is reported", func(t *testing.T) {
+		childImage := []byte("child")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return(childImage, nil)
+		mocks.storage.On("GetParentAttachment", opts.Name, compressionKey(targetSpec), opts.TenantOpts).
+			Return(compressionContent, nil)
+
+		image, res, err := svc.GetCompressedImage(context.Background(), opts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, childImage, image)
+		assert.Equal(t, compression, res)
+	})
+	t.Run("a budget which can not be met", func(t *testing.T) {
+		parentImage := []byte("parent")
+		resizedImage := []byte("resized")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 200, Height: 200, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Resize", parentImage, 0.5).Return(resizedImage, nil)
+		mocks.processor.On("GetSpec", resizedImage).
+			Return(domain.ImageSpec{Width: 100, Height: 100, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Compress", resizedImage, domain.ImageType_JPEG, 20000).
+			Return([]byte(nil), domain.Compression{}, appsvc.ErrBudgetUnreachable)
+
+		_, _, err := svc.GetCompressedImage(context.Background(), opts)
+
+		assert.ErrorIs(t, err, ErrBudgetUnreachable)
+		mocks.storage.AssertNotCalled(t, "StoreChildImage")
+	})
+}
+
+func TestDerivativeQuota(t *testing.T) {
+	opts := NewServiceGetImageOpts().
+		SetName("testimagename1").
+		SetFormat(domain.ImageType_JPEG).
+		SetWidth(100).
+		SetHeight(100).
+		SetMaxBytes(20000)
+	targetSpec := domain.ImageSpec{
+		Width:   100,
+		Height:  100,
+		Format:  domain.ImageType_JPEG,
+		Variant: variantKey("maxBytes:20000"),
+	}
+	compression := domain.Compression{Quality: 72, Width: 100, Height: 100}
+	compressionContent, _ := json.Marshal(compression)
+	usage := domain.Usage{
+		Originals:   domain.UsageCount{Objects: 2, Bytes: 100},
+		Derivatives: domain.UsageCount{Objects: 4, Bytes: 50},
+	}
+
+	testCases := []struct {
+		name    string
+		quota   domain.QuotaConfig
+		cached  bool
+		evicted bool
+	}{
+		{name: "a derivative within the quota is cached", quota: domain.QuotaConfig{MaxBytes: 200, MaxObjects: 10},
+			cached: true},
+		{name: "a derivative past the byte quota is served uncached", quota: domain.QuotaConfig{MaxBytes: 155}},
+		{name: "a derivative past the object quota is served uncached", quota: domain.QuotaConfig{MaxObjects: 6}},
+		{name: "derivatives are evicted to make room", quota: domain.QuotaConfig{MaxBytes: 155, EvictDerivatives: true},
+			cached: true, evicted: true},
+		{name: "evicted derivatives are left out of the object quota",
+			quota: domain.QuotaConfig{MaxObjects: 3, EvictDerivatives: true}, cached: true},
+		{name: "a derivative past the quota of the originals is served uncached",
+			quota: domain.QuotaConfig{MaxBytes: 105, EvictDerivatives: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parentImage := []byte("parent")
+			resizedImage := []byte("resized")
+			compressedImage := []byte("compressed")
+
+			svc, mocks := newTestImageService()
+
+			mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+				Return([]byte(nil), appsvc.ErrNoMatchingFile)
+			mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{Quota: &tc.quota}, nil)
+			mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+			mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+			mocks.processor.On("GetSpec", parentImage).
+				Return(domain.ImageSpec{Width: 200, Height: 200, Format: domain.ImageType_JPEG}, nil)
+			mocks.processor.On("Resize", parentImage, 0.5).Return(resizedImage, nil)
+			mocks.processor.On("GetSpec", resizedImage).
+				Return(domain.ImageSpec{Width: 100, Height: 100, Format: domain.ImageType_JPEG}, nil)
+			mocks.processor.On("Compress", resizedImage, domain.ImageType_JPEG, 20000).
+				Return(compressedImage, compression, nil)
+			mocks.storage.On("Usage", opts.TenantOpts).Return(usage, nil)
+			mocks.storage.On("ListParentImages", opts.TenantOpts).Return([]string{"a", "b"}, nil)
+			mocks.storage.On("DeleteChildImages", "a", opts.TenantOpts).Return(domain.UsageCount{Objects: 4, Bytes: 50}, nil)
+			mocks.storage.On("StoreChildImage", compressedImage, opts.Name, targetSpec, opts.TenantOpts).Return(nil)
+			mocks.storage.On("StoreParentAttachment", opts.Name, compressionKey(targetSpec), compressionContent, opts.TenantOpts).
+				Return(nil)
+
+			image, _, err := svc.GetCompressedImage(context.Background(), opts)
+
+			assert.NoError(t, err)
+			assert.Equal(t, compressedImage, image)
+			if tc.cached {
+				mocks.storage.AssertCalled(t, "StoreChildImage", compressedImage, opts.Name, targetSpec, opts.TenantOpts)
+			} else {
+				mocks.storage.AssertNotCalled(t, "StoreChildImage", compressedImage, opts.Name, targetSpec, opts.TenantOpts)
+			}
+			if tc.evicted {
+				mocks.storage.AssertCalled(t, "DeleteChildImages", "a", opts.TenantOpts)
+				mocks.storage.AssertNotCalled(t, "DeleteChildImages", "b", opts.TenantOpts)
+			} else {
+				mocks.storage.AssertNotCalled(t, "DeleteChildImages", "a", opts.TenantOpts)
+			}
+		})
+	}
+}
+
+func TestGetImageFromSvg(t *testing.T) {
+	t.Run("an svg parent is rasterized at the requested density and served as png", func(t *testing.T) {
+		opts := NewServiceGetImageOpts().
+			SetName("testimagename1").
+			SetWidth(300).
+			SetDensity(144)
+		parentImage := []byte("<svg/>")
+		rasterizedImage := []byte("rasterized")
+		childImage := []byte("child")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 100, Height: 50, Format: domain.ImageType_SVG}, nil)
+		mocks.processor.On("Rasterize", parentImage, 0, 144).Return(rasterizedImage, nil)
+		mocks.processor.On("GetSpec", rasterizedImage).
+			Return(domain.ImageSpec{Width: 200, Height: 100, Format: domain.ImageType_PNG}, nil)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name,
+			domain.ImageSpec{Width: 300, Height: 150, Format: domain.ImageType_PNG, Variant: variantKey("density:144")},
+			opts.TenantOpts).Return(childImage, nil)
+
+		image, err := svc.GetImage(context.Background(), opts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, childImage, image)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+}
+
+func TestGetImageFromPdf(t *testing.T) {
+	t.Run("the requested page of a pdf is rendered into the requested format", func(t *testing.T) {
+		opts := NewServiceGetImageOpts().
+			SetName("invoice").
+			SetFormat(domain.ImageType_WEBP).
+			SetWidth(200).
+			SetPage(2).
+			SetDensity(150)
+		targetSpec := domain.ImageSpec{
+			Width:   200,
+			Height:  283,
+			Format:  domain.ImageType_WEBP,
+			Variant: variantKey("page:2", "density:150"),
+		}
+		parentImage := []byte("%PDF-1.7")
+		rasterizedImage := []byte("page 2")
+		resizedImage := []byte("resized")
+		targetImage := []byte("target")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", testifymock.Anything).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", opts.Name, opts.TenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 595, Height: 842, Format: domain.ImageType_PDF}, nil)
+		mocks.processor.On("Rasterize", parentImage, 1, 150).Return(rasterizedImage, nil)
+		mocks.processor.On("GetSpec", rasterizedImage).
+			Return(domain.ImageSpec{Width: 1240, Height: 1754, Format: domain.ImageType_PNG}, nil)
+		mocks.tenantConfig.On("GetTenantConfig", opts.TenantOpts).Return(domain.TenantConfig{}, nil)
+		mocks.storage.On("GetChildImage", opts.Name, targetSpec, opts.TenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.processor.On("Resize", rasterizedImage, 283.0/1754.0).Return(resizedImage, nil)
+		mocks.processor.On("GetSpec", resizedImage).
+			Return(domain.ImageSpec{Width: 200, Height: 283, Format: domain.ImageType_PNG}, nil)
+		mocks.processor.On("Export", resizedImage, domain.ImageType_WEBP).Return(targetImage, nil)
+		mocks.storage.On("StoreChildImage", targetImage, opts.Name, targetSpec, opts.TenantOpts).Return(nil)
+
+		image, err := svc.GetImage(context.Background(), opts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, targetImage, image)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+}
+
+func TestGetPlaceholders(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("cached placeholders are served without touching the parent image", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "placeholder-blurhash", tenantOpts).
+			Return([]byte("LEHV6nWB2yk8pyo0adR*.7kCMdnj"), nil)
+
+		placeholders, err := svc.GetPlaceholders(context.Background(), "testimagename1",
+			[]domain.PlaceholderType{domain.PlaceholderType_BLURHASH}, domain.Access{}, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[domain.PlaceholderType]string{
+			domain.PlaceholderType_BLURHASH: "LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+		}, placeholders)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("missing placeholders are computed from a thumbnail and cached", func(t *testing.T) {
+		parentImage := []byte("parent")
+		lqipThumbnail := []byte("lqip")
+		lqipPreview := []byte("preview")
+		blurHashThumbnail := []byte("blurhash")
+		pixels := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+		blurHash, _ := domain.EncodeBlurHash(pixels, blurHashComponentX, blurHashComponentY)
+		lqip := "data:image/webp;base64," + base64.StdEncoding.EncodeToString(lqipPreview)
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "placeholder-lqip", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "placeholder-blurhash", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil).Once()
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 640, Height: 320, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("Resize", parentImage, 16.0/640.0).Return(lqipThumbnail, nil)
+		mocks.processor.On("Export", lqipThumbnail, domain.ImageType_WEBP).Return(lqipPreview, nil)
+		mocks.processor.On("Resize", parentImage, 32.0/640.0).Return(blurHashThumbnail, nil)
+		mocks.processor.On("GetPixels", blurHashThumbnail).Return(pixels, nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "placeholder-lqip", []byte(lqip), tenantOpts).
+			Return(nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "placeholder-blurhash", []byte(blurHash), tenantOpts).
+			Return(nil)
+
+		placeholders, err := svc.GetPlaceholders(context.Background(), "testimagename1",
+			[]domain.PlaceholderType{domain.PlaceholderType_LQIP, domain.PlaceholderType_BLURHASH}, domain.Access{}, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[domain.PlaceholderType]string{
+			domain.PlaceholderType_LQIP:     lqip,
+			domain.PlaceholderType_BLURHASH: blurHash,
+		}, placeholders)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("placeholders of a missing image", func(t *testing.T) {
+		svc, mocks := newTestImageService()
 
-		imgId, err := svc.Upload(ctx, img, tenantOpts)
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "missing", "placeholder-thumbhash", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "missing", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+
+		_, err := svc.GetPlaceholders(context.Background(), "missing",
+			[]domain.PlaceholderType{domain.PlaceholderType_THUMBHASH}, domain.Access{}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("placeholders of a private image are denied to anonymous requests", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", "testimagename1", "visibility", tenantOpts).Return([]byte("private"), nil)
+
+		_, err := svc.GetPlaceholders(context.Background(), "testimagename1",
+			[]domain.PlaceholderType{domain.PlaceholderType_BLURHASH}, domain.Access{}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrAccessDenied)
+		mocks.storage.AssertNotCalled(t, "GetParentAttachment", "testimagename1", "placeholder-blurhash", tenantOpts)
+	})
+}
+
+func TestGetColors(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	analysis := domain.ColorAnalysis{
+		Dominant: domain.Color{R: 255, A: 255},
+		Palette: []domain.PaletteColor{
+			{Color: domain.Color{R: 255, A: 255}, Percentage: 70},
+			{Color: domain.Color{B: 255, A: 255}, Percentage: 30},
+		},
+	}
+	content, _ := json.Marshal(analysis)
+
+	t.Run("colors are analyzed once and cached", func(t *testing.T) {
+		parentImage := []byte("parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "colors-2", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 640, Height: 320, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("AnalyzeColors", parentImage, 2).Return(analysis, nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "colors-2", content, tenantOpts).Return(nil)
+
+		res, err := svc.GetColors(context.Background(), "testimagename1", 2, domain.Access{}, tenantOpts)
 
 		assert.NoError(t, err)
-		assert.Equal(t, imgName, imgId)
-		mockStorageSvc.AssertExpectations(t)
-		mockImageProcessingSvc.AssertExpectations(t)
+		assert.Equal(t, analysis, res)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("cached colors", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "colors-2", tenantOpts).Return(content, nil)
+
+		res, err := svc.GetColors(context.Background(), "testimagename1", 2, domain.Access{}, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, analysis, res)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("fully transparent images have no colors", func(t *testing.T) {
+		parentImage := []byte("parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "colors-2", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 64, Height: 64, Format: domain.ImageType_PNG}, nil)
+		mocks.processor.On("AnalyzeColors", parentImage, 2).
+			Return(domain.ColorAnalysis{}, domain.ErrNoOpaquePixels)
+
+		_, err := svc.GetColors(context.Background(), "testimagename1", 2, domain.Access{}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNoColors)
+	})
+	t.Run("colors of a signed image are denied without a signature", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", "testimagename1", "visibility", tenantOpts).Return([]byte("signed"), nil)
+
+		_, err := svc.GetColors(context.Background(), "testimagename1", 2,
+			domain.Access{Principal: &domain.Principal{TenantOpts: tenantOpts}}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrAccessDenied)
+		mocks.storage.AssertNotCalled(t, "GetParentAttachment", "testimagename1", "colors-2", tenantOpts)
 	})
 }
 
-func TestGetImage(t *testing.T) {
-	t.Run("an image can be fetched if it exists", func(t *testing.T) {
-		testCases := []struct {
-			opts                     GetImageOpts
-			isParentNeedsToBeFetched bool
-			image                    []byte
-		}{
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetFormat(domain.ImageType_JPEG).
-					SetWidth(200).
-					SetHeight(300),
-				isParentNeedsToBeFetched: false,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetFormat(domain.ImageType_JPEG).
-					SetWidth(200).
-					SetAr(domain.AR{Width: 1, Height: 1}),
-				isParentNeedsToBeFetched: false,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetFormat(domain.ImageType_JPEG).
-					SetHeight(200).
-					SetAr(domain.AR{Width: 1, Height: 1}),
-				isParentNeedsToBeFetched: false,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetFormat(domain.ImageType_JPEG).
-					SetWidth(200).
-					SetHeight(300).
-					SetAr(domain.AR{Width: 1, Height: 1}),
-				isParentNeedsToBeFetched: false,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetWidth(200).
-					SetHeight(300).
-					SetAr(domain.AR{Width: 1, Height: 1}),
-				isParentNeedsToBeFetched: true,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetFormat(domain.ImageType_JPEG),
-				isParentNeedsToBeFetched: true,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetWidth(300).
-					SetFormat(domain.ImageType_JPEG),
-				isParentNeedsToBeFetched: true,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetHeight(300).
-					SetFormat(domain.ImageType_JPEG),
-				isParentNeedsToBeFetched: true,
-				image:                    []byte("this is an image"),
-			},
-			{
-				opts: NewServiceGetImageOpts().
-					SetName("testimagename1").
-					SetAr(domain.AR{Width: 1, Height: 1}).
-					SetFormat(domain.ImageType_JPEG),
-				isParentNeedsToBeFetched: true,
-				image:                    []byte("this is an image"),
+func TestGetSrcset(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("variant heights follow the aspect ratio", func(t *testing.T) {
+		parentImage := []byte("parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 2000, Height: 1500, Format: domain.ImageType_JPEG}, nil)
+
+		srcset, err := svc.GetSrcset(context.Background(), SrcsetOpts{
+			TenantOpts: tenantOpts,
+			Name:       "testimagename1",
+			Widths:     []int{320, 640},
+			Ar:         &domain.AR{Width: 16, Height: 9},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Srcset{
+			Variants: []domain.ImageVariant{{Width: 320, Height: 180}, {Width: 640, Height: 360}},
+			Formats:  []domain.ImageType{domain.ImageType_AVIF, domain.ImageType_WEBP},
+			Fallback: domain.ImageType_JPEG,
+		}, srcset)
+	})
+	t.Run("the original aspect ratio is kept and webp parents fall back to webp", func(t *testing.T) {
+		parentImage := []byte("parent")
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 2000, Height: 1500, Format: domain.ImageType_WEBP}, nil)
+
+		srcset, err := svc.GetSrcset(context.Background(), SrcsetOpts{
+			TenantOpts: tenantOpts,
+			Name:       "testimagename1",
+			Widths:     []int{400},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.Srcset{
+			Variants: []domain.ImageVariant{{Width: 400, Height: 300}},
+			Formats:  []domain.ImageType{domain.ImageType_AVIF},
+			Fallback: domain.ImageType_WEBP,
+		}, srcset)
+	})
+	t.Run("private images are planned with a signature or for a principal of the tenant", func(t *testing.T) {
+		parentImage := []byte("parent")
+		expires := time.Unix(1700000000, 0)
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", "testimagename1", "visibility", tenantOpts).Return([]byte("private"), nil)
+		mocks.urlSigner.On("Verify", "testimagename1", tenantOpts, expires, "sig").Return(true)
+		mocks.urlSigner.On("Sign", "testimagename1", tenantOpts, testifymock.Anything).Return("issued")
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 2000, Height: 1500, Format: domain.ImageType_JPEG}, nil)
+		opts := SrcsetOpts{TenantOpts: tenantOpts, Name: "testimagename1", Widths: []int{400}}
+
+		_, err := svc.GetSrcset(context.Background(), opts)
+		assert.ErrorIs(t, err, ErrAccessDenied)
+
+		opts.Access = domain.Access{Principal: &domain.Principal{TenantOpts: domain.TenantOpts{TenantCode: "other", OrgCode: "org"}}}
+		_, err = svc.GetSrcset(context.Background(), opts)
+		assert.ErrorIs(t, err, ErrAccessDenied)
+
+		opts.Access = domain.Access{Principal: &domain.Principal{TenantOpts: tenantOpts}}
+		srcset, err := svc.GetSrcset(context.Background(), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "issued", srcset.Signature, "the variant urls are signed for the principal")
+		assert.WithinDuration(t, time.Now().Add(time.Hour), srcset.Expires, time.Minute)
+
+		opts.Access = domain.Access{Expires: expires, Signature: "sig"}
+		srcset, err = svc.GetSrcset(context.Background(), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, []domain.ImageVariant{{Width: 400, Height: 300}}, srcset.Variants)
+		assert.Equal(t, "sig", srcset.Signature)
+		assert.Equal(t, expires, srcset.Expires)
+	})
+	t.Run("prerendering is queued for requests with credentials only", func(t *testing.T) {
+		parentImage := []byte("parent")
+		ar := &domain.AR{Width: 1, Height: 1}
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", "testimagename1", "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetSpec", parentImage).
+			Return(domain.ImageSpec{Width: 2000, Height: 1500, Format: domain.ImageType_WEBP}, nil)
+		job := domain.Job{
+			Type:       domain.JobType_PRERENDER,
+			TenantOpts: tenantOpts,
+			Images:     []string{"testimagename1"},
+			Eager: []domain.EagerTransformation{
+				{Width: 320, Ar: ar, Format: domain.ImageType_AVIF},
+				{Width: 640, Ar: ar, Format: domain.ImageType_AVIF},
+				{Width: 320, Ar: ar, Format: domain.ImageType_WEBP},
+				{Width: 640, Ar: ar, Format: domain.ImageType_WEBP},
 			},
 		}
-		mockStorageSvc := new(mock.ImageStorageService)
-		mockImageProcessingSvc := new(mock.ImageProcessingService)
+		mocks.jobQueue.On("Enqueue", job).Return(job, nil).Once()
+		opts := SrcsetOpts{TenantOpts: tenantOpts, Name: "testimagename1", Widths: []int{320, 640}, Ar: ar, Prerender: true}
 
-		for _, tc := range testCases {
-			parentImage := []byte("this is the parent image")
-			parentImageSpec := domain.ImageSpec{
-				Width:  500,
-				Height: 500,
-				Format: domain.ImageType_AVIF,
-			}
-			var childImageFormat domain.ImageType
-			if tc.opts.Type == domain.ImageType_AUTO {
-				childImageFormat = parentImageSpec.Format
-			} else {
-				childImageFormat = tc.opts.Type
-			}
+		_, err := svc.GetSrcset(context.Background(), opts)
+		assert.NoError(t, err)
+		mocks.jobQueue.AssertNotCalled(t, "Enqueue", testifymock.Anything)
 
-			normalizedWidth, normalizedHeight := determineDimensions(tc.opts, parentImageSpec.Width, parentImageSpec.Height)
+		opts.Access = domain.Access{Principal: &domain.Principal{TenantOpts: tenantOpts}}
+		_, err = svc.GetSrcset(context.Background(), opts)
+		assert.NoError(t, err)
+		mocks.jobQueue.AssertExpectations(t)
+	})
+}
 
-			mockStorageSvc.On("GetParentImage", tc.opts.Name, tc.opts.TenantOpts).
-				Return(parentImage, nil)
+func TestGetMetadata(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	metadata := domain.ImageMetadata{
+		Width:       4000,
+		Height:      3000,
+		Format:      domain.ImageType_JPEG,
+		Orientation: 6,
+		ColorSpace:  "srgb",
+		BitDepth:    8,
+		Pages:       1,
+		Frames:      1,
+		CameraMake:  "Canon",
+		HasExif:     true,
+	}
+	content, _ := json.Marshal(metadata)
 
-			mockImageProcessingSvc.On("GetSpec", parentImage).Return(parentImageSpec, nil)
+	t.Run("metadata is read from the original upload and cached", func(t *testing.T) {
+		parentImage := []byte("parent")
 
-			mockStorageSvc.On("GetChildImage", tc.opts.Name, childImageFormat, normalizedWidth, normalizedHeight,
-				tc.opts.TenantOpts).Return(tc.image, nil)
+		svc, mocks := newTestImageService()
 
-			svc := NewImageService(mockStorageSvc, mockImageProcessingSvc)
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "metadata", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "testimagename1", tenantOpts).Return(parentImage, nil)
+		mocks.processor.On("GetMetadata", parentImage).Return(metadata, nil)
+		mocks.storage.On("StoreParentAttachment", "testimagename1", "metadata", content, tenantOpts).Return(nil)
 
-			fetchedImage, err := svc.GetImage(context.Background(), tc.opts)
+		res, err := svc.GetMetadata(context.Background(), "testimagename1", domain.Access{}, tenantOpts)
 
-			assert.NoError(t, err)
-			assert.Equal(t, tc.image, fetchedImage)
+		assert.NoError(t, err)
+		assert.Equal(t, metadata, res)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("cached metadata", func(t *testing.T) {
+		svc, mocks := newTestImageService()
 
-			if tc.isParentNeedsToBeFetched {
-				mockStorageSvc.AssertExpectations(t)
-				mockImageProcessingSvc.AssertExpectations(t)
-			} else {
-				mockStorageSvc.AssertNotCalled(t, "GetParentImage", tc.opts.Name, tc.opts.TenantOpts)
-				mockImageProcessingSvc.AssertNotCalled(t, "GetSpec", parentImage)
-			}
-		}
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "testimagename1", "metadata", tenantOpts).Return(content, nil)
+
+		res, err := svc.GetMetadata(context.Background(), "testimagename1", domain.Access{}, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, metadata, res)
+	})
+	t.Run("metadata of a missing image", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", testifymock.Anything, "visibility", tenantOpts).Return([]byte("public"), nil)
+		mocks.storage.On("GetParentAttachment", "missing", "metadata", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "missing", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+
+		_, err := svc.GetMetadata(context.Background(), "missing", domain.Access{}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("metadata of a private image is denied to other tenants", func(t *testing.T) {
+		otherTenant := domain.TenantOpts{TenantCode: "other", OrgCode: "org"}
+
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentSetting", "testimagename1", "visibility", tenantOpts).Return([]byte("private"), nil)
+
+		_, err := svc.GetMetadata(context.Background(), "testimagename1",
+			domain.Access{Principal: &domain.Principal{TenantOpts: otherTenant}}, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrAccessDenied)
+		mocks.storage.AssertNotCalled(t, "GetParentAttachment", "testimagename1", "metadata", tenantOpts)
+	})
+}
+
+func TestFindSimilar(t *testing.T) {
+	tenantOpts := domain.TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+
+	t.Run("images within the distance are returned closest first", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("ListParentImages", tenantOpts).
+			Return([]string{"original", "recompressed", "cropped", "unrelated", "legacy"}, nil)
+		mocks.storage.On("GetParentAttachment", "original", "phash", tenantOpts).
+			Return([]byte("00000000000000ff"), nil)
+		mocks.storage.On("GetParentAttachment", "recompressed", "phash", tenantOpts).
+			Return([]byte("00000000000000fe"), nil)
+		mocks.storage.On("GetParentAttachment", "cropped", "phash", tenantOpts).
+			Return([]byte("000000000000f00f"), nil)
+		mocks.storage.On("GetParentAttachment", "unrelated", "phash", tenantOpts).
+			Return([]byte("ffffffffffffff00"), nil)
+		// images uploaded before hashing get hashed on demand
+		legacyImage := []byte("legacy")
+		mocks.storage.On("GetParentAttachment", "legacy", "phash", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "legacy", tenantOpts).Return(legacyImage, nil)
+		mocks.processor.On("GetSpec", legacyImage).
+			Return(domain.ImageSpec{Width: 10, Height: 10, Format: domain.ImageType_JPEG}, nil)
+		mocks.processor.On("PerceptualHash", legacyImage).Return(domain.PerceptualHash(0xff), nil)
+		mocks.storage.On("StoreParentAttachment", "legacy", "phash", []byte("00000000000000ff"), tenantOpts).
+			Return(nil)
+
+		similarImages, err := svc.FindSimilar(context.Background(), "original", 8, tenantOpts)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []domain.SimilarImage{
+			{Name: "legacy", Distance: 0},
+			{Name: "recompressed", Distance: 1},
+			{Name: "cropped", Distance: 8},
+		}, similarImages)
+		mocks.storage.AssertExpectations(t)
+		mocks.processor.AssertExpectations(t)
+	})
+	t.Run("similar images of a missing image", func(t *testing.T) {
+		svc, mocks := newTestImageService()
+
+		mocks.storage.On("GetParentAttachment", "missing", "phash", tenantOpts).
+			Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.storage.On("GetParentImage", "missing", tenantOpts).Return([]byte(nil), appsvc.ErrNoMatchingFile)
+		mocks.tenantConfig.On("GetTenantConfig", tenantOpts).Return(domain.TenantConfig{}, nil)
+
+		_, err := svc.FindSimilar(context.Background(), "missing", 8, tenantOpts)
+
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 }
 
+func TestNeedsRasterization(t *testing.T) {
+	testCases := []struct {
+		opts     GetImageOpts
+		format   domain.ImageType
+		expected bool
+	}{
+		{opts: NewServiceGetImageOpts(), format: domain.ImageType_SVG, expected: true},
+		{opts: NewServiceGetImageOpts(), format: domain.ImageType_TIFF, expected: false},
+		{opts: NewServiceGetImageOpts().SetPage(2), format: domain.ImageType_TIFF, expected: true},
+		{opts: NewServiceGetImageOpts().SetPage(2), format: domain.ImageType_JPEG, expected: false},
+		{opts: NewServiceGetImageOpts().SetDensity(300), format: domain.ImageType_HEIC, expected: false},
+		{opts: NewServiceGetImageOpts(), format: domain.ImageType_PDF, expected: true},
+		{opts: NewServiceGetImageOpts().SetPage(3), format: domain.ImageType_PDF, expected: true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, needsRasterization(tc.opts, tc.format), tc.format)
+	}
+}
+
+func TestShapeForFormat(t *testing.T) {
+	white := domain.Color{R: 255, G: 255, B: 255, A: 255}
+	red := domain.Color{R: 255, A: 255}
+	transparent := domain.Color{}
+	testCases := []struct {
+		shape    *domain.ShapeOpts
+		format   domain.ImageType
+		expected *domain.ShapeOpts
+	}{
+		{
+			shape:    nil,
+			format:   domain.ImageType_JPEG,
+			expected: nil,
+		},
+		{
+			shape:    &domain.ShapeOpts{Mask: domain.Mask_CIRCLE},
+			format:   domain.ImageType_PNG,
+			expected: &domain.ShapeOpts{Mask: domain.Mask_CIRCLE},
+		},
+		{
+			shape:    &domain.ShapeOpts{Mask: domain.Mask_CIRCLE},
+			format:   domain.ImageType_JPEG,
+			expected: &domain.ShapeOpts{Mask: domain.Mask_CIRCLE, Background: &white},
+		},
+		{
+			shape:    &domain.ShapeOpts{Radius: 10, Background: &transparent},
+			format:   domain.ImageType_JPEG,
+			expected: &domain.ShapeOpts{Radius: 10, Background: &white},
+		},
+		{
+			shape:    &domain.ShapeOpts{Padding: 10, Background: &red},
+			format:   domain.ImageType_JPEG,
+			expected: &domain.ShapeOpts{Padding: 10, Background: &red},
+		},
+		{
+			shape:    &domain.ShapeOpts{Padding: 10, Background: &transparent},
+			format:   domain.ImageType_WEBP,
+			expected: &domain.ShapeOpts{Padding: 10, Background: &transparent},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, shapeForFormat(tc.shape, tc.format))
+	}
+}
+
+func TestWatermarkToApply(t *testing.T) {
+	watermark := &domain.WatermarkConfig{ImageName: "logo", EnforceAboveWidth: 800}
+	testCases := []struct {
+		opts         GetImageOpts
+		tenantConfig domain.TenantConfig
+		targetWidth  int
+		expected     *domain.WatermarkConfig
+	}{
+		{
+			opts:         NewServiceGetImageOpts().SetWatermark(true),
+			tenantConfig: domain.TenantConfig{},
+			targetWidth:  1000,
+			expected:     nil,
+		},
+		{
+			opts:         NewServiceGetImageOpts(),
+			tenantConfig: domain.TenantConfig{Watermark: watermark},
+			targetWidth:  500,
+			expected:     nil,
+		},
+		{
+			opts:         NewServiceGetImageOpts().SetWatermark(true),
+			tenantConfig: domain.TenantConfig{Watermark: watermark},
+			targetWidth:  500,
+			expected:     watermark,
+		},
+		{
+			opts:         NewServiceGetImageOpts().SetWatermark(false),
+			tenantConfig: domain.TenantConfig{Watermark: watermark},
+			targetWidth:  1000,
+			expected:     watermark,
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, watermarkToApply(tc.opts, tc.tenantConfig, tc.targetWidth))
+	}
+}
+
 func TestDetermineDimensions(t *testing.T) {
 	testCases := []struct {
 		opts           GetImageOpts
@@ -219,6 +1990,31 @@ func TestDetermineDimensions(t *testing.T) {
 			expectedWidth:  1080,
 			expectedHeight: 2375,
 		},
+		{
+			opts:           NewServiceGetImageOpts().SetWidth(300).SetHeight(500).SetDpr(2),
+			expectedWidth:  600,
+			expectedHeight: 1000,
+		},
+		{
+			opts:           NewServiceGetImageOpts().SetWidth(300).SetAr(domain.AR{Width: 16, Height: 9}).SetDpr(1.5),
+			expectedWidth:  450,
+			expectedHeight: 253,
+		},
+		{
+			opts:           NewServiceGetImageOpts().SetHeight(270).SetDpr(3),
+			originalWidth:  900,
+			originalHeight: 800,
+			expectedWidth:  911,
+			expectedHeight: 810,
+		},
+		{
+			// the original size is already in physical pixels
+			opts:           NewServiceGetImageOpts().SetDpr(2),
+			originalWidth:  1080,
+			originalHeight: 2375,
+			expectedWidth:  1080,
+			expectedHeight: 2375,
+		},
 	}
 	for _, tc := range testCases {
 		w, h := determineDimensions(tc.opts, tc.originalWidth, tc.originalHeight)
@@ -398,3 +2194,35 @@ func TestParentDimensionsNeeded(t *testing.T) {
 		assert.Equal(t, tc.expected, originalDimensionsNeeded(tc.opts), tc.opts)
 	}
 }
+
+// imageServiceMocks are the dependencies of an image service under test.
+type imageServiceMocks struct {
+	storage      *mock.ImageStorageService
+	processor    *mock.ImageProcessingService
+	tenantConfig *mock.TenantConfigService
+	jobQueue     *mock.JobQueue
+	webhook      *mock.WebhookService
+	remoteFetch  *mock.RemoteFetchService
+	uploadStore  *mock.UploadStore
+	uploadToken  *mock.UploadTokenService
+	urlSigner    *mock.UrlSigner
+}
+
+// newTestImageService returns an image service built on fresh mocks, which
+// expectations can be set on up until the service is called.
+func newTestImageService() (ImageServiceInterface, imageServiceMocks) {
+	mocks := imageServiceMocks{
+		storage:      new(mock.ImageStorageService),
+		processor:    new(mock.ImageProcessingService),
+		tenantConfig: new(mock.TenantConfigService),
+		jobQueue:     new(mock.JobQueue),
+		webhook:      new(mock.WebhookService),
+		remoteFetch:  new(mock.RemoteFetchService),
+		uploadStore:  new(mock.UploadStore),
+		uploadToken:  new(mock.UploadTokenService),
+		urlSigner:    new(mock.UrlSigner),
+	}
+	svc := NewImageService(mocks.storage, mocks.processor, mocks.tenantConfig, mocks.jobQueue, mocks.webhook,
+		mocks.remoteFetch, mocks.uploadStore, mocks.uploadToken, mocks.urlSigner)
+	return svc, mocks
+}
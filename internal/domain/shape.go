@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Mask int
+
+const (
+	Mask_NONE Mask = iota
+	Mask_CIRCLE
+)
+
+func (m Mask) String() string {
+	switch m {
+	case Mask_NONE:
+		return "none"
+	case Mask_CIRCLE:
+		return "circle"
+	default:
+		return "unknown"
+	}
+}
+
+func MaskFromString(maskStr string) (Mask, error) {
+	switch strings.ToLower(maskStr) {
+	case "", "none":
+		return Mask_NONE, nil
+	case "circle":
+		return Mask_CIRCLE, nil
+	default:
+		return -1, fmt.Errorf("unsupported mask: %v", maskStr)
+	}
+}
+
+// ParseBackground parses a hex color or the "transparent" keyword.
+func ParseBackground(str string) (Color, error) {
+	if strings.ToLower(str) == "transparent" {
+		return Color{}, nil
+	}
+	return ParseHexColor(str)
+}
+
+type ShapeOpts struct {
+	// Padding adds a border of the given number of pixels around the image
+	Padding int
+	// Background fills the padding and the masked out areas; nil keeps them transparent
+	Background *Color
+	// Radius rounds the corners by the given number of pixels
+	Radius int
+	Mask   Mask
+}
+
+func (so ShapeOpts) String() string {
+	background := ""
+	if so.Background != nil {
+		background = so.Background.Hex()
+	}
+	return fmt.Sprintf("shape:%d:%s:%d:%s", so.Padding, background, so.Radius, so.Mask)
+}
+
+// SupportsAlpha reports whether the format can carry an alpha channel. GIF only
+// supports fully transparent pixels which is enough for masks and padding.
+func (imgT ImageType) SupportsAlpha() bool {
+	switch imgT {
+	case ImageType_PNG, ImageType_WEBP, ImageType_AVIF, ImageType_GIF:
+		return true
+	default:
+		return false
+	}
+}
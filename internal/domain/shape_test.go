@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseBackground(t *testing.T) {
+	testCases := []struct {
+		str      string
+		expected Color
+		isValid  bool
+	}{
+		{str: "transparent", expected: Color{}, isValid: true},
+		{str: "ffffff", expected: Color{R: 255, G: 255, B: 255, A: 255}, isValid: true},
+		{str: "none", isValid: false},
+	}
+	for _, tc := range testCases {
+		res, err := ParseBackground(tc.str)
+		if !tc.isValid {
+			assert.Error(t, err, tc.str)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, res)
+	}
+}
+
+func TestMaskFromString(t *testing.T) {
+	mask, err := MaskFromString("circle")
+	assert.NoError(t, err)
+	assert.Equal(t, Mask_CIRCLE, mask)
+
+	mask, err = MaskFromString("")
+	assert.NoError(t, err)
+	assert.Equal(t, Mask_NONE, mask)
+
+	_, err = MaskFromString("star")
+	assert.Error(t, err)
+}
@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+type ImageVariant struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Srcset describes a responsive image: every variant is offered in each of the
+// modern Formats, and in the Fallback format for clients supporting none of them.
+type Srcset struct {
+	Variants []ImageVariant `json:"variants"`
+	Formats  []ImageType    `json:"formats"`
+	Fallback ImageType      `json:"fallback"`
+	// Expires and Signature sign the variant urls, they are empty when the
+	// image is public and no signature was presented
+	Expires   time.Time `json:"-"`
+	Signature string    `json:"-"`
+}
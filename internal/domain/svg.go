@@ -0,0 +1,190 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidSvg = errors.New("invalid svg")
+
+// forbiddenSvgElements are dropped along with everything nested in them.
+var forbiddenSvgElements = map[string]struct{}{
+	"script":        {},
+	"foreignobject": {},
+	"iframe":        {},
+	"embed":         {},
+	"object":        {},
+	"audio":         {},
+	"video":         {},
+	"handler":       {},
+	"listener":      {},
+}
+
+// svgAnimationElements can set any attribute of their target, including its
+// href, through their from, to, by and values attributes.
+var svgAnimationElements = map[string]struct{}{
+	"animate":          {},
+	"set":              {},
+	"animatetransform": {},
+	"animatemotion":    {},
+}
+
+// unsafeSvgSchemes are the url schemes which run scripts when followed.
+var unsafeSvgSchemes = []string{"javascript:", "vbscript:", "data:text/html"}
+
+// cssUrlPattern matches css url() references which do not point into the document.
+var cssUrlPattern = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s)]`)
+
+// SanitizeSvg removes the parts of an svg document which can execute scripts or
+// load external resources when it is rendered by a browser: script-like elements,
+// foreignObject, event handler attributes, references to anything but fragments
+// of the document or embedded raster images, external css and all doctype
+// declarations and processing instructions.
+func SanitizeSvg(svg []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Strict = true
+
+	var out bytes.Buffer
+	// RawToken keeps namespace prefixes as written but leaves checking that
+	// elements are well nested to the caller
+	var open []xml.Name
+	// skipDepth counts the open elements of a dropped subtree
+	skipDepth := 0
+	rootSeen := false
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidSvg
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			open = append(open, t.Name)
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			if !rootSeen && strings.ToLower(t.Name.Local) != "svg" {
+				return nil, ErrInvalidSvg
+			}
+			rootSeen = true
+			if _, ok := forbiddenSvgElements[strings.ToLower(t.Name.Local)]; ok || animatesHref(t) {
+				skipDepth = 1
+				continue
+			}
+			writeSvgStartElement(&out, t)
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != t.Name {
+				return nil, ErrInvalidSvg
+			}
+			open = open[:len(open)-1]
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			out.WriteString("</" + qualifiedName(t.Name) + ">")
+		case xml.CharData:
+			if skipDepth > 0 {
+				continue
+			}
+			if cssUrlPattern.Match(t) || bytes.Contains(bytes.ToLower(t), []byte("@import")) {
+				// only style sheets can make use of these, drop the whole rule text
+				continue
+			}
+			_ = xml.EscapeText(&out, t)
+		case xml.Comment, xml.ProcInst, xml.Directive:
+			// comments carry nothing worth keeping, processing instructions can
+			// reference style sheets and doctypes can declare entities
+			continue
+		}
+	}
+	if !rootSeen || len(open) > 0 {
+		return nil, ErrInvalidSvg
+	}
+	return out.Bytes(), nil
+}
+
+func writeSvgStartElement(out *bytes.Buffer, element xml.StartElement) {
+	out.WriteString("<" + qualifiedName(element.Name))
+	for _, attr := range element.Attr {
+		if !isSafeSvgAttr(attr) {
+			continue
+		}
+		out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+		_ = xml.EscapeText(out, []byte(attr.Value))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+}
+
+// animatesHref reports whether the element is an animation of an href, which
+// would bypass the checks of the href attribute itself.
+func animatesHref(element xml.StartElement) bool {
+	if _, ok := svgAnimationElements[strings.ToLower(element.Name.Local)]; !ok {
+		return false
+	}
+	for _, attr := range element.Attr {
+		if strings.ToLower(attr.Name.Local) != "attributename" {
+			continue
+		}
+		_, local, _ := strings.Cut(normalizeSvgValue(attr.Value), ":")
+		if local == "" {
+			local = normalizeSvgValue(attr.Value)
+		}
+		if local == "href" || local == "src" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeSvgValue decodes the entities left in an attribute value, drops the
+// whitespace and control characters browsers ignore in urls and lowercases it,
+// so that e.g. "java&#9;script:" is recognized as a script url.
+func normalizeSvgValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, html.UnescapeString(value))
+}
+
+func isSafeSvgAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	value := normalizeSvgValue(attr.Value)
+	switch {
+	case attr.Name.Space == "xmlns" || (attr.Name.Space == "" && name == "xmlns"):
+		return true
+	case strings.HasPrefix(name, "on"):
+		return false
+	case name == "href" || name == "src":
+		return strings.HasPrefix(value, "#") ||
+			(strings.HasPrefix(value, "data:image/") && !strings.HasPrefix(value, "data:image/svg"))
+	case name == "style":
+		return !cssUrlPattern.MatchString(value) && !strings.Contains(value, "expression(")
+	default:
+		for _, scheme := range unsafeSvgSchemes {
+			if strings.Contains(value, scheme) {
+				return false
+			}
+		}
+		return !cssUrlPattern.MatchString(value)
+	}
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
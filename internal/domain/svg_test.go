@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSanitizeSvg(t *testing.T) {
+	testCases := []struct {
+		name     string
+		svg      string
+		expected string
+	}{
+		{
+			name:     "harmless documents are kept",
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10" fill="url(#g)"/></svg>`,
+			expected: `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10" fill="url(#g)"></rect></svg>`,
+		},
+		{
+			name:     "scripts are removed with their content",
+			svg:      `<svg><script type="text/javascript"><![CDATA[alert(1)]]></script><circle r="1"/></svg>`,
+			expected: `<svg><circle r="1"></circle></svg>`,
+		},
+		{
+			name:     "event handlers are removed",
+			svg:      `<svg onload="alert(1)"><g OnClick="alert(2)" id="a"></g></svg>`,
+			expected: `<svg><g id="a"></g></svg>`,
+		},
+		{
+			name:     "foreign objects are removed",
+			svg:      `<svg><foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><img src="x"/></body></foreignObject></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name: "external references are removed while internal ones are kept",
+			svg: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#icon"/>` +
+				`<image xlink:href="https://evil.example/x.png"/><a href="javascript:alert(1)">x</a></svg>`,
+			expected: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#icon"></use>` +
+				`<image></image><a>x</a></svg>`,
+		},
+		{
+			name:     "external css is removed",
+			svg:      `<svg><style>@import url(https://evil.example/a.css);</style><rect style="fill: url(https://evil.example/p)"/></svg>`,
+			expected: `<svg><style></style><rect></rect></svg>`,
+		},
+		{
+			name:     "doctypes and processing instructions are removed",
+			svg:      `<?xml version="1.0"?><?xml-stylesheet href="https://evil.example/a.css"?><!DOCTYPE svg [<!ENTITY a "b">]><svg></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "script urls hidden by entities and whitespace are removed",
+			svg:      `<svg><a href="java&#9;script:alert(1)">x</a><g data-x=" JAVA&#x0A;SCRIPT:alert(2)" id="a"></g></svg>`,
+			expected: `<svg><a>x</a><g id="a"></g></svg>`,
+		},
+		{
+			name: "animations of references are removed",
+			svg: `<svg><a href="#a"><animate attributeName="href" to="javascript:alert(1)"/>` +
+				`<set attributeName="xlink:href" to="javascript:alert(2)"/><animate attributeName="opacity" to="1"/>x</a></svg>`,
+			expected: `<svg><a href="#a"><animate attributeName="opacity" to="1"></animate>x</a></svg>`,
+		},
+	}
+	for _, tc := range testCases {
+		res, err := SanitizeSvg([]byte(tc.svg))
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, string(res), tc.name)
+	}
+}
+
+func TestSanitizeSvgRejectsInvalidDocuments(t *testing.T) {
+	for _, svg := range []string{``, `<html></html>`, `<svg><g></svg>`} {
+		_, err := SanitizeSvg([]byte(svg))
+		assert.ErrorIs(t, err, ErrInvalidSvg, svg)
+	}
+}
@@ -0,0 +1,24 @@
+package domain
+
+type TenantConfig struct {
+	Watermark *WatermarkConfig `json:"watermark,omitempty"`
+	// Eager lists the variants rendered in the background right after every upload
+	Eager []EagerTransformation `json:"eager,omitempty"`
+	// Webhooks are notified of the events of the tenant's images
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+	// Origin is pulled missing originals from on their first request
+	Origin *OriginConfig `json:"origin,omitempty"`
+	// Visibility is the visibility of the images none was set for
+	Visibility Visibility `json:"visibility,omitempty"`
+	// Quota limits the storage of the tenant's images, nil leaves it unlimited
+	Quota *QuotaConfig `json:"quota,omitempty"`
+}
+
+// EagerTransformation describes a variant the way GetImage is asked for it, so
+// that the rendered variant is the one later requests are served from cache.
+type EagerTransformation struct {
+	Width  int       `json:"width,omitempty"`
+	Height int       `json:"height,omitempty"`
+	Ar     *AR       `json:"ar,omitempty"`
+	Format ImageType `json:"format"`
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidUploadToken = errors.New("invalid upload token")
+	ErrUploadTokenExpired = errors.New("upload token expired")
+)
+
+// UploadToken lets a client without tenant credentials, typically a browser,
+// upload images to the tenant within the constraints of the token.
+type UploadToken struct {
+	Id         string     `json:"id"`
+	TenantOpts TenantOpts `json:"tenant"`
+	// MaxBytes bounds the size of the uploaded image
+	MaxBytes int64 `json:"maxBytes"`
+	// Formats are the image formats accepted, any format is when empty
+	Formats   []ImageType `json:"formats,omitempty"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	// SingleUse tokens are redeemed by their first upload
+	SingleUse bool `json:"singleUse,omitempty"`
+}
+
+func (ut UploadToken) AllowsFormat(format ImageType) bool {
+	return len(ut.Formats) == 0 || slices.Contains(ut.Formats, format)
+}
+
+// SignUploadToken encodes the token as its base64url encoded json payload
+// followed by a dot and the base64url encoded HMAC-SHA256 of the payload.
+func SignUploadToken(secret []byte, token UploadToken) (string, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(uploadTokenMac(secret, encoded)), nil
+}
+
+// ParseUploadToken returns the token signed by SignUploadToken if its signature
+// matches and it has not expired at now.
+func ParseUploadToken(secret []byte, signed string, now time.Time) (UploadToken, error) {
+	encoded, signature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return UploadToken{}, ErrInvalidUploadToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, uploadTokenMac(secret, encoded)) {
+		return UploadToken{}, ErrInvalidUploadToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return UploadToken{}, ErrInvalidUploadToken
+	}
+	var token UploadToken
+	if err = json.Unmarshal(payload, &token); err != nil {
+		return UploadToken{}, ErrInvalidUploadToken
+	}
+	if !now.Before(token.ExpiresAt) {
+		return UploadToken{}, ErrUploadTokenExpired
+	}
+	return token, nil
+}
+
+func uploadTokenMac(secret []byte, encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUploadToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	token := UploadToken{
+		Id:         "token1",
+		TenantOpts: TenantOpts{TenantCode: "tenant", OrgCode: "org"},
+		MaxBytes:   1024,
+		Formats:    []ImageType{ImageType_JPEG, ImageType_PNG},
+		ExpiresAt:  now.Add(time.Minute),
+		SingleUse:  true,
+	}
+	signed, err := SignUploadToken(secret, token)
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+
+	t.Run("a signed token is parsed", func(t *testing.T) {
+		parsed, err := ParseUploadToken(secret, signed, now)
+
+		assert.NoError(t, err)
+		assert.Equal(t, token, parsed)
+		assert.True(t, parsed.AllowsFormat(ImageType_PNG))
+		assert.False(t, parsed.AllowsFormat(ImageType_GIF))
+	})
+
+	t.Run("an expired token is refused", func(t *testing.T) {
+		_, err := ParseUploadToken(secret, signed, now.Add(time.Minute))
+
+		assert.ErrorIs(t, err, ErrUploadTokenExpired)
+	})
+
+	t.Run("a tampered token is refused", func(t *testing.T) {
+		other, _ := SignUploadToken(secret, UploadToken{Id: "token2", MaxBytes: 1 << 30, ExpiresAt: now.Add(time.Hour)})
+		payload, _, _ := strings.Cut(other, ".")
+		_, signature, _ := strings.Cut(signed, ".")
+
+		for _, raw := range []string{payload + "." + signature, signed + "x", "", "garbage"} {
+			_, err := ParseUploadToken(secret, raw, now)
+			assert.ErrorIs(t, err, ErrInvalidUploadToken, raw)
+		}
+		_, err := ParseUploadToken([]byte("other secret"), signed, now)
+		assert.ErrorIs(t, err, ErrInvalidUploadToken)
+	})
+}
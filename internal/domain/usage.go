@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+	ErrObjectQuotaExceeded  = errors.New("object quota exceeded")
+)
+
+// UsageCount is the number of stored objects and their size in bytes.
+type UsageCount struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (uc UsageCount) Add(other UsageCount) UsageCount {
+	return UsageCount{Objects: uc.Objects + other.Objects, Bytes: uc.Bytes + other.Bytes}
+}
+
+func (uc UsageCount) Sub(other UsageCount) UsageCount {
+	return UsageCount{Objects: uc.Objects - other.Objects, Bytes: uc.Bytes - other.Bytes}
+}
+
+// Usage is the storage used by a tenant. Originals are the parent images,
+// derivatives the child images rendered from them. Attachments and settings
+// are small enough to be left out.
+type Usage struct {
+	Originals   UsageCount `json:"originals"`
+	Derivatives UsageCount `json:"derivatives"`
+}
+
+func (u Usage) Total() UsageCount {
+	return u.Originals.Add(u.Derivatives)
+}
+
+// QuotaConfig limits the storage of a tenant, a zero limit is no limit.
+type QuotaConfig struct {
+	MaxBytes   int64 `json:"maxBytes,omitempty"`
+	MaxObjects int64 `json:"maxObjects,omitempty"`
+	// EvictDerivatives leaves derivatives out of the quota, they are evicted
+	// to make room for originals instead since they are rendered again on
+	// their next request
+	EvictDerivatives bool `json:"evictDerivatives,omitempty"`
+}
+
+// Counted returns the part of the usage the quota applies to.
+func (qc QuotaConfig) Counted(usage Usage) UsageCount {
+	if qc.EvictDerivatives {
+		return usage.Originals
+	}
+	return usage.Total()
+}
+
+// Check returns an error if storing an object of size bytes would take the
+// usage past the quota.
+func (qc QuotaConfig) Check(usage Usage, size int64) error {
+	counted := qc.Counted(usage)
+	if qc.MaxObjects > 0 && counted.Objects+1 > qc.MaxObjects {
+		return ErrObjectQuotaExceeded
+	}
+	if qc.MaxBytes > 0 && counted.Bytes+size > qc.MaxBytes {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
+
+// CheckDerivative returns an error if caching a derivative of size bytes would
+// take the usage past the quota. Quotas leaving derivatives out only refuse a
+// derivative that would not fit with every other derivative evicted.
+func (qc QuotaConfig) CheckDerivative(usage Usage, size int64) error {
+	if !qc.EvictDerivatives {
+		return qc.Check(usage, size)
+	}
+	if qc.MaxBytes > 0 && usage.Originals.Bytes+size > qc.MaxBytes {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
+
+// Overflow returns how many bytes of derivatives must be evicted to store an
+// object of size bytes, once derivatives are left out of the quota.
+func (qc QuotaConfig) Overflow(usage Usage, size int64) int64 {
+	if !qc.EvictDerivatives || qc.MaxBytes == 0 {
+		return 0
+	}
+	return max(usage.Total().Bytes+size-qc.MaxBytes, 0)
+}
+
+// UsageSnapshot is the usage of a tenant at a point in time, as exported for
+// billing.
+type UsageSnapshot struct {
+	TenantOpts TenantOpts   `json:"tenant"`
+	TakenAt    time.Time    `json:"takenAt"`
+	Usage      Usage        `json:"usage"`
+	Quota      *QuotaConfig `json:"quota,omitempty"`
+}
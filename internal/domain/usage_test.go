@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	usage := Usage{
+		Originals:   UsageCount{Objects: 2, Bytes: 600},
+		Derivatives: UsageCount{Objects: 6, Bytes: 300},
+	}
+	testCases := []struct {
+		name             string
+		quota            QuotaConfig
+		size             int64
+		expectedErr      error
+		expectedOverflow int64
+	}{
+		{name: "no limits", quota: QuotaConfig{}, size: 1 << 30},
+		{name: "within the byte limit", quota: QuotaConfig{MaxBytes: 1000}, size: 100},
+		{name: "past the byte limit", quota: QuotaConfig{MaxBytes: 1000}, size: 101, expectedErr: ErrStorageQuotaExceeded},
+		{name: "past the object limit", quota: QuotaConfig{MaxObjects: 8}, size: 1, expectedErr: ErrObjectQuotaExceeded},
+		{name: "derivatives left out of the object limit", quota: QuotaConfig{MaxObjects: 3, EvictDerivatives: true},
+			size: 1},
+		{name: "derivatives evicted to make room", quota: QuotaConfig{MaxBytes: 1000, EvictDerivatives: true},
+			size: 250, expectedOverflow: 150},
+		{name: "originals alone past the byte limit", quota: QuotaConfig{MaxBytes: 1000, EvictDerivatives: true},
+			size: 401, expectedErr: ErrStorageQuotaExceeded},
+	}
+	for _, tc := range testCases {
+		err := tc.quota.Check(usage, tc.size)
+
+		if tc.expectedErr != nil {
+			assert.ErrorIs(t, err, tc.expectedErr, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expectedOverflow, tc.quota.Overflow(usage, tc.size), tc.name)
+	}
+}
+
+func TestQuotaCheckDerivative(t *testing.T) {
+	usage := Usage{
+		Originals:   UsageCount{Objects: 2, Bytes: 600},
+		Derivatives: UsageCount{Objects: 6, Bytes: 300},
+	}
+	testCases := []struct {
+		name        string
+		quota       QuotaConfig
+		size        int64
+		expectedErr error
+	}{
+		{name: "within the byte limit", quota: QuotaConfig{MaxBytes: 1000}, size: 100},
+		{name: "past the byte limit", quota: QuotaConfig{MaxBytes: 1000}, size: 101, expectedErr: ErrStorageQuotaExceeded},
+		{name: "past the object limit", quota: QuotaConfig{MaxObjects: 8}, size: 1, expectedErr: ErrObjectQuotaExceeded},
+		{name: "evicted derivatives left out of the object limit", quota: QuotaConfig{MaxObjects: 2, EvictDerivatives: true},
+			size: 1},
+		{name: "fits once derivatives are evicted", quota: QuotaConfig{MaxBytes: 1000, EvictDerivatives: true}, size: 400},
+		{name: "past the byte limit with derivatives evicted", quota: QuotaConfig{MaxBytes: 1000, EvictDerivatives: true},
+			size: 401, expectedErr: ErrStorageQuotaExceeded},
+	}
+	for _, tc := range testCases {
+		err := tc.quota.CheckDerivative(usage, tc.size)
+
+		if tc.expectedErr != nil {
+			assert.ErrorIs(t, err, tc.expectedErr, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+	}
+}
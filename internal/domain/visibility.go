@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Visibility decides who a parent image and its child images are served to.
+type Visibility int
+
+const (
+	// Visibility_PUBLIC images are served to anyone
+	Visibility_PUBLIC Visibility = iota
+	// Visibility_PRIVATE images are served to principals of their tenant and
+	// through signed urls
+	Visibility_PRIVATE
+	// Visibility_SIGNED images are only served through signed urls
+	Visibility_SIGNED
+)
+
+func (v Visibility) String() string {
+	switch v {
+	case Visibility_PUBLIC:
+		return "public"
+	case Visibility_PRIVATE:
+		return "private"
+	case Visibility_SIGNED:
+		return "signed"
+	default:
+		return "unknown"
+	}
+}
+
+func VisibilityFromString(visibilityStr string) (Visibility, error) {
+	switch strings.ToLower(visibilityStr) {
+	case "public":
+		return Visibility_PUBLIC, nil
+	case "private":
+		return Visibility_PRIVATE, nil
+	case "signed":
+		return Visibility_SIGNED, nil
+	default:
+		return -1, fmt.Errorf("unsupported visibility: %v", visibilityStr)
+	}
+}
+
+func (v Visibility) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v *Visibility) UnmarshalText(text []byte) error {
+	visibility, err := VisibilityFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*v = visibility
+	return nil
+}
+
+// Access is what a request for an image presents to be served it.
+type Access struct {
+	// Principal is the authenticated caller, nil for anonymous requests
+	Principal *Principal
+	// Expires and Signature are the query parameters of a signed url
+	Expires   time.Time
+	Signature string
+}
+
+// SignImageUrl returns the hex encoded HMAC-SHA256 granting access to the image
+// until expiresAt. It covers neither the path nor the other query parameters,
+// so the holder of a signed url can request any variant of the image.
+func SignImageUrl(secret []byte, name string, tenantOpts TenantOpts, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, secret)
+	// the fields are joined by a character none of them can contain
+	mac.Write([]byte(strings.Join([]string{
+		tenantOpts.TenantCode,
+		tenantOpts.OrgCode,
+		name,
+		strconv.FormatInt(expiresAt.Unix(), 10),
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyImageUrl reports whether the signature was made by SignImageUrl for the
+// image and has not expired at now.
+func VerifyImageUrl(secret []byte, name string, tenantOpts TenantOpts, expiresAt time.Time, signature string, now time.Time) bool {
+	if !now.Before(expiresAt) {
+		return false
+	}
+	expected := SignImageUrl(secret, name, tenantOpts, expiresAt)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVisibilityFromString(t *testing.T) {
+	for _, visibility := range []Visibility{Visibility_PUBLIC, Visibility_PRIVATE, Visibility_SIGNED} {
+		res, err := VisibilityFromString(visibility.String())
+		assert.NoError(t, err)
+		assert.Equal(t, visibility, res)
+	}
+	_, err := VisibilityFromString("hidden")
+	assert.Error(t, err)
+}
+
+func TestVerifyImageUrl(t *testing.T) {
+	secret := []byte("secret")
+	tenantOpts := TenantOpts{TenantCode: "tenant", OrgCode: "org"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+	signature := SignImageUrl(secret, "cat.jpg", tenantOpts, expiresAt)
+
+	assert.True(t, VerifyImageUrl(secret, "cat.jpg", tenantOpts, expiresAt, signature, now))
+	// expired
+	assert.False(t, VerifyImageUrl(secret, "cat.jpg", tenantOpts, expiresAt, signature, expiresAt))
+	// another image, tenant, expiry or secret
+	assert.False(t, VerifyImageUrl(secret, "dog.jpg", tenantOpts, expiresAt, signature, now))
+	assert.False(t, VerifyImageUrl(secret, "cat.jpg", TenantOpts{TenantCode: "tenant", OrgCode: "other"}, expiresAt, signature, now))
+	assert.False(t, VerifyImageUrl(secret, "cat.jpg", tenantOpts, expiresAt.Add(time.Hour), signature, now))
+	assert.False(t, VerifyImageUrl([]byte("other"), "cat.jpg", tenantOpts, expiresAt, signature, now))
+	assert.False(t, VerifyImageUrl(secret, "cat.jpg", tenantOpts, expiresAt, "", now))
+}
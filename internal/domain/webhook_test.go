@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	signature := SignWebhookPayload("secret", timestamp, []byte(`{"id":"event1"}`))
+
+	assert.Equal(t, "be034da71cabbef692de471285867fc452fec69cf9a9096057259799b1b113ea", signature)
+	assert.NotEqual(t, signature, SignWebhookPayload("secret", timestamp.Add(time.Second), []byte(`{"id":"event1"}`)))
+}
+
+func TestWebhookSubscribed(t *testing.T) {
+	testCases := []struct {
+		events    []EventType
+		eventType EventType
+		expected  bool
+	}{
+		{events: nil, eventType: EventType_VARIANT_GENERATED, expected: true},
+		{events: []EventType{EventType_IMAGE_UPLOADED}, eventType: EventType_IMAGE_UPLOADED, expected: true},
+		{events: []EventType{EventType_IMAGE_UPLOADED}, eventType: EventType_IMAGE_DELETED, expected: false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, WebhookConfig{Url: "http://cms", Events: tc.events}.Subscribed(tc.eventType))
+	}
+}
+
+func TestEventTypeText(t *testing.T) {
+	var eventType EventType
+	assert.NoError(t, eventType.UnmarshalText([]byte("image.deleted")))
+	assert.Equal(t, EventType_IMAGE_DELETED, eventType)
+	assert.Error(t, eventType.UnmarshalText([]byte("image.renamed")))
+}
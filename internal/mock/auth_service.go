@@ -0,0 +1,20 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type AuthService struct {
+	mock.Mock
+}
+
+func (m *AuthService) AuthenticateApiKey(key string) (domain.Principal, error) {
+	args := m.Called(key)
+	return args.Get(0).(domain.Principal), args.Error(1)
+}
+
+func (m *AuthService) AuthenticateJwt(token string) (domain.Principal, error) {
+	args := m.Called(token)
+	return args.Get(0).(domain.Principal), args.Error(1)
+}
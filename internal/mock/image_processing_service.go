@@ -3,6 +3,7 @@ package mock
 import (
 	"example.com/imageProc/internal/domain"
 	"github.com/stretchr/testify/mock"
+	goimage "image"
 )
 
 type ImageProcessingService struct {
@@ -43,3 +44,53 @@ func (m *ImageProcessingService) Export(image []byte, imageType domain.ImageType
 	args := m.Called(image, imageType)
 	return args.Get(0).([]byte), args.Error(1)
 }
+
+func (m *ImageProcessingService) Overlay(image []byte, overlay []byte, opts domain.OverlayOpts) ([]byte, error) {
+	args := m.Called(image, overlay, opts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageProcessingService) DrawText(image []byte, opts domain.TextOpts) ([]byte, error) {
+	args := m.Called(image, opts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageProcessingService) Shape(image []byte, opts domain.ShapeOpts) ([]byte, error) {
+	args := m.Called(image, opts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageProcessingService) ExtractFrame(image []byte, frame int) ([]byte, error) {
+	args := m.Called(image, frame)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageProcessingService) Rasterize(image []byte, page int, density int) ([]byte, error) {
+	args := m.Called(image, page, density)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageProcessingService) GetPixels(image []byte) (*goimage.NRGBA, error) {
+	args := m.Called(image)
+	return args.Get(0).(*goimage.NRGBA), args.Error(1)
+}
+
+func (m *ImageProcessingService) AnalyzeColors(image []byte, count int) (domain.ColorAnalysis, error) {
+	args := m.Called(image, count)
+	return args.Get(0).(domain.ColorAnalysis), args.Error(1)
+}
+
+func (m *ImageProcessingService) PerceptualHash(image []byte) (domain.PerceptualHash, error) {
+	args := m.Called(image)
+	return args.Get(0).(domain.PerceptualHash), args.Error(1)
+}
+
+func (m *ImageProcessingService) GetMetadata(image []byte) (domain.ImageMetadata, error) {
+	args := m.Called(image)
+	return args.Get(0).(domain.ImageMetadata), args.Error(1)
+}
+
+func (m *ImageProcessingService) Compress(image []byte, imageType domain.ImageType, maxBytes int) ([]byte, domain.Compression, error) {
+	args := m.Called(image, imageType, maxBytes)
+	return args.Get(0).([]byte), args.Get(1).(domain.Compression), args.Error(2)
+}
@@ -14,6 +14,11 @@ func (m *ImageStorageService) StoreParentImage(image []byte, format domain.Image
 	return args.String(0), args.Error(1)
 }
 
+func (m *ImageStorageService) StoreNamedParentImage(name string, image []byte, format domain.ImageType, tenantOpts domain.TenantOpts) error {
+	args := m.Called(name, image, format, tenantOpts)
+	return args.Error(0)
+}
+
 func (m *ImageStorageService) StoreChildImage(image []byte, name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) error {
 	args := m.Called(image, name, spec, tenantOpts)
 	return args.Error(0)
@@ -24,7 +29,62 @@ func (m *ImageStorageService) GetParentImage(name string, tenantOpts domain.Tena
 	return args.Get(0).([]byte), args.Error(1)
 }
 
-func (m *ImageStorageService) GetChildImage(name string, format domain.ImageType, width, height int, tenantOpts domain.TenantOpts) ([]byte, error) {
-	args := m.Called(name, format, width, height, tenantOpts)
+func (m *ImageStorageService) GetChildImage(name string, spec domain.ImageSpec, tenantOpts domain.TenantOpts) ([]byte, error) {
+	args := m.Called(name, spec, tenantOpts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageStorageService) StoreParentSetting(name, key string, data []byte, tenantOpts domain.TenantOpts) error {
+	args := m.Called(name, key, data, tenantOpts)
+	return args.Error(0)
+}
+
+func (m *ImageStorageService) GetParentSetting(name, key string, tenantOpts domain.TenantOpts) ([]byte, error) {
+	args := m.Called(name, key, tenantOpts)
 	return args.Get(0).([]byte), args.Error(1)
 }
+
+func (m *ImageStorageService) StoreParentAttachment(name, key string, data []byte, tenantOpts domain.TenantOpts) error {
+	args := m.Called(name, key, data, tenantOpts)
+	return args.Error(0)
+}
+
+func (m *ImageStorageService) GetParentAttachment(name, key string, tenantOpts domain.TenantOpts) ([]byte, error) {
+	args := m.Called(name, key, tenantOpts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *ImageStorageService) ListParentImages(tenantOpts domain.TenantOpts) ([]string, error) {
+	args := m.Called(tenantOpts)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *ImageStorageService) DeleteParentImage(name string, tenantOpts domain.TenantOpts) error {
+	args := m.Called(name, tenantOpts)
+	return args.Error(0)
+}
+
+func (m *ImageStorageService) DeleteDerivedImages(name string, tenantOpts domain.TenantOpts) error {
+	args := m.Called(name, tenantOpts)
+	return args.Error(0)
+}
+
+func (m *ImageStorageService) DeleteChildImages(name string, tenantOpts domain.TenantOpts) (domain.UsageCount, error) {
+	args := m.Called(name, tenantOpts)
+	return args.Get(0).(domain.UsageCount), args.Error(1)
+}
+
+func (m *ImageStorageService) Usage(tenantOpts domain.TenantOpts) (domain.Usage, error) {
+	args := m.Called(tenantOpts)
+	return args.Get(0).(domain.Usage), args.Error(1)
+}
+
+func (m *ImageStorageService) RecountUsage(tenantOpts domain.TenantOpts) (domain.Usage, error) {
+	args := m.Called(tenantOpts)
+	return args.Get(0).(domain.Usage), args.Error(1)
+}
+
+func (m *ImageStorageService) ListTenants() ([]domain.TenantOpts, error) {
+	args := m.Called()
+	return args.Get(0).([]domain.TenantOpts), args.Error(1)
+}
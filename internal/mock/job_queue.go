@@ -0,0 +1,45 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type JobQueue struct {
+	mock.Mock
+}
+
+func (m *JobQueue) Enqueue(job domain.Job) (domain.Job, error) {
+	args := m.Called(job)
+	return args.Get(0).(domain.Job), args.Error(1)
+}
+
+func (m *JobQueue) GetJob(id string) (domain.Job, error) {
+	args := m.Called(id)
+	return args.Get(0).(domain.Job), args.Error(1)
+}
+
+func (m *JobQueue) Claim() (domain.Job, error) {
+	args := m.Called()
+	return args.Get(0).(domain.Job), args.Error(1)
+}
+
+func (m *JobQueue) Complete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *JobQueue) Fail(id string, jobErr error) error {
+	args := m.Called(id, jobErr)
+	return args.Error(0)
+}
+
+func (m *JobQueue) DeadLetters() ([]domain.Job, error) {
+	args := m.Called()
+	return args.Get(0).([]domain.Job), args.Error(1)
+}
+
+func (m *JobQueue) DeleteExpired() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
@@ -0,0 +1,15 @@
+package mock
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+)
+
+type RemoteFetchService struct {
+	mock.Mock
+}
+
+func (m *RemoteFetchService) Fetch(ctx context.Context, rawUrl string) ([]byte, error) {
+	args := m.Called(ctx, rawUrl)
+	return args.Get(0).([]byte), args.Error(1)
+}
@@ -0,0 +1,15 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type TenantConfigService struct {
+	mock.Mock
+}
+
+func (m *TenantConfigService) GetTenantConfig(tenantOpts domain.TenantOpts) (domain.TenantConfig, error) {
+	args := m.Called(tenantOpts)
+	return args.Get(0).(domain.TenantConfig), args.Error(1)
+}
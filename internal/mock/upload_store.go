@@ -0,0 +1,50 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+	"io"
+)
+
+type UploadStore struct {
+	mock.Mock
+}
+
+func (m *UploadStore) Create(upload domain.ResumableUpload) (domain.ResumableUpload, error) {
+	args := m.Called(upload)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *UploadStore) Get(id string) (domain.ResumableUpload, error) {
+	args := m.Called(id)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *UploadStore) Append(id string, offset int64, chunk io.Reader) (domain.ResumableUpload, error) {
+	args := m.Called(id, offset, chunk)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *UploadStore) Read(id string) ([]byte, error) {
+	args := m.Called(id)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *UploadStore) Complete(id string, imageName string) (domain.ResumableUpload, error) {
+	args := m.Called(id, imageName)
+	return args.Get(0).(domain.ResumableUpload), args.Error(1)
+}
+
+func (m *UploadStore) Release(id string) {
+	m.Called(id)
+}
+
+func (m *UploadStore) Delete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *UploadStore) DeleteExpired() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
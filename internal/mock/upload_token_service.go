@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type UploadTokenService struct {
+	mock.Mock
+}
+
+func (m *UploadTokenService) Issue(token domain.UploadToken) (domain.UploadToken, string, error) {
+	args := m.Called(token)
+	return args.Get(0).(domain.UploadToken), args.String(1), args.Error(2)
+}
+
+func (m *UploadTokenService) Verify(signed string) (domain.UploadToken, error) {
+	args := m.Called(signed)
+	return args.Get(0).(domain.UploadToken), args.Error(1)
+}
+
+func (m *UploadTokenService) Redeem(token domain.UploadToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *UploadTokenService) Release(token domain.UploadToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *UploadTokenService) DeleteExpired() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
@@ -0,0 +1,21 @@
+package mock
+
+import (
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+	"time"
+)
+
+type UrlSigner struct {
+	mock.Mock
+}
+
+func (m *UrlSigner) Sign(name string, tenantOpts domain.TenantOpts, expiresAt time.Time) string {
+	args := m.Called(name, tenantOpts, expiresAt)
+	return args.String(0)
+}
+
+func (m *UrlSigner) Verify(name string, tenantOpts domain.TenantOpts, expiresAt time.Time, signature string) bool {
+	args := m.Called(name, tenantOpts, expiresAt, signature)
+	return args.Bool(0)
+}
@@ -0,0 +1,21 @@
+package mock
+
+import (
+	"context"
+	"example.com/imageProc/internal/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type WebhookService struct {
+	mock.Mock
+}
+
+func (m *WebhookService) Deliver(ctx context.Context, webhook domain.WebhookConfig, event domain.Event, attempt int) error {
+	args := m.Called(ctx, webhook, event, attempt)
+	return args.Error(0)
+}
+
+func (m *WebhookService) Deliveries(tenantOpts domain.TenantOpts, limit int) ([]domain.WebhookDelivery, error) {
+	args := m.Called(tenantOpts, limit)
+	return args.Get(0).([]domain.WebhookDelivery), args.Error(1)
+}